@@ -0,0 +1,45 @@
+package onset
+
+import (
+	"runtime"
+	"sync"
+)
+
+// AnalyzeSlicesBatch analyzes several WAV files concurrently with a bounded pool
+// of concurrency workers (the number of CPUs if concurrency <= 0).
+// Results and errors are returned in the same order as paths; for each file
+// exactly one of them is non-nil. Every analysis creates its own detectors,
+// so no mutable state is shared between workers.
+func AnalyzeSlicesBatch(paths []string, options SliceAnalyzerOptions, concurrency int) ([]*SliceAnalyzerResult, []error) {
+	results := make([]*SliceAnalyzerResult, len(paths))
+	errs := make([]error, len(paths))
+
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(paths) {
+		concurrency = len(paths)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = AnalyzeSlices(paths[i], options)
+			}
+		}()
+	}
+
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	return results, errs
+}
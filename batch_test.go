@@ -0,0 +1,56 @@
+package onset
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyzeSlicesBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	clicksFile := filepath.Join(dir, "clicks.wav")
+	writeTestWav(t, clicksFile, clickTrack(44100, 2.0, []float64{0.2, 0.7, 1.2, 1.7}, 0.8), 44100, 1)
+
+	paths := []string{"amen.wav", clicksFile, "nonexistent.wav", "amen.wav"}
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+
+	results, errs := AnalyzeSlicesBatch(paths, options, 2)
+
+	if len(results) != len(paths) || len(errs) != len(paths) {
+		t.Fatalf("Expected %d results and errors, got %d and %d", len(paths), len(results), len(errs))
+	}
+
+	for i, path := range paths {
+		if path == "nonexistent.wav" {
+			if errs[i] == nil || results[i] != nil {
+				t.Errorf("Expected an error and no result for %s", path)
+			}
+			continue
+		}
+
+		if errs[i] != nil {
+			t.Fatalf("AnalyzeSlicesBatch failed for %s: %v", path, errs[i])
+		}
+
+		// Each result must match a direct analysis of the same file
+		expected, err := AnalyzeSlices(path, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSlices failed for %s: %v", path, err)
+		}
+
+		if len(results[i].Onsets) != len(expected.Onsets) {
+			t.Fatalf("Expected %d onsets for %s, got %d", len(expected.Onsets), path, len(results[i].Onsets))
+		}
+
+		for j := range expected.Onsets {
+			if results[i].Onsets[j] != expected.Onsets[j] {
+				t.Errorf("Onset %d of %s differs: got %f, expected %f", j, path, results[i].Onsets[j], expected.Onsets[j])
+			}
+		}
+	}
+
+	if len(results[1].Onsets) != 4 {
+		t.Errorf("Expected 4 onsets in the click track, got %d: %v", len(results[1].Onsets), results[1].Onsets)
+	}
+}
@@ -0,0 +1,79 @@
+package onset
+
+import "math"
+
+// clipMinRun is the number of consecutive equal samples near the peak that
+// make a clipped run
+const clipMinRun = 3
+
+// clipLevelRatio is how close to the peak of the samples, as a ratio of it, a
+// clipped run lies
+const clipLevelRatio = 0.99
+
+// clipGuardMs is the length of the audio before an onset that ClipAware checks
+// for clipping
+const clipGuardMs = 5.0
+
+// clippedSamples marks the samples in clipped runs, the flat tops of a
+// waveform railed at full scale: at least clipMinRun consecutive samples with
+// the same value, within 1% of the peak of the samples. Peak rather than a
+// fixed full scale is used since the scale of decoded samples depends on the
+// bit depth.
+func clippedSamples(samples []float64) []bool {
+	clipped := make([]bool, len(samples))
+
+	peak := 0.0
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak == 0 {
+		return clipped
+	}
+
+	level := clipLevelRatio * peak
+	runStart := 0
+	for i := 1; i <= len(samples); i++ {
+		if i < len(samples) && samples[i] == samples[runStart] {
+			continue
+		}
+		if i-runStart >= clipMinRun && math.Abs(samples[runStart]) >= level {
+			for k := runStart; k < i; k++ {
+				clipped[k] = true
+			}
+		}
+		runStart = i
+	}
+
+	return clipped
+}
+
+// dropClippedOnsets drops the onsets whose preceding clipGuardMs are mostly
+// clipped: inside a railed waveform the detector takes the edges of the flat
+// tops for onsets, and a new attack cannot be told apart from them. It
+// returns the kept onsets with their strengths and the dropped onsets.
+func dropClippedOnsets(clipped []bool, sampleRate uint, onsets, strengths []float64) ([]float64, []float64, []float64) {
+	guard := max(int(clipGuardMs*float64(sampleRate)/1000.0), 1)
+
+	kept := []float64{}
+	keptStrengths := []float64{}
+	var dropped []float64
+	for i, onsetTime := range onsets {
+		end := min(max(Round(onsetTime*float64(sampleRate)), 0), len(clipped))
+		start := max(end-guard, 0)
+		count := 0
+		for _, c := range clipped[start:end] {
+			if c {
+				count++
+			}
+		}
+
+		if end > start && 2*count > end-start {
+			dropped = append(dropped, onsetTime)
+		} else {
+			kept = append(kept, onsetTime)
+			keptStrengths = append(keptStrengths, strengths[i])
+		}
+	}
+
+	return kept, keptStrengths, dropped
+}
@@ -0,0 +1,52 @@
+package onset
+
+import "math"
+
+// decimateCutoff is the cutoff of the anti-aliasing filter relative to the
+// Nyquist frequency of the decimated rate, leaving room for the roll-off
+const decimateCutoff = 0.8
+
+// butterworthQ are the quality factors of the four biquad sections of an
+// 8th-order Butterworth low-pass filter
+var butterworthQ = []float64{0.50979558, 0.60134489, 0.89997622, 2.56291545}
+
+// Decimate reduces the sample rate of the samples by an integer factor, e.g.
+// for a quick coarse scan of a long file. The samples are low-pass filtered
+// below the Nyquist frequency of the new rate before every factor-th sample is
+// kept, so high frequencies are attenuated instead of aliasing down and
+// smearing transients. The filter, a chain of biquads, runs forward and
+// backward so onsets are not shifted. It returns the decimated samples and
+// their sample rate; a factor of 1 or less returns a copy at the same rate.
+func Decimate(samples []float64, factor int, sampleRate uint) ([]float64, uint) {
+	if factor <= 1 || sampleRate == 0 {
+		return append([]float64{}, samples...), sampleRate
+	}
+
+	filtered := NewFvec(uint(len(samples)))
+	copy(filtered.Data, samples)
+	tmp := NewFvec(filtered.Length)
+
+	cutoff := decimateCutoff * float64(sampleRate) / float64(2*factor)
+	for _, q := range butterworthQ {
+		lowpassBiquad(cutoff, q, sampleRate).DoFiltFilt(filtered, tmp)
+	}
+
+	decimated := make([]float64, 0, (len(samples)+factor-1)/factor)
+	for i := 0; i < len(samples); i += factor {
+		decimated = append(decimated, filtered.Data[i])
+	}
+
+	return decimated, sampleRate / uint(factor)
+}
+
+// lowpassBiquad returns a second-order low-pass filter with the given cutoff
+// frequency and quality factor (RBJ audio EQ cookbook)
+func lowpassBiquad(cutoffHz, q float64, sampleRate uint) *Filter {
+	w0 := 2 * math.Pi * cutoffHz / float64(sampleRate)
+	cosW0 := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+	a0 := 1 + alpha
+
+	b0 := (1 - cosW0) / 2 / a0
+	return NewBiquadFilter(b0, 2*b0, b0, -2*cosW0/a0, (1-alpha)/a0)
+}
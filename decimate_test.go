@@ -0,0 +1,68 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecimate(t *testing.T) {
+	sampleRate := uint(44100)
+	tone := func(freq float64) []float64 {
+		samples := make([]float64, sampleRate)
+		for i := range samples {
+			samples[i] = math.Sin(2 * math.Pi * freq * float64(i) / float64(sampleRate))
+		}
+		return samples
+	}
+	// RMS away from the edges, where the filter settles
+	rms := func(samples []float64) float64 {
+		edge := len(samples) / 10
+		sum := 0.0
+		for _, v := range samples[edge : len(samples)-edge] {
+			sum += v * v
+		}
+		return math.Sqrt(sum / float64(len(samples)-2*edge))
+	}
+
+	// 10 kHz is above the 5.5 kHz Nyquist frequency of 11.025 kHz: dropping
+	// samples would alias it to 1025 Hz at full level
+	decimated, rate := Decimate(tone(10000), 4, sampleRate)
+	if rate != 11025 {
+		t.Fatalf("Expected 11025 Hz, got %d", rate)
+	}
+	if len(decimated) != int(sampleRate)/4 {
+		t.Fatalf("Expected %d samples, got %d", sampleRate/4, len(decimated))
+	}
+	if level := rms(decimated); level > 0.001 {
+		t.Errorf("Expected the 10 kHz tone to be attenuated, got RMS %f", level)
+	}
+
+	// A tone well below the new Nyquist frequency passes unchanged
+	decimated, _ = Decimate(tone(1000), 4, sampleRate)
+	if level := rms(decimated); math.Abs(level-math.Sqrt(0.5)) > 0.01 {
+		t.Errorf("Expected the 1 kHz tone to pass at RMS %f, got %f", math.Sqrt(0.5), level)
+	}
+
+	// A factor of 1 returns the samples unchanged
+	samples := tone(1000)
+	same, rate := Decimate(samples, 1, sampleRate)
+	if rate != sampleRate || len(same) != len(samples) || same[10] != samples[10] {
+		t.Error("Expected factor 1 to return the samples at the same rate")
+	}
+}
+
+func TestDecimateKeepsOnsets(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 1.0, []float64{0.25, 0.5, 0.75}, 0.8)
+
+	decimated, rate := Decimate(samples, 4, sampleRate)
+	times, _ := detectOnsetsWithStrength(decimated, rate, relaxedDetector("hfc", SliceAnalyzerOptions{}))
+	if len(times) != 3 {
+		t.Fatalf("Expected 3 onsets after decimation, got %d: %v", len(times), times)
+	}
+	for i, expected := range []float64{0.25, 0.5, 0.75} {
+		if math.Abs(times[i]-expected) > 0.02 {
+			t.Errorf("Onset %d: expected %.3fs, got %.3fs", i, expected, times[i])
+		}
+	}
+}
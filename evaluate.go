@@ -0,0 +1,104 @@
+package onset
+
+import (
+	"math"
+	"sort"
+)
+
+// EvaluateOnsets compares detected onsets with ground truth onsets (both in
+// seconds) and returns the precision (fraction of detected onsets that match
+// a ground truth onset), the recall (fraction of ground truth onsets that are
+// detected) and their harmonic mean, the F-measure. Onsets match when they are
+// at most toleranceSec apart (0.05 is the usual MIREX tolerance); each onset
+// matches at most once, pairing the closest onsets first. Metrics without any
+// onsets to divide by are 0.
+func EvaluateOnsets(detected, groundTruth []float64, toleranceSec float64) (precision, recall, f1 float64) {
+	matched := len(MatchOnsets(detected, groundTruth, toleranceSec))
+
+	if len(detected) > 0 {
+		precision = float64(matched) / float64(len(detected))
+	}
+	if len(groundTruth) > 0 {
+		recall = float64(matched) / float64(len(groundTruth))
+	}
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
+
+// ValidateOnsets checks candidate slice points (in seconds), e.g. of a
+// pre-sliced pack, against the onsets of the samples: a candidate is valid
+// when the dense detection pass of AnalyzeSamples, with the "hfc" method,
+// finds an onset at most toleranceMs away. Several candidates may validate
+// against the same onset.
+func ValidateOnsets(samples []float64, sampleRate uint, candidates []float64, toleranceMs float64) []bool {
+	valid := make([]bool, len(candidates))
+	if sampleRate == 0 || len(candidates) == 0 || isConstant(samples) {
+		return valid
+	}
+
+	detected, _ := findAllOnsets(samples, sampleRate, relaxedDetector("hfc", SliceAnalyzerOptions{}))
+	if len(detected) == 0 {
+		return valid
+	}
+
+	toleranceSec := toleranceMs / 1000.0
+	for i, candidate := range candidates {
+		valid[i] = math.Abs(nearestValue(detected, candidate)-candidate) <= toleranceSec
+	}
+
+	return valid
+}
+
+// MatchPair is a pair of matched onsets, given by their indices in the two
+// lists passed to MatchOnsets
+type MatchPair struct {
+	A, B int
+}
+
+// MatchOnsets pairs the onsets of a and b (in seconds) that are at most
+// toleranceSec apart. Each onset is in at most one pair, and the closest
+// onsets are paired first, so an onset of b near two onsets of a matches the
+// nearer one. Ties are broken by index so the matching is deterministic. The
+// pairs are returned closest first.
+func MatchOnsets(a, b []float64, toleranceSec float64) []MatchPair {
+	type candidate struct {
+		i, j     int
+		distance float64
+	}
+
+	var candidates []candidate
+	for i, x := range a {
+		for j, y := range b {
+			if distance := math.Abs(x - y); distance <= toleranceSec {
+				candidates = append(candidates, candidate{i, j, distance})
+			}
+		}
+	}
+
+	// Closest first, breaking ties by position so the matching is deterministic
+	sort.SliceStable(candidates, func(k, l int) bool {
+		if candidates[k].distance != candidates[l].distance {
+			return candidates[k].distance < candidates[l].distance
+		}
+		if candidates[k].i != candidates[l].i {
+			return candidates[k].i < candidates[l].i
+		}
+		return candidates[k].j < candidates[l].j
+	})
+
+	usedA := make([]bool, len(a))
+	usedB := make([]bool, len(b))
+	var matches []MatchPair
+	for _, c := range candidates {
+		if usedA[c.i] || usedB[c.j] {
+			continue
+		}
+		usedA[c.i] = true
+		usedB[c.j] = true
+		matches = append(matches, MatchPair{c.i, c.j})
+	}
+
+	return matches
+}
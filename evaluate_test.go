@@ -0,0 +1,99 @@
+package onset
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluateOnsets(t *testing.T) {
+	reference := make([]float64, 10)
+	for i := range reference {
+		reference[i] = 0.5 + float64(i)*0.5
+	}
+
+	// The first 8 reference onsets 20ms late, plus two false detections
+	var detected []float64
+	for _, onsetTime := range reference[:8] {
+		detected = append(detected, onsetTime+0.02)
+	}
+	detected = append(detected, 0.1, 5.7)
+
+	precision, recall, f1 := EvaluateOnsets(detected, reference, 0.05)
+	if math.Abs(precision-0.8) > 1e-9 || math.Abs(recall-0.8) > 1e-9 || math.Abs(f1-0.8) > 1e-9 {
+		t.Errorf("Expected precision, recall and F1 of 0.8, got %f, %f, %f", precision, recall, f1)
+	}
+
+	// Only the first 5 detected: every detection is right, half the reference is found
+	precision, recall, f1 = EvaluateOnsets(detected[:5], reference, 0.05)
+	if precision != 1 || recall != 0.5 || math.Abs(f1-2.0/3.0) > 1e-9 {
+		t.Errorf("Expected 1, 0.5, 0.667, got %f, %f, %f", precision, recall, f1)
+	}
+
+	// A shift beyond the tolerance matches nothing
+	precision, recall, f1 = EvaluateOnsets(detected, reference, 0.01)
+	if precision != 0 || recall != 0 || f1 != 0 {
+		t.Errorf("Expected no matches with a 10ms tolerance, got %f, %f, %f", precision, recall, f1)
+	}
+
+	// Two detections near one reference onset count once
+	precision, recall, _ = EvaluateOnsets([]float64{0.49, 0.52}, []float64{0.5}, 0.05)
+	if precision != 0.5 || recall != 1 {
+		t.Errorf("Expected precision 0.5 and recall 1 for a doubled detection, got %f, %f", precision, recall)
+	}
+
+	if precision, recall, f1 := EvaluateOnsets(nil, nil, 0.05); precision != 0 || recall != 0 || f1 != 0 {
+		t.Errorf("Expected zero metrics without onsets, got %f, %f, %f", precision, recall, f1)
+	}
+}
+
+func TestMatchOnsets(t *testing.T) {
+	// The reference onset at 1.0 is within tolerance of the detections at
+	// 0.97 and 1.01, and only the nearer one matches it
+	detected := []float64{0.97, 1.01, 2.0, 3.5}
+	reference := []float64{1.0, 2.03, 3.0}
+
+	matches := MatchOnsets(detected, reference, 0.05)
+	expected := []MatchPair{{A: 1, B: 0}, {A: 2, B: 1}}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("Expected matches %v, got %v", expected, matches)
+	}
+
+	// The farther detection is free to match another onset in range
+	matches = MatchOnsets([]float64{0.97, 1.01}, []float64{0.95, 1.0}, 0.05)
+	expected = []MatchPair{{A: 1, B: 1}, {A: 0, B: 0}}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("Expected matches %v, got %v", expected, matches)
+	}
+
+	if matches := MatchOnsets(detected, nil, 0.05); len(matches) != 0 {
+		t.Errorf("Expected no matches without reference onsets, got %v", matches)
+	}
+}
+
+func TestValidateOnsets(t *testing.T) {
+	sampleRate := uint(44100)
+	transients := []float64{0.3, 0.9, 1.5, 2.1}
+	samples := clickTrack(sampleRate, 2.5, transients, 0.8)
+
+	// Candidates on the transients, slightly off, and at arbitrary times
+	// between them
+	candidates := []float64{0.3, 0.905, 1.495, 2.1, 0.6, 1.2, 1.8, 2.4}
+	valid := ValidateOnsets(samples, sampleRate, candidates, 20)
+	if len(valid) != len(candidates) {
+		t.Fatalf("Expected %d results, got %d", len(candidates), len(valid))
+	}
+	for i, candidate := range candidates {
+		expected := i < len(transients)
+		if valid[i] != expected {
+			t.Errorf("Candidate %.3fs: expected %v, got %v", candidate, expected, valid[i])
+		}
+	}
+
+	// Nothing validates in silence
+	for i, ok := range ValidateOnsets(make([]float64, 44100), sampleRate, candidates, 20) {
+		if ok {
+			t.Errorf("Candidate %.3fs validated in silence", candidates[i])
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -19,10 +19,13 @@ func main() {
 	outputFile := flag.String("output", "waveform.html", "Output HTML file (default: waveform.html)")
 	optimizeOnsets := flag.Bool("optimize", true, "Optimize onset positions using RMS differential (default: true)")
 	optimizeWindowMs := flag.Float64("optimize-window", 100.0, "Window size in milliseconds for onset optimization (default: 100.0)")
-	method := flag.String("method", "hfc", "Onset detection method: hfc, energy, complex, phase, wphase, specdiff, kl, mkl, specflux, consensus (default: hfc)")
+	method := flag.String("method", "hfc", "Onset detection method: hfc, energy, complex, phase, wphase, specdiff, kl, mkl, specflux, consensus, auto (default: hfc)")
 	minConsensusClusterSize := flag.Int("min-consensus-cluster", 3, "Minimum cluster size for consensus method (default: 3)")
+	consensusRemoveOutliers := flag.Bool("consensus-remove-outliers", true, "Remove outlying markers from consensus clusters before taking the midpoint (default: true)")
 	useMinimumSpacing := flag.Bool("use-minimum-spacing", true, "Enable minimum spacing filter between slices (default: true)")
 	minimumSpacing := flag.Float64("minimum-spacing", 80.0, "Minimum spacing in milliseconds between slices (default: 80.0)")
+	computeGrid := flag.Bool("grid", false, "Include the estimated BPM and the beat grid aligned to the first strong onset in the plot (default: false)")
+	noveltyCSV := flag.String("novelty-csv", "", "Write the per-frame novelty curve to this CSV file, for debugging detection (optional)")
 	flag.Parse()
 
 	if *soundFile == "" {
@@ -36,6 +39,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Read the header first so unsupported files fail before the analysis
+	info, err := onset.ProbeWav(*soundFile)
+	if errors.Is(err, onset.ErrNotWAV) {
+		log.Fatalf("%s is not a WAV file", *soundFile)
+	} else if err != nil {
+		log.Fatalf("Failed to read %s: %v", *soundFile, err)
+	}
+
+	fmt.Printf("Loaded: %s\n", filepath.Base(*soundFile))
+	fmt.Printf("  %d Hz, %d channels, %d bit, %.2f seconds\n", info.SampleRate, info.NumChannels, info.BitDepth, info.Duration)
+
 	// Use the slice analyzer API
 	options := onset.SliceAnalyzerOptions{
 		NumSlices:               *numSlices,
@@ -43,6 +57,7 @@ func main() {
 		OptimizeWindowMs:        *optimizeWindowMs,
 		Method:                  *method,
 		MinConsensusClusterSize: *minConsensusClusterSize,
+		ConsensusKeepOutliers:   !*consensusRemoveOutliers,
 		UseMinimumSpacing:       *useMinimumSpacing,
 		MinimumSpacing:          *minimumSpacing,
 	}
@@ -52,29 +67,24 @@ func main() {
 		log.Fatalf("Failed to analyze slices: %v", err)
 	}
 
-	fmt.Printf("Loaded: %s\n", filepath.Base(*soundFile))
-	fmt.Printf("  Samples: %d\n", len(result.Samples))
-	fmt.Printf("  Sample Rate: %d Hz\n", result.SampleRate)
-	fmt.Printf("  Duration: %.2f seconds\n", float64(len(result.Samples))/float64(result.SampleRate))
-	fmt.Printf("  Method: %s\n", *method)
-	if *numSlices > 0 {
-		fmt.Printf("  Finding best %d slices...\n", *numSlices)
-	} else {
-		fmt.Printf("  Finding all slices...\n")
-	}
-
 	if len(result.Onsets) == 0 {
 		log.Fatal("No onsets detected. Try adjusting parameters or using a different audio file.")
 	}
 
-	fmt.Printf("Found %d onsets:\n", len(result.Onsets))
-	for i, onset := range result.Onsets {
-		fmt.Printf("  %2d: %.4f seconds (sample %d)\n", i+1, onset, int(onset*float64(result.SampleRate)))
+	fmt.Print(result.Report())
+
+	if *noveltyCSV != "" {
+		hopSize := options.HopSize(result.SampleRate)
+		err = writeNoveltyCSV(result.Samples, result.SampleRate, result.Method, hopSize, *noveltyCSV)
+		if err != nil {
+			log.Fatalf("Failed to write novelty CSV: %v", err)
+		}
+		fmt.Printf("Novelty curve saved to: %s\n", *noveltyCSV)
 	}
 
 	// Write data to JSON file
 	dataFile := "waveform_data.json"
-	err = writeDataToJSON(result.Samples, result.SampleRate, result.Onsets, dataFile)
+	err = writeDataToJSON(result, *computeGrid, dataFile)
 	if err != nil {
 		log.Fatalf("Failed to write data file: %v", err)
 	}
@@ -89,32 +99,38 @@ func main() {
 	fmt.Printf("Waveform plot saved to: %s\n", *outputFile)
 }
 
-// WaveformData represents the data structure for JSON export
-type WaveformData struct {
-	Samples    []float64 `json:"samples"`
-	SampleRate uint      `json:"sample_rate"`
-	Onsets     []float64 `json:"onsets"`
-}
-
-// writeDataToJSON writes the waveform and onset data to a JSON file
-func writeDataToJSON(samples []float64, sampleRate uint, onsets []float64, filename string) error {
-	data := WaveformData{
-		Samples:    samples,
-		SampleRate: sampleRate,
-		Onsets:     onsets,
+// writeDataToJSON writes the waveform and onset data, and the tempo grid if
+// computeGrid is set, to a JSON file
+func writeDataToJSON(result *onset.SliceAnalyzerResult, computeGrid bool, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create JSON file: %w", err)
 	}
+	defer f.Close()
 
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
+	options := onset.JSONOptions{IncludeSamples: true, ComputeGrid: computeGrid}
+	if err := result.WriteJSON(f, options); err != nil {
+		return err
 	}
+	return f.Close()
+}
+
+// writeNoveltyCSV writes the novelty curve of the samples, computed with hops
+// of hopSize samples, to a CSV file
+func writeNoveltyCSV(samples []float64, sampleRate uint, method string, hopSize uint, filename string) error {
+	novelty := onset.NoveltyCurve(samples, sampleRate, method, hopSize)
+	frameRate := float64(sampleRate) / float64(hopSize)
 
-	err = os.WriteFile(filename, jsonData, 0644)
+	f, err := os.Create(filename)
 	if err != nil {
-		return fmt.Errorf("failed to write JSON file: %w", err)
+		return fmt.Errorf("failed to create CSV file: %w", err)
 	}
+	defer f.Close()
 
-	return nil
+	if err := onset.WriteNoveltyCSV(f, novelty, frameRate); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 // runPlotlyScript executes the Python plotly script to generate the visualization
@@ -46,6 +46,17 @@ def plot_waveform(data_file, output_file):
             showlegend=False
         ))
 
+    # Add the beat grid, if it was computed
+    for i, line in enumerate(data.get('grid', [])):
+        fig.add_trace(go.Scatter(
+            x=[line, line],
+            y=[min_amp, max_amp],
+            mode='lines',
+            line=dict(color='gray', width=1, dash='dot'),
+            hovertemplate=f'Beat {i+1} ({data["bpm"]:.1f} BPM)<br>Time: {line:.4f}s<extra></extra>',
+            showlegend=False
+        ))
+
     # Update layout
     fig.update_layout(
         title='Waveform with Onset Slices',
@@ -0,0 +1,169 @@
+package onset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// ExportOptions contains options for exporting slices
+type ExportOptions struct {
+	// Prefix is the start of the slice file names, which are numbered from 1
+	// (e.g. slice_001.wav), or from 0 for the lead-in (see IncludeLeadIn).
+	// Default is "slice" if not set.
+	Prefix string
+	// Stereo writes every channel of the source, interleaved as in the source
+	// (2-channel slices for a stereo file), instead of only the left channel
+	// that is analyzed.
+	// Default is false.
+	Stereo bool
+	// IncludeLeadIn also writes the audio before the first onset, if any, as
+	// slice 0 (e.g. slice_000.wav), so nothing of the file is lost.
+	// Default is false (the lead-in is discarded).
+	IncludeLeadIn bool
+	// DropTail discards the audio after the last onset, which then only ends
+	// the slice before it, e.g. when the onsets mark both ends of every hit.
+	// Default is false (the last slice runs to the end of the file).
+	DropTail bool
+}
+
+// ExportSlices cuts a WAV file at the given onsets and writes each slice, from
+// one onset to the next (the last to the end of the file unless DropTail is
+// set), as a WAV file in outDir with the sample rate and bit depth of the
+// source. Onsets are rounded to the nearest sample frame. With IncludeLeadIn
+// the audio before the first onset is written first, as slice 0. It returns
+// the paths of the written files.
+func ExportSlices(wavFile string, onsets []float64, outDir string, options ExportOptions) ([]string, error) {
+	data, info, err := readWavInterleaved(wavFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+
+	prefix := options.Prefix
+	if prefix == "" {
+		prefix = "slice"
+	}
+
+	numChannels := 1
+	if options.Stereo {
+		numChannels = info.NumChannels
+	}
+
+	numFrames := len(data) / info.NumChannels
+	frameAt := func(onsetTime float64) int {
+		return min(max(Round(onsetTime*float64(info.SampleRate)), 0), numFrames)
+	}
+
+	writeSlice := func(number, start, end int) (string, error) {
+		slice := make([]int, 0, (end-start)*numChannels)
+		for frame := start; frame < end; frame++ {
+			slice = append(slice, data[frame*info.NumChannels:frame*info.NumChannels+numChannels]...)
+		}
+
+		path := filepath.Join(outDir, fmt.Sprintf("%s_%03d.wav", prefix, number))
+		return path, writeWavInts(path, slice, info.SampleRate, info.BitDepth, numChannels)
+	}
+
+	paths := make([]string, 0, len(onsets)+1)
+	if options.IncludeLeadIn && len(onsets) > 0 && frameAt(onsets[0]) > 0 {
+		path, err := writeSlice(0, 0, frameAt(onsets[0]))
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	for i, onsetTime := range onsets {
+		if options.DropTail && i+1 == len(onsets) {
+			break
+		}
+
+		start := frameAt(onsetTime)
+		end := numFrames
+		if i+1 < len(onsets) {
+			end = max(frameAt(onsets[i+1]), start)
+		}
+
+		path, err := writeSlice(i+1, start, end)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// SliceFile detects the onsets of a WAV file with AnalyzeSlices and writes
+// its slices to outDir with ExportSlices, creating outDir if it is missing.
+// The slices are numbered in time order whatever the OrderBy option. It
+// returns the paths of the written files, none if no onset was found.
+func SliceFile(inPath, outDir string, options SliceAnalyzerOptions, export ExportOptions) ([]string, error) {
+	result, err := AnalyzeSlices(inPath, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	onsets := append([]float64(nil), result.Onsets...)
+	sort.Float64s(onsets)
+	return ExportSlices(inPath, onsets, outDir, export)
+}
+
+// readWavInterleaved decodes every channel of a WAV file and returns the
+// interleaved integer samples of the complete frames with the header information
+func readWavInterleaved(filename string) ([]int, WavInfo, error) {
+	reader, err := openWavBlockReader(filename)
+	if err != nil {
+		return nil, WavInfo{}, err
+	}
+	defer reader.Close()
+
+	data := make([]int, 0, reader.info.NumFrames*reader.info.NumChannels)
+	for {
+		n, err := reader.decoder.PCMBuffer(reader.buf)
+		if err != nil {
+			return nil, WavInfo{}, fmt.Errorf("failed to read PCM data: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		data = append(data, reader.buf.Data[:n]...)
+	}
+
+	// Drop a trailing partial frame
+	data = data[:len(data)/reader.info.NumChannels*reader.info.NumChannels]
+	return data, reader.info, nil
+}
+
+// writeWavInts writes interleaved integer samples to a WAV file
+func writeWavInts(path string, data []int, sampleRate uint, bitDepth, numChannels int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: numChannels, SampleRate: int(sampleRate)},
+		Data:           data,
+		SourceBitDepth: bitDepth,
+	}
+
+	encoder := wav.NewEncoder(f, int(sampleRate), bitDepth, numChannels, 1)
+	if err := encoder.Write(buf); err != nil {
+		return fmt.Errorf("failed to write WAV data: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to close WAV encoder: %w", err)
+	}
+
+	return nil
+}
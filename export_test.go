@@ -0,0 +1,189 @@
+package onset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/go-audio/wav"
+)
+
+func TestExportSlices(t *testing.T) {
+	// A stereo file with a ramp in the left channel and its negation in the right
+	dir := t.TempDir()
+	source := dir + "/stereo.wav"
+	sampleRate := uint(1000)
+	interleaved := make([]float64, 2*1000)
+	for i := 0; i < 1000; i++ {
+		interleaved[2*i] = float64(i) / 1000
+		interleaved[2*i+1] = -float64(i) / 1000
+	}
+	writeTestWav(t, source, interleaved, sampleRate, 2)
+
+	onsets := []float64{0.1, 0.25, 0.6}
+	expectedFrames := []int{150, 350, 400}
+
+	for _, stereo := range []bool{true, false} {
+		paths, err := ExportSlices(source, onsets, dir, ExportOptions{Stereo: stereo, Prefix: "hit"})
+		if err != nil {
+			t.Fatalf("ExportSlices failed (Stereo=%v): %v", stereo, err)
+		}
+		if len(paths) != len(onsets) {
+			t.Fatalf("Expected %d slices, got %d", len(onsets), len(paths))
+		}
+		if paths[0] != dir+"/hit_001.wav" {
+			t.Errorf("Expected first slice at %s, got %s", dir+"/hit_001.wav", paths[0])
+		}
+
+		numChannels := 1
+		if stereo {
+			numChannels = 2
+		}
+
+		for k, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("failed to open slice: %v", err)
+			}
+			buf, err := wav.NewDecoder(f).FullPCMBuffer()
+			f.Close()
+			if err != nil {
+				t.Fatalf("failed to decode slice: %v", err)
+			}
+
+			if buf.Format.NumChannels != numChannels {
+				t.Fatalf("Slice %d: expected %d channels, got %d", k, numChannels, buf.Format.NumChannels)
+			}
+			if len(buf.Data) != expectedFrames[k]*numChannels {
+				t.Fatalf("Slice %d: expected %d frames, got %d", k, expectedFrames[k], len(buf.Data)/numChannels)
+			}
+
+			// Both channels are copied unchanged from the source
+			start := int(onsets[k] * float64(sampleRate))
+			for frame := 0; frame < expectedFrames[k]; frame++ {
+				left := int(interleaved[2*(start+frame)] * 32767)
+				if buf.Data[frame*numChannels] != left {
+					t.Fatalf("Slice %d frame %d: expected left %d, got %d", k, frame, left, buf.Data[frame*numChannels])
+				}
+				if stereo && buf.Data[frame*2+1] != -left {
+					t.Fatalf("Slice %d frame %d: expected right %d, got %d", k, frame, -left, buf.Data[frame*2+1])
+				}
+			}
+		}
+	}
+
+	if _, err := ExportSlices(dir+"/missing.wav", onsets, dir, ExportOptions{}); err == nil {
+		t.Error("Expected error for a missing file, got nil")
+	}
+}
+
+func TestExportLeadInAndTail(t *testing.T) {
+	dir := t.TempDir()
+	source := dir + "/ramp.wav"
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = float64(i) / 1000
+	}
+	writeTestWav(t, source, samples, 1000, 1)
+
+	onsets := []float64{0.1, 0.25, 0.6}
+	tests := []struct {
+		leadIn, dropTail bool
+		expectedFrames   []int
+	}{
+		{false, false, []int{150, 350, 400}},
+		{true, false, []int{100, 150, 350, 400}},
+		{false, true, []int{150, 350}},
+		{true, true, []int{100, 150, 350}},
+	}
+
+	for _, tt := range tests {
+		outDir := filepath.Join(dir, fmt.Sprintf("leadin_%v_droptail_%v", tt.leadIn, tt.dropTail))
+		if err := os.Mkdir(outDir, 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		paths, err := ExportSlices(source, onsets, outDir, ExportOptions{IncludeLeadIn: tt.leadIn, DropTail: tt.dropTail})
+		if err != nil {
+			t.Fatalf("ExportSlices failed: %v", err)
+		}
+		if len(paths) != len(tt.expectedFrames) {
+			t.Fatalf("IncludeLeadIn=%v DropTail=%v: expected %d slices, got %d", tt.leadIn, tt.dropTail, len(tt.expectedFrames), len(paths))
+		}
+
+		// The lead-in is slice 0 and the onset slices keep their numbers
+		first := 1
+		if tt.leadIn {
+			first = 0
+		}
+		for k, path := range paths {
+			if expected := filepath.Join(outDir, fmt.Sprintf("slice_%03d.wav", first+k)); path != expected {
+				t.Errorf("Expected slice %d at %s, got %s", k, expected, path)
+			}
+			info, err := ProbeWav(path)
+			if err != nil {
+				t.Fatalf("ProbeWav failed on slice %d: %v", k, err)
+			}
+			if info.NumFrames != tt.expectedFrames[k] {
+				t.Errorf("IncludeLeadIn=%v DropTail=%v slice %d: expected %d frames, got %d", tt.leadIn, tt.dropTail, k, tt.expectedFrames[k], info.NumFrames)
+			}
+		}
+	}
+
+	// No lead-in slice is written when the first onset is at the start
+	paths, err := ExportSlices(source, []float64{0, 0.5}, dir, ExportOptions{IncludeLeadIn: true, Prefix: "start"})
+	if err != nil {
+		t.Fatalf("ExportSlices failed: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Errorf("Expected 2 slices without a lead-in, got %d", len(paths))
+	}
+}
+
+func TestSliceFile(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "amen", "slices")
+
+	options := DefaultSliceAnalyzerOptions()
+	options.NumSlices = 8
+	options.OrderBy = "energy"
+	paths, err := SliceFile("amen.wav", outDir, options, ExportOptions{})
+	if err != nil {
+		t.Fatalf("SliceFile failed: %v", err)
+	}
+	if len(paths) != 8 {
+		t.Fatalf("Expected 8 slices, got %d", len(paths))
+	}
+
+	info, err := ProbeWav("amen.wav")
+	if err != nil {
+		t.Fatalf("ProbeWav failed: %v", err)
+	}
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	first := slices.Min(result.Onsets)
+
+	// Slices are numbered in time order and together cover the file from the
+	// first onset
+	totalFrames := 0
+	for i, path := range paths {
+		expected := filepath.Join(outDir, fmt.Sprintf("slice_%03d.wav", i+1))
+		if path != expected {
+			t.Errorf("Expected slice %d at %s, got %s", i, expected, path)
+		}
+		slice, err := ProbeWav(path)
+		if err != nil {
+			t.Fatalf("ProbeWav failed on slice %d: %v", i, err)
+		}
+		totalFrames += slice.NumFrames
+	}
+	if expected := info.NumFrames - Round(first*float64(info.SampleRate)); totalFrames != expected {
+		t.Errorf("Expected %d frames in the slices, got %d", expected, totalFrames)
+	}
+
+	if _, err := SliceFile("missing.wav", outDir, options, ExportOptions{}); err == nil {
+		t.Error("Expected error for a missing file, got nil")
+	}
+}
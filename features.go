@@ -0,0 +1,445 @@
+package onset
+
+import "math"
+
+const (
+	// classifyWindowMs is the length of the window after each onset used for classification
+	classifyWindowMs = 50.0
+	// classifyFrameSize is the FFT size used for classification
+	classifyFrameSize = 1024
+	// percussiveFlatness is the spectral flatness above which an onset is percussive
+	percussiveFlatness = 0.3
+	// sharpnessFrameMs is the resolution of the amplitude envelope used for transient sharpness
+	sharpnessFrameMs = 1.0
+	// sharpnessPreMs is how far before each onset the envelope starts, to catch early rises
+	sharpnessPreMs = 5.0
+	// sharpnessWindowMs is how far after each onset the envelope peak is searched
+	sharpnessWindowMs = 30.0
+	// velocityRangeDb is the dynamic range below the loudest onset mapped onto
+	// the MIDI velocities by the logarithmic curve
+	velocityRangeDb = 60.0
+	// autoFrameSize is the frame size used to characterize the signal for the "auto" method
+	autoFrameSize = 1024
+	// autoActiveDb is the level below the loudest frame under which frames are ignored
+	autoActiveDb = -40.0
+	// autoTransientRiseDb is the frame-to-frame level rise counted as a transient
+	autoTransientRiseDb = 9.0
+	// autoTransientRate is the number of transients per second above which tonal
+	// material is treated as plucked or struck rather than legato
+	autoTransientRate = 0.5
+	// fingerprintBands is the number of mel bands of an onset fingerprint
+	fingerprintBands = 20
+	// pitchWindowMs is the length of the YIN integration window after each onset
+	pitchWindowMs = 40.0
+	// pitchMinHz and pitchMaxHz bound the fundamental frequencies searched
+	pitchMinHz = 50.0
+	pitchMaxHz = 2000.0
+	// yinThreshold is the cumulative mean normalized difference below which a
+	// lag counts as a period; onsets with no lag below it are unpitched
+	yinThreshold = 0.15
+)
+
+// ClassifyOnsets labels each onset "percussive" or "tonal" from the spectral
+// flatness of the 50ms following it. Noise-like, broadband spectra (drums,
+// clicks) have a high flatness while pitched sounds concentrate their energy
+// in a few harmonics and have a flatness close to 0.
+func ClassifyOnsets(samples []float64, sampleRate uint, onsets []float64) []string {
+	labels := make([]string, len(onsets))
+
+	hopSize := classifyFrameSize / 2
+	windowSamples := int(classifyWindowMs * float64(sampleRate) / 1000.0)
+	a := newSpectrumAnalyzer(classifyFrameSize)
+
+	for i, onsetTime := range onsets {
+		start := Round(onsetTime * float64(sampleRate))
+
+		sum := 0.0
+		count := 0
+		for offset := 0; offset == 0 || offset+classifyFrameSize <= windowSamples; offset += hopSize {
+			sum += spectralFlatness(a.do(samples, start+offset))
+			count++
+		}
+
+		if sum/float64(count) >= percussiveFlatness {
+			labels[i] = "percussive"
+		} else {
+			labels[i] = "tonal"
+		}
+	}
+
+	return labels
+}
+
+// SpectralFlatness returns the spectral flatness of every hopSize step of the
+// samples, computed over frames of 2*hopSize samples (the same frame layout as
+// onset detection). Flatness is the geometric mean over the arithmetic mean of
+// the magnitude spectrum: close to 1 for white noise and close to 0 for a pure tone.
+func SpectralFlatness(samples []float64, sampleRate uint, hopSize uint) []float64 {
+	if hopSize == 0 {
+		return []float64{}
+	}
+
+	a := newSpectrumAnalyzer(2 * hopSize)
+	flatness := make([]float64, len(samples)/int(hopSize))
+	for i := range flatness {
+		flatness[i] = spectralFlatness(a.do(samples, i*int(hopSize)))
+	}
+
+	return flatness
+}
+
+// TransientSharpness returns how snappy the attack of each onset is, as the
+// slope of the amplitude envelope from 10% to 90% of its peak in amplitude per
+// second (the peak level over the rise time). The envelope is the peak absolute
+// value of 1ms frames from 5ms before to 30ms after the onset, so clicks score
+// much higher than slow swells of the same level. Silent onsets score 0.
+func TransientSharpness(samples []float64, sampleRate uint, onsets []float64) []float64 {
+	sharpness := make([]float64, len(onsets))
+
+	frameSamples := int(math.Max(1, sharpnessFrameMs*float64(sampleRate)/1000.0))
+	numFrames := int((sharpnessPreMs + sharpnessWindowMs) / sharpnessFrameMs)
+	preSamples := int(sharpnessPreMs * float64(sampleRate) / 1000.0)
+	envelope := make([]float64, numFrames)
+
+	for i, onsetTime := range onsets {
+		start := Round(onsetTime*float64(sampleRate)) - preSamples
+
+		// Peak envelope and its position
+		peak := 0.0
+		peakFrame := 0
+		for k := range envelope {
+			envelope[k] = 0
+			for j := start + k*frameSamples; j < start+(k+1)*frameSamples; j++ {
+				if j >= 0 && j < len(samples) {
+					envelope[k] = math.Max(envelope[k], math.Abs(samples[j]))
+				}
+			}
+			if envelope[k] > peak {
+				peak = envelope[k]
+				peakFrame = k
+			}
+		}
+		if peak <= 0 {
+			continue
+		}
+
+		// Last frame below 10% before the peak and first frame above 90%
+		low := 0
+		for k := peakFrame; k >= 0; k-- {
+			if envelope[k] <= 0.1*peak {
+				low = k
+				break
+			}
+		}
+		high := peakFrame
+		for k := low; k <= peakFrame; k++ {
+			if envelope[k] >= 0.9*peak {
+				high = k
+				break
+			}
+		}
+
+		riseSec := float64(max(high-low, 1)*frameSamples) / float64(sampleRate)
+		sharpness[i] = 0.8 * peak / riseSec
+	}
+
+	return sharpness
+}
+
+// OnsetVelocities returns a MIDI velocity (1..127) for each onset from its RMS
+// energy over the following 50ms, using the logarithmic curve. See
+// OnsetVelocitiesWithCurve.
+func OnsetVelocities(samples []float64, sampleRate uint, onsets []float64) []uint8 {
+	return OnsetVelocitiesWithCurve(samples, sampleRate, onsets, "log")
+}
+
+// OnsetVelocitiesWithCurve returns a MIDI velocity (1..127) for each onset from
+// its RMS energy relative to the loudest onset, which gets velocity 127.
+// With the "linear" curve velocity is proportional to the energy; with the
+// "log" curve (the default for unknown curves) it is proportional to the level
+// in dB over the 60dB below the loudest onset, which better matches perceived
+// loudness. Onsets at or below the bottom of the range get velocity 1.
+func OnsetVelocitiesWithCurve(samples []float64, sampleRate uint, onsets []float64, curve string) []uint8 {
+	velocities := make([]uint8, len(onsets))
+
+	energies := make([]float64, len(onsets))
+	maxEnergy := 0.0
+	for i, onsetTime := range onsets {
+		energies[i] = calculateOnsetEnergy(samples, sampleRate, onsetTime)
+		maxEnergy = math.Max(maxEnergy, energies[i])
+	}
+
+	for i, energy := range energies {
+		level := 0.0
+		if maxEnergy > 0 && energy > 0 {
+			if curve == "linear" {
+				level = energy / maxEnergy
+			} else {
+				level = 1 + 20*math.Log10(energy/maxEnergy)/velocityRangeDb
+			}
+		}
+		velocities[i] = uint8(1 + math.Round(126*math.Max(0, math.Min(1, level))))
+	}
+
+	return velocities
+}
+
+// selectMethod chooses a detection method from the characteristics of the signal.
+// Noise-like material with a high average spectral flatness over its active frames
+// (drums, percussion) uses "hfc". Harmonic material with a low flatness uses
+// "complex" when it has clear level jumps (plucked or struck notes), which
+// complex domain detection catches along with pitch changes, and "phase" for
+// legato material whose onsets are mostly changes of pitch.
+func selectMethod(samples []float64, sampleRate uint) string {
+	hopSize := autoFrameSize / 2
+	a := newSpectrumAnalyzer(autoFrameSize)
+
+	numFrames := len(samples) / hopSize
+	if numFrames == 0 || sampleRate == 0 {
+		return "hfc"
+	}
+
+	// Level in dB and flatness of every frame
+	levels := make([]float64, numFrames)
+	flatness := make([]float64, numFrames)
+	maxLevel := math.Inf(-1)
+	for i := range levels {
+		start := i * hopSize
+		end := min(start+autoFrameSize, len(samples))
+		sumSquares := 0.0
+		for _, sample := range samples[start:end] {
+			sumSquares += sample * sample
+		}
+		levels[i] = 10 * math.Log10(sumSquares/float64(autoFrameSize)+1e-20)
+		maxLevel = math.Max(maxLevel, levels[i])
+		flatness[i] = spectralFlatness(a.do(samples, start))
+	}
+
+	flatnessSum := 0.0
+	active := 0
+	transients := 0
+	for i, level := range levels {
+		if level < maxLevel+autoActiveDb {
+			continue
+		}
+		flatnessSum += flatness[i]
+		active++
+		if i == 0 || level-levels[i-1] >= autoTransientRiseDb {
+			transients++
+		}
+	}
+
+	if active == 0 {
+		return "hfc"
+	}
+
+	if flatnessSum/float64(active) >= percussiveFlatness {
+		return "hfc"
+	}
+
+	duration := float64(len(samples)) / float64(sampleRate)
+	if float64(transients)/duration >= autoTransientRate {
+		return "complex"
+	}
+	return "phase"
+}
+
+// OnsetPitches estimates the fundamental frequency in Hz of each onset with
+// the YIN algorithm over the 40ms following it, e.g. to map melodic slices to
+// the keys of a sampler. Frequencies between 50 Hz and 2 kHz are found, with
+// the period interpolated between samples. Onsets with no clear period, such
+// as percussive or noisy hits and silence, get 0.
+func OnsetPitches(samples []float64, sampleRate uint, onsets []float64) []float64 {
+	pitches := make([]float64, len(onsets))
+	if sampleRate == 0 {
+		return pitches
+	}
+
+	window := int(pitchWindowMs * float64(sampleRate) / 1000.0)
+	minLag := max(int(float64(sampleRate)/pitchMaxHz), 2)
+	maxLag := int(float64(sampleRate) / pitchMinHz)
+	diff := make([]float64, maxLag+2)
+
+	for i, onsetTime := range onsets {
+		frame := sampleWindow(samples, Round(onsetTime*float64(sampleRate)), window+maxLag+1)
+		if lag := yinPeriod(frame, window, minLag, maxLag, diff); lag > 0 {
+			pitches[i] = float64(sampleRate) / lag
+		}
+	}
+
+	return pitches
+}
+
+// yinPeriod returns the period in samples of the first window samples of
+// frame, between minLag and maxLag, from the cumulative mean normalized
+// difference function of YIN, or 0 when no lag is below yinThreshold. frame
+// must hold window+maxLag+1 samples and diff maxLag+2 values.
+func yinPeriod(frame []float64, window, minLag, maxLag int, diff []float64) float64 {
+	// Difference function, normalized by its cumulative mean
+	diff[0] = 1
+	sum := 0.0
+	for lag := 1; lag <= maxLag+1; lag++ {
+		d := 0.0
+		for j := 0; j < window; j++ {
+			delta := frame[j] - frame[j+lag]
+			d += delta * delta
+		}
+		sum += d
+		if sum > 0 {
+			diff[lag] = d * float64(lag) / sum
+		} else {
+			diff[lag] = 1
+		}
+	}
+
+	// The first dip below the threshold, followed down to its minimum
+	for lag := minLag; lag <= maxLag; lag++ {
+		if diff[lag] >= yinThreshold {
+			continue
+		}
+		for lag < maxLag && diff[lag+1] < diff[lag] {
+			lag++
+		}
+		return float64(lag) + parabolicPeakOffset(-diff[lag-1], -diff[lag], -diff[lag+1])
+	}
+
+	return 0
+}
+
+// OnsetWindows returns fixed-length overlapping windows of samples after each
+// onset, e.g. as input to a feature extraction or classification pipeline.
+// Every window is windowMs long. For each onset, windows start at the onset and
+// every hopMs after it while the start stays within windowMs of the onset, so
+// each onset gets ceil(windowMs/hopMs) windows (1 if hopMs <= 0), and window k
+// of onset i is at index i*ceil(windowMs/hopMs)+k. Onsets are clamped to the
+// samples and windows are zero-padded past the end.
+func OnsetWindows(samples []float64, sampleRate uint, onsets []float64, windowMs float64, hopMs float64) [][]float64 {
+	length := int(windowMs * float64(sampleRate) / 1000.0)
+	if length <= 0 {
+		return [][]float64{}
+	}
+
+	perOnset := 1
+	hop := 0
+	if hopMs > 0 {
+		hop = max(1, int(hopMs*float64(sampleRate)/1000.0))
+		perOnset = (length + hop - 1) / hop
+	}
+
+	windows := make([][]float64, 0, len(onsets)*perOnset)
+	for _, onsetTime := range onsets {
+		start := min(max(Round(onsetTime*float64(sampleRate)), 0), len(samples))
+		for k := 0; k < perOnset; k++ {
+			windows = append(windows, sampleWindow(samples, start+k*hop, length))
+		}
+	}
+
+	return windows
+}
+
+// OnsetFingerprints returns a spectral fingerprint of each onset, e.g. to find
+// near-duplicate hits in a sample pack by clustering or by comparing the L2
+// distance between fingerprints. A fingerprint is the magnitude spectrum of
+// the 50ms following the onset reduced to 20 mel bands and scaled to unit
+// length, so it describes the timbre of the hit regardless of its level: the
+// distance between two fingerprints ranges from 0 (same spectral shape) to
+// at most 2. An onset followed by silence has a zero fingerprint.
+func OnsetFingerprints(samples []float64, sampleRate uint, onsets []float64) [][]float64 {
+	fingerprints := make([][]float64, len(onsets))
+
+	hopSize := classifyFrameSize / 2
+	windowSamples := int(classifyWindowMs * float64(sampleRate) / 1000.0)
+	a := newSpectrumAnalyzer(classifyFrameSize)
+	mel := NewMelFilterbank(fingerprintBands, classifyFrameSize, sampleRate)
+	bands := mel.NewBands()
+	spectrum := NewCvec(classifyFrameSize)
+
+	for i, onsetTime := range onsets {
+		start := Round(onsetTime * float64(sampleRate))
+
+		// Average magnitude spectrum of the frames after the onset
+		for j := range spectrum.Norm {
+			spectrum.Norm[j] = 0
+		}
+		count := 0
+		for offset := 0; offset == 0 || offset+classifyFrameSize <= windowSamples; offset += hopSize {
+			for j, m := range a.do(samples, start+offset) {
+				spectrum.Norm[j] += m
+			}
+			count++
+		}
+		for j := range spectrum.Norm {
+			spectrum.Norm[j] /= float64(count)
+		}
+
+		mel.Do(spectrum, bands)
+		fingerprint := make([]float64, fingerprintBands)
+		norm := 0.0
+		for b, value := range bands.Norm {
+			norm += value * value
+			fingerprint[b] = value
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for b := range fingerprint {
+				fingerprint[b] /= norm
+			}
+		}
+		fingerprints[i] = fingerprint
+	}
+
+	return fingerprints
+}
+
+// spectrumAnalyzer computes magnitude spectra of frames of samples with the
+// package phase vocoder
+type spectrumAnalyzer struct {
+	pv    *Pvoc
+	frame *Fvec
+	grain *Cvec
+}
+
+// newSpectrumAnalyzer creates a spectrum analyzer for frames of frameSize samples
+func newSpectrumAnalyzer(frameSize uint) *spectrumAnalyzer {
+	return &spectrumAnalyzer{
+		pv:    NewPvoc(frameSize, frameSize),
+		frame: NewFvec(frameSize),
+		grain: NewCvec(frameSize),
+	}
+}
+
+// do returns the magnitude spectrum of the frame starting at start, zero-padded
+// outside the samples. The returned slice is reused by the following call.
+func (a *spectrumAnalyzer) do(samples []float64, start int) []float64 {
+	for k := range a.frame.Data {
+		idx := start + k
+		if idx >= 0 && idx < len(samples) {
+			a.frame.Data[k] = samples[idx]
+		} else {
+			a.frame.Data[k] = 0
+		}
+	}
+	a.pv.Do(a.frame, a.grain)
+	return a.grain.Norm
+}
+
+// spectralFlatness returns the geometric mean over the arithmetic mean of a
+// magnitude spectrum, ignoring the DC bin. It is 0 for silence.
+func spectralFlatness(norm []float64) float64 {
+	if len(norm) < 2 {
+		return 0
+	}
+
+	logSum := 0.0
+	sum := 0.0
+	for _, m := range norm[1:] {
+		logSum += math.Log(m + 1e-12)
+		sum += m
+	}
+
+	n := float64(len(norm) - 1)
+	if sum <= 0 {
+		return 0
+	}
+	return math.Exp(logSum/n) / (sum / n)
+}
@@ -0,0 +1,324 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClassifyOnsets(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, int(sampleRate)*2)
+
+	// A noise burst at 0.2s and a pure tone at 1.0s
+	noise := clickTrack(sampleRate, 0.1, []float64{0}, 0.8)
+	copy(samples[int(0.2*float64(sampleRate)):], noise)
+	start := int(1.0 * float64(sampleRate))
+	for i := 0; i < int(sampleRate)/2; i++ {
+		samples[start+i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	labels := ClassifyOnsets(samples, sampleRate, []float64{0.2, 1.0})
+	if len(labels) != 2 {
+		t.Fatalf("Expected 2 labels, got %d", len(labels))
+	}
+
+	if labels[0] != "percussive" {
+		t.Errorf("Expected noise burst to be percussive, got %s", labels[0])
+	}
+
+	if labels[1] != "tonal" {
+		t.Errorf("Expected pure tone to be tonal, got %s", labels[1])
+	}
+}
+
+func TestSpectralFlatness(t *testing.T) {
+	sampleRate := uint(44100)
+	hopSize := uint(256)
+
+	noise := make([]float64, sampleRate)
+	seed := uint32(7)
+	for i := range noise {
+		seed = seed*1664525 + 1013904223
+		noise[i] = float64(seed)/float64(math.MaxUint32)*2 - 1
+	}
+
+	tone := make([]float64, sampleRate)
+	for i := range tone {
+		tone[i] = math.Sin(2 * math.Pi * 1000 * float64(i) / float64(sampleRate))
+	}
+
+	noiseFlatness := SpectralFlatness(noise, sampleRate, hopSize)
+	toneFlatness := SpectralFlatness(tone, sampleRate, hopSize)
+
+	expectedFrames := len(noise) / int(hopSize)
+	if len(noiseFlatness) != expectedFrames || len(toneFlatness) != expectedFrames {
+		t.Fatalf("Expected %d frames, got %d and %d", expectedFrames, len(noiseFlatness), len(toneFlatness))
+	}
+
+	// Skip the last frame, which is zero-padded
+	noiseMean := 0.0
+	toneMean := 0.0
+	for i := 0; i < expectedFrames-1; i++ {
+		noiseMean += noiseFlatness[i]
+		toneMean += toneFlatness[i]
+	}
+	noiseMean /= float64(expectedFrames - 1)
+	toneMean /= float64(expectedFrames - 1)
+
+	t.Logf("Mean flatness: noise %.3f, tone %.3f", noiseMean, toneMean)
+
+	if noiseMean < 0.7 {
+		t.Errorf("Expected flatness near 1 for white noise, got %f", noiseMean)
+	}
+
+	if toneMean > 0.1 {
+		t.Errorf("Expected flatness near 0 for a pure tone, got %f", toneMean)
+	}
+}
+
+func TestTransientSharpness(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, int(sampleRate))
+
+	// A click at 0.2s and a swell with a 20ms linear attack at 0.6s, both
+	// peaking at 0.8
+	click := clickTrack(sampleRate, 0.05, []float64{0}, 0.8)
+	copy(samples[int(0.2*float64(sampleRate)):], click)
+	start := int(0.6 * float64(sampleRate))
+	attack := int(0.02 * float64(sampleRate))
+	for i := 0; i < int(sampleRate)/10; i++ {
+		gain := math.Min(1, float64(i)/float64(attack))
+		samples[start+i] = 0.8 * gain * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	sharpness := TransientSharpness(samples, sampleRate, []float64{0.2, 0.6, 0.9})
+	if len(sharpness) != 3 {
+		t.Fatalf("Expected 3 values, got %d", len(sharpness))
+	}
+
+	t.Logf("Sharpness: click %.1f, swell %.1f", sharpness[0], sharpness[1])
+
+	if sharpness[0] <= 4*sharpness[1] {
+		t.Errorf("Expected click (%f) to be much sharper than swell (%f)", sharpness[0], sharpness[1])
+	}
+
+	if sharpness[2] != 0 {
+		t.Errorf("Expected 0 for silence, got %f", sharpness[2])
+	}
+}
+
+func TestOnsetVelocities(t *testing.T) {
+	sampleRate := uint(44100)
+	times := []float64{0.1, 0.3, 0.5, 0.7}
+	samples := make([]float64, int(sampleRate))
+	for i, amplitude := range []float64{0.8, 0.4, 0.1, 0.0001} {
+		click := clickTrack(sampleRate, 0.05, []float64{0}, amplitude)
+		copy(samples[int(times[i]*float64(sampleRate)):], click)
+	}
+	onsets := append(times, 0.9)
+
+	for _, curve := range []string{"linear", "log"} {
+		velocities := OnsetVelocitiesWithCurve(samples, sampleRate, onsets, curve)
+		t.Logf("%s: %v", curve, velocities)
+
+		if velocities[0] != 127 {
+			t.Errorf("%s: expected the loudest onset to have velocity 127, got %d", curve, velocities[0])
+		}
+		for i := 1; i < 3; i++ {
+			if velocities[i] >= velocities[i-1] {
+				t.Errorf("%s: expected velocity to fall with level, got %v", curve, velocities)
+			}
+		}
+		// Very quiet and silent onsets are clamped to 1
+		if velocities[3] > velocities[2] || velocities[4] != 1 {
+			t.Errorf("%s: expected quiet onsets clamped to the bottom, got %v", curve, velocities)
+		}
+	}
+
+	// The logarithmic curve gives quieter onsets more velocity than the linear one
+	linear := OnsetVelocitiesWithCurve(samples, sampleRate, onsets, "linear")
+	log := OnsetVelocities(samples, sampleRate, onsets)
+	if log[2] <= linear[2] {
+		t.Errorf("Expected log velocity (%d) above linear (%d) for a quiet onset", log[2], linear[2])
+	}
+}
+
+func TestAutoMethod(t *testing.T) {
+	sampleRate := uint(44100)
+
+	// Noise bursts on a beat
+	var times []float64
+	for i := 0; i < 8; i++ {
+		times = append(times, 0.1+float64(i)*0.25)
+	}
+	drums := clickTrack(sampleRate, 2.2, times, 0.8)
+
+	// Plucked notes: decaying harmonic tones
+	plucks := make([]float64, int(2.2*float64(sampleRate)))
+	for n, freq := range []float64{220, 330, 262, 392} {
+		start := int((0.1 + float64(n)*0.5) * float64(sampleRate))
+		for i := 0; start+i < len(plucks) && i < int(sampleRate)/2; i++ {
+			tm := float64(i) / float64(sampleRate)
+			plucks[start+i] = 0.5 * math.Exp(-tm*4) * (math.Sin(2*math.Pi*freq*tm) + 0.5*math.Sin(4*math.Pi*freq*tm))
+		}
+	}
+
+	drumMethod := selectMethod(drums, sampleRate)
+	pluckMethod := selectMethod(plucks, sampleRate)
+	t.Logf("Drums: %s, plucks: %s", drumMethod, pluckMethod)
+
+	if drumMethod != "hfc" {
+		t.Errorf("Expected hfc for percussion, got %s", drumMethod)
+	}
+	if pluckMethod != "complex" && pluckMethod != "phase" {
+		t.Errorf("Expected a tonal method for plucked notes, got %s", pluckMethod)
+	}
+
+	result, err := AnalyzeSamples(plucks, sampleRate, SliceAnalyzerOptions{Method: "auto"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if result.Method != pluckMethod {
+		t.Errorf("Expected result to record method %s, got %s", pluckMethod, result.Method)
+	}
+	if len(result.Onsets) == 0 {
+		t.Error("Expected onsets with the auto method")
+	}
+}
+
+func TestOnsetWindows(t *testing.T) {
+	sampleRate := uint(1000)
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = float64(i)
+	}
+
+	// 100ms windows with a 40ms stride: ceil(100/40) = 3 windows per onset
+	windows := OnsetWindows(samples, sampleRate, []float64{0.2, 0.95}, 100, 40)
+	if len(windows) != 6 {
+		t.Fatalf("Expected 6 windows, got %d", len(windows))
+	}
+
+	for i, window := range windows {
+		if len(window) != 100 {
+			t.Fatalf("Window %d: expected 100 samples, got %d", i, len(window))
+		}
+	}
+
+	// Windows of the first onset start at 200, 240 and 280
+	for k, start := range []float64{200, 240, 280} {
+		if windows[k][0] != start || windows[k][99] != start+99 {
+			t.Errorf("Window %d: expected samples %v..%v, got %v..%v", k, start, start+99, windows[k][0], windows[k][99])
+		}
+	}
+
+	// Windows past the end are zero-padded
+	if windows[3][0] != 950 || windows[3][49] != 999 || windows[3][50] != 0 {
+		t.Errorf("Expected the window at 950 to be zero-padded after 50 samples")
+	}
+	for _, v := range windows[5] {
+		if v != 0 {
+			t.Errorf("Expected the window starting past the end to be all zeros")
+			break
+		}
+	}
+
+	// Without a stride there is one window per onset, and onsets are clamped
+	windows = OnsetWindows(samples, sampleRate, []float64{-0.1, 0.5}, 50, 0)
+	if len(windows) != 2 || windows[0][0] != 0 || windows[1][0] != 500 {
+		t.Errorf("Expected one clamped window per onset, got %d windows", len(windows))
+	}
+}
+
+func TestOnsetFingerprints(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, int(sampleRate)*2)
+
+	// A decaying 80 Hz kick at 0.2s and a copy at half level at 1.2s, and a
+	// noise burst at 0.7s
+	for _, hit := range []struct{ start, gain float64 }{{0.2, 0.8}, {1.2, 0.4}} {
+		start := int(hit.start * float64(sampleRate))
+		for i := 0; i < int(sampleRate)/5; i++ {
+			tt := float64(i) / float64(sampleRate)
+			samples[start+i] = hit.gain * math.Sin(2*math.Pi*80*tt) * math.Exp(-tt*20)
+		}
+	}
+	noise := clickTrack(sampleRate, 0.1, []float64{0}, 0.8)
+	copy(samples[int(0.7*float64(sampleRate)):], noise)
+
+	fingerprints := OnsetFingerprints(samples, sampleRate, []float64{0.2, 0.7, 1.2, 1.8})
+	if len(fingerprints) != 4 {
+		t.Fatalf("Expected 4 fingerprints, got %d", len(fingerprints))
+	}
+
+	distance := func(a, b []float64) float64 {
+		sum := 0.0
+		for i := range a {
+			sum += (a[i] - b[i]) * (a[i] - b[i])
+		}
+		return math.Sqrt(sum)
+	}
+
+	if d := distance(fingerprints[0], fingerprints[2]); d > 0.01 {
+		t.Errorf("Expected the two kicks to have near-identical fingerprints, got distance %f", d)
+	}
+	if d := distance(fingerprints[0], fingerprints[1]); d < 0.5 {
+		t.Errorf("Expected the kick and the noise burst to differ, got distance %f", d)
+	}
+
+	// Unit length, except after silence
+	for i, fingerprint := range fingerprints[:3] {
+		if len(fingerprint) != fingerprintBands {
+			t.Fatalf("Fingerprint %d: expected %d bands, got %d", i, fingerprintBands, len(fingerprint))
+		}
+		if norm := distance(fingerprint, make([]float64, fingerprintBands)); math.Abs(norm-1) > 1e-9 {
+			t.Errorf("Fingerprint %d: expected unit length, got %f", i, norm)
+		}
+	}
+	for b, value := range fingerprints[3] {
+		if value != 0 {
+			t.Errorf("Expected a zero fingerprint after silence, got %f in band %d", value, b)
+		}
+	}
+}
+
+func TestOnsetPitches(t *testing.T) {
+	sampleRate := uint(44100)
+	notes := []float64{82.41, 220, 261.63, 440, 659.26, 1046.5}
+	noteSec := 0.3
+
+	// Harmonic tones of known pitch, then a noise burst and silence
+	samples := make([]float64, int(float64(len(notes)+2)*noteSec*float64(sampleRate)))
+	var onsets []float64
+	for n, freq := range notes {
+		start := int(float64(n) * noteSec * float64(sampleRate))
+		for i := 0; i < int(noteSec*float64(sampleRate)); i++ {
+			phase := 2 * math.Pi * freq * float64(i) / float64(sampleRate)
+			samples[start+i] = 0.5*math.Sin(phase) + 0.25*math.Sin(2*phase+0.4) + 0.1*math.Sin(3*phase+1.1)
+		}
+		onsets = append(onsets, float64(n)*noteSec)
+	}
+	noiseStart := float64(len(notes)) * noteSec
+	copy(samples[int(noiseStart*float64(sampleRate)):], clickTrack(sampleRate, noteSec, []float64{0}, 0.8))
+	onsets = append(onsets, noiseStart, noiseStart+noteSec)
+
+	pitches := OnsetPitches(samples, sampleRate, onsets)
+	if len(pitches) != len(onsets) {
+		t.Fatalf("Expected %d pitches, got %d", len(onsets), len(pitches))
+	}
+
+	for n, freq := range notes {
+		cents := 1200 * math.Log2(pitches[n]/freq)
+		t.Logf("%.2f Hz: estimated %.2f Hz (%.2f cents)", freq, pitches[n], cents)
+		if math.Abs(cents) > 5 {
+			t.Errorf("Expected %.2f Hz within 5 cents, got %.2f Hz (%.2f cents)", freq, pitches[n], cents)
+		}
+	}
+
+	if pitches[len(notes)] != 0 {
+		t.Errorf("Expected 0 for the noise burst, got %.2f Hz", pitches[len(notes)])
+	}
+	if pitches[len(notes)+1] != 0 {
+		t.Errorf("Expected 0 for silence, got %.2f Hz", pitches[len(notes)+1])
+	}
+}
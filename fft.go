@@ -0,0 +1,85 @@
+package onset
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// fftTables holds the read-only tables of a radix-2 FFT of one size, shared
+// by every workspace of that size
+type fftTables struct {
+	twiddles []complex128
+	reversed []int
+}
+
+// fftTableCache maps FFT sizes to their *fftTables
+var fftTableCache sync.Map
+
+// tablesForSize returns the shared tables of a radix-2 FFT of size n
+func tablesForSize(n int) *fftTables {
+	if tables, ok := fftTableCache.Load(n); ok {
+		return tables.(*fftTables)
+	}
+
+	tables := &fftTables{
+		twiddles: make([]complex128, n/2),
+		reversed: make([]int, n),
+	}
+	for k := range tables.twiddles {
+		angle := -2 * math.Pi * float64(k) / float64(n)
+		tables.twiddles[k] = complex(math.Cos(angle), math.Sin(angle))
+	}
+	shift := bits.UintSize - bits.TrailingZeros(uint(n))
+	for i := range tables.reversed {
+		tables.reversed[i] = int(bits.Reverse(uint(i)) >> shift)
+	}
+
+	actual, _ := fftTableCache.LoadOrStore(n, tables)
+	return actual.(*fftTables)
+}
+
+// fftWorkspace computes FFTs of one power-of-two size without allocating,
+// reusing its output buffer and the shared tables of its size. A workspace
+// must not be used concurrently.
+type fftWorkspace struct {
+	tables *fftTables
+	buf    []complex128
+}
+
+// newFFTWorkspace returns a workspace for FFTs of size n, or nil if n is not
+// a power of two
+func newFFTWorkspace(n uint) *fftWorkspace {
+	if n < 2 || n&(n-1) != 0 {
+		return nil
+	}
+	return &fftWorkspace{
+		tables: tablesForSize(int(n)),
+		buf:    make([]complex128, n),
+	}
+}
+
+// realFFT returns the FFT of the real input, which must have the size of the
+// workspace. The result is overwritten by the next call.
+func (w *fftWorkspace) realFFT(x []float64) []complex128 {
+	n := len(w.buf)
+	for i, j := range w.tables.reversed {
+		w.buf[i] = complex(x[j], 0)
+	}
+
+	// Iterative decimation in time
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := n / size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				a := w.buf[start+k]
+				b := w.buf[start+k+half] * w.tables.twiddles[k*step]
+				w.buf[start+k] = a + b
+				w.buf[start+k+half] = a - b
+			}
+		}
+	}
+
+	return w.buf
+}
@@ -0,0 +1,30 @@
+package onset
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+func TestFFTWorkspace(t *testing.T) {
+	if newFFTWorkspace(1000) != nil {
+		t.Error("Expected no workspace for a size that is not a power of two")
+	}
+
+	for _, n := range []uint{2, 8, 512, 1024} {
+		x := make([]float64, n)
+		for i := range x {
+			x[i] = math.Sin(float64(i)*0.37) + 0.5*math.Cos(float64(i*i)*0.01)
+		}
+
+		want := fft.FFTReal(x)
+		got := newFFTWorkspace(n).realFFT(x)
+		for k := range want {
+			if cmplx.Abs(got[k]-want[k]) > 1e-9 {
+				t.Fatalf("Size %d, bin %d: expected %v, got %v", n, k, want[k], got[k])
+			}
+		}
+	}
+}
@@ -36,7 +36,10 @@ func NewBiquadFilter(b0, b1, b2, a1, a2 float64) *Filter {
 	return f
 }
 
-// Do applies the filter to the input vector in-place
+// Do applies the filter to the input vector in-place. The input and output
+// history in X and Y carries over from one call to the next, so a signal
+// filtered block by block gives the same output as filtered in one call; call
+// Reset before filtering an unrelated signal.
 func (f *Filter) Do(in *Fvec) {
 	for j := uint(0); j < in.Length; j++ {
 		// New input
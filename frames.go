@@ -0,0 +1,85 @@
+package onset
+
+import (
+	"math"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// DetectFromFrames runs onset detection over caller-supplied frames, for
+// pipelines with their own framing policy (frame size, overlap, windowing).
+// Frames are used as-is, so they should already be windowed; frames of a
+// different length than the first are truncated or zero-padded. frameRate is
+// the number of frames per second and converts frame positions to seconds.
+// If pp is nil a new PeakPicker with default settings is used; a supplied one
+// keeps its history, so it should be fresh for each signal.
+// Unlike Onset, no silence gate or minimum inter-onset interval is applied.
+func DetectFromFrames(frames [][]float64, frameRate float64, method string, pp *PeakPicker) []float64 {
+	if len(frames) == 0 || len(frames[0]) == 0 || frameRate <= 0 {
+		return []float64{}
+	}
+
+	size := len(frames[0])
+	buf := make([]float64, size)
+
+	return detectSpectra(len(frames), uint(size), frameRate, method, pp, func(i int, grain *Cvec) {
+		n := copy(buf, frames[i])
+		clear(buf[n:])
+
+		spectrum := fft.FFTReal(buf)
+		for j := uint(0); j < grain.Length; j++ {
+			grain.Norm[j] = math.Hypot(real(spectrum[j]), imag(spectrum[j]))
+			grain.Phas[j] = math.Atan2(imag(spectrum[j]), real(spectrum[j]))
+		}
+	})
+}
+
+// DetectFromSpectrogram runs onset detection over a caller-supplied magnitude
+// spectrogram, skipping the FFT, for pipelines that compute their own STFT.
+// Each row of mag holds the magnitudes of one frame, from DC to Nyquist
+// (frameSize/2+1 bins); rows of a different length than the first are
+// truncated or zero-padded. The phases are zero, so phase-based methods
+// (complex, phase, wphase) find no onsets and magnitude methods such as hfc,
+// energy or specflux should be used. frameRate, pp and the lack of a silence
+// gate or minimum inter-onset interval are as for DetectFromFrames.
+func DetectFromSpectrogram(mag [][]float64, frameRate float64, method string, pp *PeakPicker) []float64 {
+	if len(mag) == 0 || len(mag[0]) == 0 || frameRate <= 0 {
+		return []float64{}
+	}
+
+	numBins := len(mag[0])
+	return detectSpectra(len(mag), 2*uint(numBins-1), frameRate, method, pp, func(i int, grain *Cvec) {
+		n := copy(grain.Norm, mag[i])
+		clear(grain.Norm[n:])
+	})
+}
+
+// detectSpectra runs the descriptor of method and the peak picker over
+// numFrames spectral frames of a frame size of size samples, each filled in by
+// spectrum, and returns the onset times in seconds
+func detectSpectra(numFrames int, size uint, frameRate float64, method string, pp *PeakPicker, spectrum func(i int, grain *Cvec)) []float64 {
+	onsets := []float64{}
+	if pp == nil {
+		pp = NewPeakPicker()
+	}
+
+	desc := NewSpecdesc(method, size)
+	grain := NewCvec(size)
+	novelty := NewFvec(1)
+	out := NewFvec(1)
+
+	for i := 0; i < numFrames; i++ {
+		spectrum(i, grain)
+		desc.Do(grain, novelty)
+		pp.Do(novelty, out)
+
+		if out.Data[0] > 0 {
+			// The peak picker reports the peak position within its three-frame
+			// peek window, whose newest value is WinPre frames behind frame i
+			peakFrame := float64(i) - float64(pp.WinPre) - 2 + out.Data[0]
+			onsets = append(onsets, math.Max(0, peakFrame)/frameRate)
+		}
+	}
+
+	return onsets
+}
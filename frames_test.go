@@ -0,0 +1,80 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDetectFromFrames(t *testing.T) {
+	frameSize := 512
+	frameRate := 100.0
+	onsetFrame := 20
+
+	// Silent frames with a windowed noise burst starting at a known frame
+	frames := make([][]float64, 60)
+	seed := uint32(5)
+	for i := range frames {
+		frames[i] = make([]float64, frameSize)
+		if i < onsetFrame || i >= onsetFrame+3 {
+			continue
+		}
+		for j := range frames[i] {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(math.MaxUint32)*2 - 1
+			hann := 0.5 - 0.5*math.Cos(2*math.Pi*float64(j)/float64(frameSize))
+			frames[i][j] = 0.8 * noise * hann
+		}
+	}
+
+	for _, method := range []string{"hfc", "energy", "specflux"} {
+		onsets := DetectFromFrames(frames, frameRate, method, nil)
+		if len(onsets) != 1 {
+			t.Fatalf("%s: expected 1 onset, got %v", method, onsets)
+		}
+
+		expected := float64(onsetFrame) / frameRate
+		t.Logf("%s: onset at %.4fs", method, onsets[0])
+		if math.Abs(onsets[0]-expected) > 1/frameRate {
+			t.Errorf("%s: expected onset at %.3fs, got %.3fs", method, expected, onsets[0])
+		}
+	}
+
+	if onsets := DetectFromFrames(nil, frameRate, "hfc", nil); len(onsets) != 0 {
+		t.Errorf("Expected no onsets for no frames, got %v", onsets)
+	}
+}
+
+func TestDetectFromSpectrogram(t *testing.T) {
+	numBins := 257
+	frameRate := 100.0
+	onsetFrame := 30
+
+	// Silent frames with a sudden broadband energy jump at a known frame
+	mag := make([][]float64, 80)
+	for i := range mag {
+		mag[i] = make([]float64, numBins)
+		if i < onsetFrame {
+			continue
+		}
+		for j := range mag[i] {
+			mag[i][j] = 1.0
+		}
+	}
+
+	for _, method := range []string{"hfc", "energy", "specflux"} {
+		onsets := DetectFromSpectrogram(mag, frameRate, method, nil)
+		if len(onsets) != 1 {
+			t.Fatalf("%s: expected 1 onset, got %v", method, onsets)
+		}
+
+		expected := float64(onsetFrame) / frameRate
+		t.Logf("%s: onset at %.4fs", method, onsets[0])
+		if math.Abs(onsets[0]-expected) > 1/frameRate {
+			t.Errorf("%s: expected onset at %.3fs, got %.3fs", method, expected, onsets[0])
+		}
+	}
+
+	if onsets := DetectFromSpectrogram(nil, frameRate, "hfc", nil); len(onsets) != 0 {
+		t.Errorf("Expected no onsets for an empty spectrogram, got %v", onsets)
+	}
+}
@@ -3,11 +3,9 @@ module github.com/schollz/onsets
 go 1.25
 
 require (
+	github.com/go-audio/audio v1.0.0
 	github.com/go-audio/wav v1.1.0
 	github.com/mjibson/go-dsp v0.0.0-20180508042940-11479a337f12
 )
 
-require (
-	github.com/go-audio/audio v1.0.0 // indirect
-	github.com/go-audio/riff v1.0.0 // indirect
-)
+require github.com/go-audio/riff v1.0.0 // indirect
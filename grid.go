@@ -0,0 +1,209 @@
+package onset
+
+import (
+	"math"
+	"sort"
+)
+
+// AlignToReference snaps each onset to the nearest reference time when it lies
+// within toleranceSec of it. Onsets with no reference time within the tolerance
+// are returned unchanged. Unlike a regular grid, the reference times can be
+// arbitrary (e.g. beat times from a MIDI file).
+func AlignToReference(onsets, reference []float64, toleranceSec float64) []float64 {
+	aligned := make([]float64, len(onsets))
+	copy(aligned, onsets)
+
+	if len(reference) == 0 {
+		return aligned
+	}
+
+	// Create a sorted copy of the reference times for binary search
+	sorted := make([]float64, len(reference))
+	copy(sorted, reference)
+	sort.Float64s(sorted)
+
+	for i, onsetTime := range onsets {
+		nearest := nearestValue(sorted, onsetTime)
+		if math.Abs(nearest-onsetTime) <= toleranceSec {
+			aligned[i] = nearest
+		}
+	}
+
+	return aligned
+}
+
+// nearestValue returns the value in a sorted, non-empty array closest to x
+func nearestValue(sorted []float64, x float64) float64 {
+	idx := sort.SearchFloat64s(sorted, x)
+	if idx == 0 {
+		return sorted[0]
+	}
+	if idx == len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	if x-sorted[idx-1] <= sorted[idx]-x {
+		return sorted[idx-1]
+	}
+	return sorted[idx]
+}
+
+// gridInterval returns the spacing in seconds of a grid of division notes per
+// 4/4 bar (e.g. division 8 for eighth notes) at the given tempo
+func gridInterval(bpm float64, division int) float64 {
+	return 60.0 / bpm * 4.0 / float64(division)
+}
+
+// ExtractGroove measures how far the onsets deviate from an exact grid of
+// division notes per 4/4 bar (e.g. 16 for sixteenth notes) anchored at time 0.
+// For each grid line up to the last onset it returns the deviation in
+// milliseconds of the nearest onset (positive = late, negative = early).
+// Grid lines with no onset within half a grid step are reported as NaN.
+// Onsets before time 0 only count as early hits on the first grid line, so
+// onsets all well before it give no grid lines.
+func ExtractGroove(onsets []float64, bpm float64, division int) []float64 {
+	if len(onsets) == 0 || bpm <= 0 || division <= 0 {
+		return []float64{}
+	}
+
+	sorted := make([]float64, len(onsets))
+	copy(sorted, onsets)
+	sort.Float64s(sorted)
+
+	step := gridInterval(bpm, division)
+	numLines := max(int(math.Floor(sorted[len(sorted)-1]/step+0.5))+1, 0)
+
+	deviations := make([]float64, numLines)
+	for i := range deviations {
+		gridTime := float64(i) * step
+		deviation := nearestValue(sorted, gridTime) - gridTime
+		if math.Abs(deviation) <= step/2 {
+			deviations[i] = deviation * 1000.0
+		} else {
+			deviations[i] = math.NaN()
+		}
+	}
+
+	return deviations
+}
+
+// strongOnsetRatio is the fraction of the loudest onset energy an onset needs
+// to be considered a strong onset (e.g. a downbeat rather than a ghost note)
+const strongOnsetRatio = 0.5
+
+// SliceToGrid returns slicesPerBar*bars evenly spaced slice points on a 4/4 grid
+// at the given tempo, anchored to the first strong onset of the result.
+// The first strong onset is the first one with at least half the energy of the
+// loudest onset; when the result has no samples, the first onset is used, and
+// when it has no onsets the grid starts at 0.
+func SliceToGrid(result *SliceAnalyzerResult, bpm float64, slicesPerBar, bars int) []float64 {
+	if bpm <= 0 || slicesPerBar <= 0 || bars <= 0 {
+		return []float64{}
+	}
+
+	anchor := firstStrongOnset(result)
+	step := gridInterval(bpm, slicesPerBar)
+
+	points := make([]float64, slicesPerBar*bars)
+	for i := range points {
+		points[i] = anchor + float64(i)*step
+	}
+
+	return points
+}
+
+// firstStrongOnset returns the time of the first onset whose energy is close
+// to that of the loudest onset
+func firstStrongOnset(result *SliceAnalyzerResult) float64 {
+	if result == nil || len(result.Onsets) == 0 {
+		return 0.0
+	}
+
+	if len(result.Samples) == 0 {
+		return result.Onsets[0]
+	}
+
+	energies := make([]float64, len(result.Onsets))
+	maxEnergy := 0.0
+	for i, onsetTime := range result.Onsets {
+		energies[i] = calculateOnsetEnergy(result.Samples, result.SampleRate, onsetTime)
+		if energies[i] > maxEnergy {
+			maxEnergy = energies[i]
+		}
+	}
+
+	for i, energy := range energies {
+		if energy >= maxEnergy*strongOnsetRatio {
+			return result.Onsets[i]
+		}
+	}
+
+	return result.Onsets[0]
+}
+
+// BBT is a musical position in bars, beats and ticks. Bars and beats are
+// numbered from 1 like in a DAW; ticks are numbered from 0.
+type BBT struct {
+	Bar  int
+	Beat int
+	Tick int
+}
+
+// SecondsToBBT converts a time in seconds to bars:beats:ticks in 4/4 at the
+// given tempo with ppq ticks per quarter note. At 120 BPM, 0.5s is 1:2:0.
+func SecondsToBBT(sec, bpm float64, ppq int) (bar, beat, tick int) {
+	position := SecondsToBBTWithMeter(sec, bpm, ppq, 4)
+	return position.Bar, position.Beat, position.Tick
+}
+
+// SecondsToBBTWithMeter converts a time in seconds to bars:beats:ticks with
+// beatsPerBar beats per bar (e.g. 3 for 3/4) at the given tempo with ppq ticks
+// per beat. The time is rounded to the nearest tick; negative times map to 1:1:0.
+func SecondsToBBTWithMeter(sec, bpm float64, ppq int, beatsPerBar int) BBT {
+	if bpm <= 0 || ppq <= 0 || beatsPerBar <= 0 || sec < 0 {
+		return BBT{Bar: 1, Beat: 1, Tick: 0}
+	}
+
+	ticks := Round(sec * bpm / 60.0 * float64(ppq))
+	beats := ticks / ppq
+
+	return BBT{
+		Bar:  beats/beatsPerBar + 1,
+		Beat: beats%beatsPerBar + 1,
+		Tick: ticks % ppq,
+	}
+}
+
+// OnsetsToBBT converts onset times in seconds to bars:beats:ticks with
+// beatsPerBar beats per bar at the given tempo and ppq ticks per beat
+func OnsetsToBBT(onsets []float64, bpm float64, ppq int, beatsPerBar int) []BBT {
+	positions := make([]BBT, len(onsets))
+	for i, onsetTime := range onsets {
+		positions[i] = SecondsToBBTWithMeter(onsetTime, bpm, ppq, beatsPerBar)
+	}
+	return positions
+}
+
+// OnsetsRelativeToDownbeat returns the position of each onset within its 4/4
+// bar in beats (0 up to 4), measured from the nearest preceding downbeat. Bars
+// start at firstDownbeatSec and every four beats at the given tempo, and are
+// extended backwards for onsets before the first downbeat. At 120 BPM with the
+// first downbeat at 0.5s, an onset at 2.75s is 0.5 beats into the second bar.
+// It returns zeros when the tempo is not positive.
+func OnsetsRelativeToDownbeat(onsets []float64, bpm float64, firstDownbeatSec float64) []float64 {
+	positions := make([]float64, len(onsets))
+	if bpm <= 0 {
+		return positions
+	}
+
+	const beatsPerBar = 4.0
+	for i, onsetTime := range onsets {
+		beats := (onsetTime - firstDownbeatSec) * bpm / 60.0
+		position := beats - beatsPerBar*math.Floor(beats/beatsPerBar)
+		// An onset a rounding error before a downbeat is on the downbeat
+		if beatsPerBar-position < 1e-9 {
+			position = 0
+		}
+		positions[i] = position
+	}
+	return positions
+}
@@ -0,0 +1,207 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAlignToReference(t *testing.T) {
+	reference := []float64{0.0, 0.5, 1.0, 1.5, 2.0}
+	onsets := []float64{0.01, 0.48, 0.75, 1.02, 1.9, 2.3}
+	tolerance := 0.03
+
+	aligned := AlignToReference(onsets, reference, tolerance)
+
+	expected := []float64{0.0, 0.5, 0.75, 1.0, 1.9, 2.3}
+	if len(aligned) != len(expected) {
+		t.Fatalf("Expected %d onsets, got %d", len(expected), len(aligned))
+	}
+
+	for i := range expected {
+		if aligned[i] != expected[i] {
+			t.Errorf("Onset %d: expected %f, got %f", i, expected[i], aligned[i])
+		}
+	}
+
+	// The input must not be modified
+	if onsets[0] != 0.01 {
+		t.Errorf("Expected input to be unchanged, got %f", onsets[0])
+	}
+
+	t.Run("UnsortedReference", func(t *testing.T) {
+		aligned := AlignToReference([]float64{0.99}, []float64{2.0, 1.0, 0.0}, 0.02)
+		if aligned[0] != 1.0 {
+			t.Errorf("Expected 1.0, got %f", aligned[0])
+		}
+	})
+
+	t.Run("EmptyReference", func(t *testing.T) {
+		aligned := AlignToReference(onsets, nil, tolerance)
+		for i := range onsets {
+			if aligned[i] != onsets[i] {
+				t.Errorf("Onset %d: expected %f, got %f", i, onsets[i], aligned[i])
+			}
+		}
+	})
+}
+
+func TestExtractGroove(t *testing.T) {
+	bpm := 120.0
+	eighth := 0.25 // seconds per eighth note at 120 BPM
+	swing := 0.04  // off-beats are pushed 40ms late
+
+	// Two bars of swung eighth notes
+	var onsets []float64
+	for i := 0; i < 16; i++ {
+		onsetTime := float64(i) * eighth
+		if i%2 == 1 {
+			onsetTime += swing
+		}
+		onsets = append(onsets, onsetTime)
+	}
+
+	groove := ExtractGroove(onsets, bpm, 8)
+	if len(groove) != 16 {
+		t.Fatalf("Expected 16 grid positions, got %d", len(groove))
+	}
+
+	for i, deviation := range groove {
+		if i%2 == 0 {
+			if math.Abs(deviation) > 0.001 {
+				t.Errorf("Expected on-beat %d to have ~0ms deviation, got %.3fms", i, deviation)
+			}
+		} else {
+			if math.Abs(deviation-swing*1000) > 0.001 {
+				t.Errorf("Expected off-beat %d to have %.1fms deviation, got %.3fms", i, swing*1000, deviation)
+			}
+		}
+	}
+
+	t.Run("MissingGridLine", func(t *testing.T) {
+		groove := ExtractGroove([]float64{0.0, 0.5}, bpm, 8)
+		if len(groove) != 3 {
+			t.Fatalf("Expected 3 grid positions, got %d", len(groove))
+		}
+		if !math.IsNaN(groove[1]) {
+			t.Errorf("Expected NaN for a grid line without an onset, got %f", groove[1])
+		}
+	})
+
+	t.Run("NegativeOnsets", func(t *testing.T) {
+		if groove := ExtractGroove([]float64{-1}, bpm, 8); len(groove) != 0 {
+			t.Errorf("Expected no grid positions before time 0, got %v", groove)
+		}
+
+		// Slightly early for the first grid line
+		groove := ExtractGroove([]float64{-0.01}, bpm, 8)
+		if len(groove) != 1 || math.Abs(groove[0]+10) > 1e-9 {
+			t.Errorf("Expected the first grid line 10ms early, got %v", groove)
+		}
+	})
+}
+
+func TestSliceToGrid(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, int(sampleRate)*2)
+
+	// A quiet ghost note at 0.1s followed by the downbeat at 0.25s
+	addClick := func(timeSec, amplitude float64) {
+		start := int(timeSec * float64(sampleRate))
+		for i := 0; i < 500; i++ {
+			samples[start+i] = amplitude * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate))
+		}
+	}
+	addClick(0.1, 0.1)
+	addClick(0.25, 1.0)
+
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0.1, 0.25},
+		Samples:    samples,
+		SampleRate: sampleRate,
+	}
+
+	bpm := 120.0
+	points := SliceToGrid(result, bpm, 4, 4)
+	if len(points) != 16 {
+		t.Fatalf("Expected 16 slice points, got %d", len(points))
+	}
+
+	if points[0] != 0.25 {
+		t.Errorf("Expected grid to be anchored at the strong onset 0.25, got %f", points[0])
+	}
+
+	beat := 60.0 / bpm
+	for i := 1; i < len(points); i++ {
+		spacing := points[i] - points[i-1]
+		if math.Abs(spacing-beat) > 1e-9 {
+			t.Errorf("Expected spacing %f at index %d, got %f", beat, i, spacing)
+		}
+	}
+
+	t.Run("WithoutSamples", func(t *testing.T) {
+		points := SliceToGrid(&SliceAnalyzerResult{Onsets: []float64{0.1, 0.25}}, bpm, 8, 2)
+		if len(points) != 16 {
+			t.Fatalf("Expected 16 slice points, got %d", len(points))
+		}
+		if points[0] != 0.1 {
+			t.Errorf("Expected grid to be anchored at the first onset 0.1, got %f", points[0])
+		}
+	})
+}
+
+func TestSecondsToBBT(t *testing.T) {
+	tests := []struct {
+		sec             float64
+		bpm             float64
+		ppq             int
+		bar, beat, tick int
+	}{
+		{0.0, 120, 960, 1, 1, 0},
+		{0.5, 120, 960, 1, 2, 0},
+		{0.75, 120, 960, 1, 2, 480},
+		{2.0, 120, 960, 2, 1, 0},
+		{2.125, 120, 96, 2, 1, 24},
+		{1.0, 60, 480, 1, 2, 0},
+	}
+
+	for _, tt := range tests {
+		bar, beat, tick := SecondsToBBT(tt.sec, tt.bpm, tt.ppq)
+		if bar != tt.bar || beat != tt.beat || tick != tt.tick {
+			t.Errorf("SecondsToBBT(%f, %f, %d) = %d:%d:%d, expected %d:%d:%d",
+				tt.sec, tt.bpm, tt.ppq, bar, beat, tick, tt.bar, tt.beat, tt.tick)
+		}
+	}
+
+	t.Run("ThreeFour", func(t *testing.T) {
+		// At 120 BPM in 3/4, a bar lasts 1.5s
+		positions := OnsetsToBBT([]float64{0.0, 1.0, 1.5, 2.25}, 120, 960, 3)
+		expected := []BBT{{1, 1, 0}, {1, 3, 0}, {2, 1, 0}, {2, 2, 480}}
+		for i := range expected {
+			if positions[i] != expected[i] {
+				t.Errorf("Onset %d: expected %+v, got %+v", i, expected[i], positions[i])
+			}
+		}
+	})
+}
+
+func TestOnsetsRelativeToDownbeat(t *testing.T) {
+	// At 120 BPM a beat lasts 0.5s and a bar 2s; the first downbeat is at 0.25s
+	onsets := []float64{0.25, 0.75, 1.5, 2.25, 2.5, 4.249999999999, 0.0}
+	expected := []float64{0, 1, 2.5, 0, 0.5, 0, 3.5}
+
+	positions := OnsetsRelativeToDownbeat(onsets, 120, 0.25)
+	if len(positions) != len(expected) {
+		t.Fatalf("Expected %d positions, got %d", len(expected), len(positions))
+	}
+	for i := range expected {
+		if math.Abs(positions[i]-expected[i]) > 1e-9 {
+			t.Errorf("Onset %.3fs: expected %.3f beats into the bar, got %.3f", onsets[i], expected[i], positions[i])
+		}
+	}
+
+	for _, position := range OnsetsRelativeToDownbeat(onsets, 0, 0.25) {
+		if position != 0 {
+			t.Errorf("Expected 0 without a tempo, got %f", position)
+		}
+	}
+}
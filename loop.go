@@ -0,0 +1,89 @@
+package onset
+
+import "math"
+
+const (
+	// loopMatchWindowMs is the length of the windows compared at the loop boundaries
+	loopMatchWindowMs = 5.0
+	// loopSearchWindowMs is how far the loop end may move from the last onset
+	loopSearchWindowMs = 10.0
+)
+
+// FindLoopPoints picks loop boundaries for seamless playback of the audio
+// between the first and last onsets. The start is the first onset; the end is
+// searched within 10ms of the last onset (or of the end of the audio when there
+// is only one onset) for the position whose surrounding 5ms best matches the
+// audio around the start, so the jump back to the start is as smooth as possible.
+// Both points are returned in seconds.
+func FindLoopPoints(samples []float64, sampleRate uint, onsets []float64) (startSec, endSec float64) {
+	durationSec := float64(len(samples)) / float64(sampleRate)
+	if len(onsets) == 0 {
+		return 0, durationSec
+	}
+
+	startSec = onsets[0]
+	endSec = durationSec
+	if len(onsets) > 1 {
+		endSec = onsets[len(onsets)-1]
+	}
+
+	start := int(Round(startSec * float64(sampleRate)))
+	target := int(Round(endSec * float64(sampleRate)))
+	halfWindow := int(loopMatchWindowMs*float64(sampleRate)/1000.0) / 2
+	search := int(loopSearchWindowMs * float64(sampleRate) / 1000.0)
+
+	bestEnd := target
+	bestDiff := math.MaxFloat64
+	for end := target - search; end <= target+search; end++ {
+		if end <= start {
+			continue
+		}
+		diff, ok := windowDifference(samples, start, end, halfWindow)
+		if !ok {
+			continue
+		}
+		// Prefer the candidate closest to the last onset among equally good matches
+		closer := absInt(end-target) < absInt(bestEnd-target)
+		if diff < bestDiff-1e-12 || (diff <= bestDiff+1e-12 && closer) {
+			bestDiff = diff
+			bestEnd = end
+		}
+	}
+
+	return startSec, float64(bestEnd) / float64(sampleRate)
+}
+
+// windowDifference returns the mean squared difference between the windows of
+// halfWindow samples on either side of positions a and b. It reports false when
+// a window does not fit in the samples.
+func windowDifference(samples []float64, a, b, halfWindow int) (float64, bool) {
+	lo := -halfWindow
+	if a+lo < 0 {
+		lo = -a
+	}
+	if b+lo < 0 {
+		return 0, false
+	}
+	hi := halfWindow
+	if b+hi > len(samples) {
+		return 0, false
+	}
+	if hi <= lo {
+		return 0, false
+	}
+
+	sum := 0.0
+	for k := lo; k < hi; k++ {
+		d := samples[a+k] - samples[b+k]
+		sum += d * d
+	}
+	return sum / float64(hi-lo), true
+}
+
+// absInt returns the absolute value of an integer
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,43 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFindLoopPoints(t *testing.T) {
+	sampleRate := uint(44100)
+	period := 100 // samples, i.e. 441 Hz
+
+	samples := make([]float64, int(sampleRate)*2)
+	for i := range samples {
+		phase := 2 * math.Pi * float64(i) / float64(period)
+		samples[i] = 0.5*math.Sin(phase) + 0.25*math.Sin(3*phase+0.3)
+	}
+
+	// The last onset is deliberately not a whole number of periods after the first
+	onsets := []float64{0.1, 0.5, 1.50037}
+
+	startSec, endSec := FindLoopPoints(samples, sampleRate, onsets)
+
+	if startSec != onsets[0] {
+		t.Errorf("Expected loop start at the first onset %f, got %f", onsets[0], startSec)
+	}
+
+	// The best match closest to the last onset is at most half a period away
+	if math.Abs(endSec-onsets[2]) > float64(period/2)/float64(sampleRate) {
+		t.Errorf("Expected loop end near the last onset %f, got %f", onsets[2], endSec)
+	}
+
+	length := int(Round((endSec - startSec) * float64(sampleRate)))
+	if length%period != 0 {
+		t.Errorf("Expected loop length to be a whole number of periods, got %d samples", length)
+	}
+
+	t.Run("NoOnsets", func(t *testing.T) {
+		startSec, endSec := FindLoopPoints(samples, sampleRate, nil)
+		if startSec != 0 || endSec != 2.0 {
+			t.Errorf("Expected the whole file (0, 2), got (%f, %f)", startSec, endSec)
+		}
+	})
+}
@@ -19,7 +19,7 @@ func FvecPush(v *Fvec, newElem float64) {
 	v.Data[v.Length-1] = newElem
 }
 
-// FvecMedian computes the median of a vector (modifies the input)
+// FvecMedian computes the median of a vector without modifying it
 func FvecMedian(input *Fvec) float64 {
 	if input.Length == 0 {
 		return 0
@@ -29,6 +29,16 @@ func FvecMedian(input *Fvec) float64 {
 	arr := make([]float64, input.Length)
 	copy(arr, input.Data)
 
+	return medianInPlace(arr)
+}
+
+// medianInPlace computes the median of arr by partial sorting, reordering
+// arr instead of allocating a copy
+func medianInPlace(arr []float64) float64 {
+	if len(arr) == 0 {
+		return 0
+	}
+
 	n := len(arr)
 	low := 0
 	high := n - 1
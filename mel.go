@@ -0,0 +1,94 @@
+package onset
+
+import "math"
+
+// MelFilterbank reduces a linear-frequency spectrum to bands equally spaced on
+// the mel scale, so low frequencies get as many bands as their musical
+// importance rather than as many FFT bins as their bandwidth
+type MelFilterbank struct {
+	NumBands uint
+	// Filters holds the triangular weights of each band over the FFT bins.
+	// The weights of a band sum to 1, so a band is the weighted mean
+	// magnitude of its bins.
+	Filters []*Fvec
+}
+
+// hzToMel converts a frequency in Hz to mels
+func hzToMel(hz float64) float64 {
+	return 2595.0 * math.Log10(1.0+hz/700.0)
+}
+
+// melToHz converts mels to a frequency in Hz
+func melToHz(mel float64) float64 {
+	return 700.0 * (math.Pow(10, mel/2595.0) - 1.0)
+}
+
+// NewMelFilterbank creates a filterbank of numBands triangular mel bands from 0
+// Hz to the Nyquist frequency for spectra of an FFT of bufSize samples. A band
+// too narrow to cover any bin takes the bin nearest its center.
+func NewMelFilterbank(numBands, bufSize, samplerate uint) *MelFilterbank {
+	numBins := bufSize/2 + 1
+	binHz := float64(samplerate) / float64(bufSize)
+
+	// Band edges: numBands+2 points equally spaced in mels
+	maxMel := hzToMel(float64(samplerate) / 2.0)
+	edges := make([]float64, numBands+2)
+	for i := range edges {
+		edges[i] = melToHz(maxMel * float64(i) / float64(numBands+1))
+	}
+
+	m := &MelFilterbank{
+		NumBands: numBands,
+		Filters:  make([]*Fvec, numBands),
+	}
+	for b := uint(0); b < numBands; b++ {
+		lower, center, upper := edges[b], edges[b+1], edges[b+2]
+		filter := NewFvec(numBins)
+		sum := 0.0
+		for j := uint(0); j < numBins; j++ {
+			hz := float64(j) * binHz
+			weight := 0.0
+			if hz > lower && hz <= center {
+				weight = (hz - lower) / (center - lower)
+			} else if hz > center && hz < upper {
+				weight = (upper - hz) / (upper - center)
+			}
+			filter.Data[j] = weight
+			sum += weight
+		}
+
+		if sum == 0 {
+			nearest := uint(math.Round(center / binHz))
+			filter.Data[min(nearest, numBins-1)] = 1
+			sum = 1
+		}
+		for j := range filter.Data {
+			filter.Data[j] /= sum
+		}
+		m.Filters[b] = filter
+	}
+
+	return m
+}
+
+// NewBands returns a Cvec with one value per band, to be filled by Do
+func (m *MelFilterbank) NewBands() *Cvec {
+	return &Cvec{
+		Length: m.NumBands,
+		Norm:   make([]float64, m.NumBands),
+		Phas:   make([]float64, m.NumBands),
+	}
+}
+
+// Do reduces the magnitudes of the spectrum to the bands. The phases of the
+// bands are zero, so phase-based descriptors see no phase change.
+func (m *MelFilterbank) Do(spectrum *Cvec, bands *Cvec) {
+	for b, filter := range m.Filters {
+		sum := 0.0
+		for j := uint(0); j < filter.Length && j < spectrum.Length; j++ {
+			sum += filter.Data[j] * spectrum.Norm[j]
+		}
+		bands.Norm[b] = sum
+		bands.Phas[b] = 0
+	}
+}
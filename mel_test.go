@@ -0,0 +1,109 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMelFilterbank(t *testing.T) {
+	m := NewMelFilterbank(40, 512, 44100)
+	if len(m.Filters) != 40 {
+		t.Fatalf("Expected 40 filters, got %d", len(m.Filters))
+	}
+
+	// Every band is a weighted mean of its bins and covers at least one bin
+	for b, filter := range m.Filters {
+		if sum := FvecMean(filter) * float64(filter.Length); math.Abs(sum-1) > 1e-9 {
+			t.Errorf("Band %d: expected weights summing to 1, got %f", b, sum)
+		}
+	}
+
+	// A flat spectrum gives flat bands, a low tone lands in the low bands
+	spectrum := NewCvec(512)
+	for j := range spectrum.Norm {
+		spectrum.Norm[j] = 2
+	}
+	bands := m.NewBands()
+	m.Do(spectrum, bands)
+	for b, v := range bands.Norm {
+		if math.Abs(v-2) > 1e-9 {
+			t.Errorf("Band %d: expected 2 for a flat spectrum, got %f", b, v)
+		}
+	}
+
+	clear(spectrum.Norm)
+	spectrum.Norm[2] = 1 // 172 Hz
+	m.Do(spectrum, bands)
+	loudest := 0
+	for b := range bands.Norm {
+		if bands.Norm[b] > bands.Norm[loudest] {
+			loudest = b
+		}
+	}
+	if loudest > 4 {
+		t.Errorf("Expected a 172 Hz bin in the first bands, got band %d", loudest)
+	}
+}
+
+func TestMelBands(t *testing.T) {
+	// A decaying bassline with hi-hats between the notes
+	sampleRate := 44100
+	samples := make([]float64, sampleRate*3)
+	notes := []float64{55, 82.4, 65.4, 73.4, 49, 61.7}
+	var bass []float64
+	for k := 0; k < 12; k++ {
+		start := 0.1 + 0.23*float64(k)
+		bass = append(bass, start)
+		for i := int(start * float64(sampleRate)); i < int((start+0.23)*float64(sampleRate)); i++ {
+			tm := float64(i)/float64(sampleRate) - start
+			samples[i] += 0.5 * math.Min(1, tm*200) * math.Exp(-tm*4) * math.Sin(2*math.Pi*notes[k%len(notes)]*tm)
+		}
+	}
+	seed := uint32(3)
+	for k := 0; k < 24; k++ {
+		start := int((0.1575 + 0.115*float64(k)) * float64(sampleRate))
+		for i := 0; i < 2000 && start+i < len(samples); i++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(math.MaxUint32)*2 - 1
+			samples[start+i] += 0.3 * noise * math.Exp(-float64(i)/300)
+		}
+	}
+
+	// Mean strength of the bass onsets relative to the other onsets
+	bassRatio := func(melBands int) float64 {
+		result, err := AnalyzeSamples(samples, uint(sampleRate), SliceAnalyzerOptions{Method: "hfc", MelBands: melBands})
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		var bassSum, otherSum float64
+		var bassCount, otherCount int
+		for i, onset := range result.Onsets {
+			isBass := false
+			for _, b := range bass {
+				isBass = isBass || math.Abs(onset-b) < 0.03
+			}
+			if isBass {
+				bassSum += result.Strengths[i]
+				bassCount++
+			} else {
+				otherSum += result.Strengths[i]
+				otherCount++
+			}
+		}
+		if bassCount == 0 || otherCount == 0 {
+			t.Fatalf("Expected bass and hi-hat onsets with %d mel bands, got %d and %d", melBands, bassCount, otherCount)
+		}
+		return (bassSum / float64(bassCount)) / (otherSum / float64(otherCount))
+	}
+
+	// Linear hfc favors the hi-hats, mel bands weigh the bass much more
+	linear := bassRatio(0)
+	mel := bassRatio(40)
+	if mel < 1.5*linear {
+		t.Errorf("Expected mel bands to raise the bass strength ratio, got %.2f linear and %.2f mel", linear, mel)
+	}
+
+	if _, err := AnalyzeSamples(samples, uint(sampleRate), SliceAnalyzerOptions{MelBands: -1}); err == nil {
+		t.Error("Expected error for negative mel bands, got nil")
+	}
+}
@@ -0,0 +1,110 @@
+package onset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// NoveltyCurve returns the raw onset detection function (novelty) of the
+// samples for the given method, one value per hopSize samples computed over
+// frames of 2*hopSize samples like onset detection. The frame rate of the
+// curve is sampleRate/hopSize.
+func NoveltyCurve(samples []float64, sampleRate uint, method string, hopSize uint) []float64 {
+	if hopSize == 0 {
+		return []float64{}
+	}
+
+	o := NewOnset(method, 2*hopSize, hopSize, sampleRate)
+	input := NewFvec(hopSize)
+	output := NewFvec(1)
+
+	novelty := make([]float64, len(samples)/int(hopSize))
+	for i := range novelty {
+		copy(input.Data, samples[i*int(hopSize):])
+		o.Do(input, output)
+		novelty[i] = o.Desc.Data[0]
+	}
+
+	return novelty
+}
+
+// WriteNoveltyWav writes a novelty curve with frameRate values per second as a
+// mono 16-bit WAV file at outSampleRate, e.g. to use it as a control signal or
+// envelope. The curve is linearly interpolated between frames and normalized
+// so its maximum is 1, with negative values clipped to 0. The file lasts
+// len(novelty)/frameRate seconds.
+func WriteNoveltyWav(path string, novelty []float64, frameRate float64, outSampleRate uint) error {
+	if frameRate <= 0 || outSampleRate == 0 {
+		return fmt.Errorf("invalid frame rate %f or sample rate %d", frameRate, outSampleRate)
+	}
+
+	numSamples := int(math.Round(float64(len(novelty)) / frameRate * float64(outSampleRate)))
+	values := make([]float64, numSamples)
+	maxValue := 0.0
+	for i := range values {
+		// Position in frames, interpolated between neighbouring frames
+		pos := float64(i) * frameRate / float64(outSampleRate)
+		frame := int(pos)
+		value := novelty[frame]
+		if frame+1 < len(novelty) {
+			frac := pos - float64(frame)
+			value = value*(1-frac) + novelty[frame+1]*frac
+		}
+		values[i] = value
+		maxValue = math.Max(maxValue, value)
+	}
+
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 1, SampleRate: int(outSampleRate)},
+		Data:           make([]int, numSamples),
+		SourceBitDepth: 16,
+	}
+	if maxValue > 0 {
+		for i, value := range values {
+			buf.Data[i] = int(math.Round(math.Max(0, value/maxValue) * 32767))
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	encoder := wav.NewEncoder(f, int(outSampleRate), 16, 1, 1)
+	if err := encoder.Write(buf); err != nil {
+		return fmt.Errorf("failed to write WAV data: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to close WAV encoder: %w", err)
+	}
+
+	return nil
+}
+
+// WriteNoveltyCSV writes a novelty curve with frameRate values per second as
+// CSV, a "time,novelty" header followed by one row per frame with the start
+// time of the frame in seconds, e.g. to plot the curve against the onsets and
+// see why one was missed.
+func WriteNoveltyCSV(w io.Writer, novelty []float64, frameRate float64) error {
+	if frameRate <= 0 {
+		return fmt.Errorf("invalid frame rate %f", frameRate)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "time,novelty")
+	for i, value := range novelty {
+		fmt.Fprintf(bw, "%.6f,%g\n", float64(i)/frameRate, value)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package onset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestNoveltyCurve(t *testing.T) {
+	sampleRate := uint(44100)
+	hopSize := uint(256)
+	samples := clickTrack(sampleRate, 1.0, []float64{0.5}, 0.8)
+
+	novelty := NoveltyCurve(samples, sampleRate, "hfc", hopSize)
+	if len(novelty) != len(samples)/int(hopSize) {
+		t.Fatalf("Expected %d frames, got %d", len(samples)/int(hopSize), len(novelty))
+	}
+
+	// The curve peaks at the click
+	peak := 0
+	for i, value := range novelty {
+		if value > novelty[peak] {
+			peak = i
+		}
+	}
+	peakTime := float64(peak) * float64(hopSize) / float64(sampleRate)
+	if math.Abs(peakTime-0.5) > 0.02 {
+		t.Errorf("Expected novelty peak near 0.5s, got %.3fs", peakTime)
+	}
+}
+
+func TestWriteNoveltyWav(t *testing.T) {
+	sampleRate := uint(44100)
+	hopSize := uint(256)
+	samples := clickTrack(sampleRate, 2.0, []float64{0.5, 1.5}, 0.8)
+	novelty := NoveltyCurve(samples, sampleRate, "hfc", hopSize)
+	frameRate := float64(sampleRate) / float64(hopSize)
+
+	path := filepath.Join(t.TempDir(), "novelty.wav")
+	outSampleRate := uint(8000)
+	if err := WriteNoveltyWav(path, novelty, frameRate, outSampleRate); err != nil {
+		t.Fatalf("WriteNoveltyWav failed: %v", err)
+	}
+
+	info, err := ProbeWav(path)
+	if err != nil {
+		t.Fatalf("ProbeWav failed: %v", err)
+	}
+
+	duration := float64(len(novelty)) / frameRate
+	expected := int(math.Round(duration * float64(outSampleRate)))
+	if info.NumFrames != expected {
+		t.Errorf("Expected %d samples, got %d", expected, info.NumFrames)
+	}
+	if info.SampleRate != outSampleRate || info.NumChannels != 1 {
+		t.Errorf("Expected mono at %d Hz, got %d channels at %d Hz", outSampleRate, info.NumChannels, info.SampleRate)
+	}
+
+	// Normalized to 0..1: the peak reaches full scale and nothing is negative
+	written, _, err := readWavFileLeftChannel(path)
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+	maxValue, minValue := 0.0, 0.0
+	for _, v := range written {
+		maxValue = math.Max(maxValue, v)
+		minValue = math.Min(minValue, v)
+	}
+	if math.Abs(maxValue-32767.0/32768.0) > 1e-9 || minValue < 0 {
+		t.Errorf("Expected values in 0..1 reaching full scale, got %f..%f", minValue, maxValue)
+	}
+
+	if err := WriteNoveltyWav(path, novelty, 0, outSampleRate); err == nil {
+		t.Error("Expected error for zero frame rate, got nil")
+	}
+}
+
+func TestWriteNoveltyCSV(t *testing.T) {
+	sampleRate := uint(44100)
+	hopSize := uint(256)
+	samples := clickTrack(sampleRate, 1.0, []float64{0.5}, 0.8)
+	novelty := NoveltyCurve(samples, sampleRate, "hfc", hopSize)
+	frameRate := float64(sampleRate) / float64(hopSize)
+
+	var buf bytes.Buffer
+	if err := WriteNoveltyCSV(&buf, novelty, frameRate); err != nil {
+		t.Fatalf("WriteNoveltyCSV failed: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != len(novelty)+1 {
+		t.Fatalf("Expected header and %d rows, got %d records", len(novelty), len(records))
+	}
+	if records[0][0] != "time" || records[0][1] != "novelty" {
+		t.Errorf("Expected time,novelty header, got %v", records[0])
+	}
+
+	// Each row holds the frame time and its value
+	for i, record := range records[1:] {
+		timeSec, err1 := strconv.ParseFloat(record[0], 64)
+		value, err2 := strconv.ParseFloat(record[1], 64)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("Row %d is not numeric: %v", i, record)
+		}
+		if math.Abs(timeSec-float64(i)/frameRate) > 1e-6 || value != novelty[i] {
+			t.Fatalf("Row %d: expected %.6f,%g, got %v", i, float64(i)/frameRate, novelty[i], record)
+		}
+	}
+
+	if err := WriteNoveltyCSV(&buf, novelty, 0); err == nil {
+		t.Error("Expected error for zero frame rate, got nil")
+	}
+}
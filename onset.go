@@ -1,27 +1,57 @@
 package onset
 
 import (
+	"math"
 	"strings"
 )
 
 // Onset represents an onset detection object
 type Onset struct {
-	Pv                *Pvoc
-	Od                *Specdesc
-	Pp                *PeakPicker
-	Fftgrain          *Cvec
-	Desc              *Fvec
-	Silence           float64
+	Pv       *Pvoc
+	Od       *Specdesc
+	Pp       *PeakPicker
+	Fftgrain *Cvec
+	Desc     *Fvec
+	Silence  float64
+	// SilenceClose is the level in dB below which the silence gate closes
+	// again once opened at Silence (see SetSilenceHysteresis)
+	SilenceClose      float64
 	Minioi            uint
+	Lookahead         uint
 	Delay             uint
 	Samplerate        uint
 	HopSize           uint
 	TotalFrames       uint
 	LastOnset         uint
+	LastStrength      float64
 	ApplyCompression  bool
 	LambdaCompression float64
 	ApplyAWhitening   bool
 	SpectralWhitening *SpectralWhitening
+	// Mel reduces the spectrum to mel bands before the descriptor, or is nil
+	// to use the linear spectrum (see SetMelBands)
+	Mel      *MelFilterbank
+	MelGrain *Cvec
+	// SmoothFrames is the length in frames of the moving average applied to
+	// the novelty before peak picking, or 0 or 1 for none (see
+	// SetNoveltySmoothing). SmoothHistory holds the last novelty values and
+	// Smoothed their mean.
+	SmoothFrames  uint
+	SmoothHistory *Fvec
+	Smoothed      *Fvec
+
+	// Candidate onset held back until the lookahead has passed
+	pending         bool
+	pendingOnset    uint
+	pendingStrength float64
+	pendingValue    float64
+
+	// gateOpen is whether the silence gate let the previous frame through
+	gateOpen bool
+
+	// onReject is called with the position in samples (before delay
+	// compensation) and the reason of every candidate onset that is dropped
+	onReject func(position uint, reason string)
 }
 
 // NewOnset creates a new onset detection object
@@ -43,10 +73,23 @@ func NewOnset(onsetMode string, bufSize, hopSize, samplerate uint) *Onset {
 	return o
 }
 
-// Do processes input and detects onsets
+// Do processes input and detects onsets. The input must hold exactly HopSize
+// samples, the next hop of the signal; to feed blocks of any size, use a
+// RealtimeDetector, which buffers them into hops.
 func (o *Onset) Do(input *Fvec, onset *Fvec) {
 	isonset := 0.0
 
+	// Emit a held candidate once no later peak within the lookahead can replace it
+	emitted := 0.0
+	if o.pending && o.TotalFrames >= o.pendingOnset+o.Lookahead {
+		o.pending = false
+		o.LastOnset = o.pendingOnset
+		o.LastStrength = o.pendingStrength
+		emitted = o.pendingValue
+	}
+
+	silent := o.silent(input)
+
 	// Phase vocoder
 	o.Pv.Do(input, o.Fftgrain)
 
@@ -55,36 +98,55 @@ func (o *Onset) Do(input *Fvec, onset *Fvec) {
 		o.SpectralWhitening.Do(o.Fftgrain)
 	}
 
+	// Reduce to mel bands if enabled
+	grain := o.Fftgrain
+	if o.Mel != nil {
+		o.Mel.Do(o.Fftgrain, o.MelGrain)
+		grain = o.MelGrain
+	}
+
 	// Apply compression if enabled
 	if o.ApplyCompression {
-		o.Fftgrain.LogMag(o.LambdaCompression)
+		grain.LogMag(o.LambdaCompression)
 	}
 
 	// Compute spectral descriptor
-	o.Od.Do(o.Fftgrain, o.Desc)
+	o.Od.Do(grain, o.Desc)
 
-	// Peak picking
-	o.Pp.Do(o.Desc, onset)
+	// Peak picking, on the smoothed novelty if enabled
+	if o.SmoothFrames > 1 {
+		FvecPush(o.SmoothHistory, o.Desc.Data[0])
+		o.Smoothed.Data[0] = FvecMean(o.SmoothHistory)
+		o.Pp.Do(o.Smoothed, onset)
+	} else {
+		o.Pp.Do(o.Desc, onset)
+	}
 	isonset = onset.Data[0]
 
 	if isonset > 0 {
-		if SilenceDetection(input, o.Silence) {
+		if silent {
 			// Silent onset, not marking
+			o.reject(o.peakPosition(isonset), "silence")
 			isonset = 0
 		} else {
 			// We have an onset
-			newOnset := o.TotalFrames + uint(Round(isonset*float64(o.HopSize)))
+			newOnset := o.peakPosition(isonset)
 
 			// Check if last onset time was more than minioi ago
 			if o.LastOnset+o.Minioi < newOnset {
 				// Start of file: make sure (new_onset - delay) >= 0
 				if o.LastOnset > 0 && o.Delay > newOnset {
 					isonset = 0
+				} else if o.Lookahead > 0 {
+					o.hold(Max(o.Delay, newOnset), o.Pp.GetPeakValue(), isonset)
+					isonset = 0
 				} else {
 					o.LastOnset = Max(o.Delay, newOnset)
+					o.LastStrength = o.Pp.GetPeakValue()
 				}
 			} else {
 				// Doubled onset, not marking
+				o.reject(newOnset, "minioi")
 				isonset = 0
 			}
 		}
@@ -92,20 +154,72 @@ func (o *Onset) Do(input *Fvec, onset *Fvec) {
 		// We are at the beginning of the file
 		if o.TotalFrames <= o.Delay {
 			// And we don't find silence
-			if !SilenceDetection(input, o.Silence) {
+			if !silent {
 				newOnset := o.TotalFrames
-				if o.TotalFrames == 0 || o.LastOnset+o.Minioi < newOnset {
+				if !o.pending && (o.TotalFrames == 0 || o.LastOnset+o.Minioi < newOnset) {
 					isonset = float64(o.Delay) / float64(o.HopSize)
 					o.LastOnset = o.TotalFrames + o.Delay
+					// No peak yet, use the raw novelty of this frame
+					o.LastStrength = o.Desc.Data[0]
 				}
 			}
 		}
 	}
 
+	if emitted > 0 {
+		isonset = emitted
+	}
+
 	onset.Data[0] = isonset
 	o.TotalFrames += o.HopSize
 }
 
+// peakPosition returns the position in samples of the peak reported by the
+// peak picker, isonset hops into the current frame, moved back by the half
+// window the moving average of the novelty delays peaks by
+func (o *Onset) peakPosition(isonset float64) uint {
+	position := o.TotalFrames + uint(Round(isonset*float64(o.HopSize)))
+	if o.SmoothFrames > 1 {
+		position -= min(position, (o.SmoothFrames-1)*o.HopSize/2)
+	}
+	return position
+}
+
+// silent updates the silence gate with the level of the input and reports
+// whether the gate is closed. The gate opens when the level reaches Silence
+// and closes when it falls below SilenceClose, and keeps its state in between.
+func (o *Onset) silent(input *Fvec) bool {
+	if !SilenceDetection(input, o.Silence) {
+		o.gateOpen = true
+	} else if SilenceDetection(input, o.SilenceClose) {
+		o.gateOpen = false
+	}
+	return !o.gateOpen
+}
+
+// hold keeps a candidate onset until the lookahead has passed, replacing the
+// held candidate if this one is stronger
+func (o *Onset) hold(position uint, strength, value float64) {
+	if o.pending && strength <= o.pendingStrength {
+		o.reject(position, "lookahead")
+		return
+	}
+	if o.pending {
+		o.reject(o.pendingOnset, "lookahead")
+	}
+	o.pending = true
+	o.pendingOnset = position
+	o.pendingStrength = strength
+	o.pendingValue = value
+}
+
+// reject reports a dropped candidate onset to onReject, if set
+func (o *Onset) reject(position uint, reason string) {
+	if o.onReject != nil {
+		o.onReject(position, reason)
+	}
+}
+
 // GetLast returns the time of the latest onset detected, in samples
 func (o *Onset) GetLast() uint {
 	if o.Delay > o.LastOnset {
@@ -124,6 +238,12 @@ func (o *Onset) GetLastMs() float64 {
 	return o.GetLastS() * 1000.0
 }
 
+// GetLastStrength returns the novelty value of the latest onset detected,
+// i.e. the height of the thresholded detection function peak that triggered it
+func (o *Onset) GetLastStrength() float64 {
+	return o.LastStrength
+}
+
 // SetAWhitening enables or disables adaptive whitening
 func (o *Onset) SetAWhitening(enable bool) {
 	o.ApplyAWhitening = enable
@@ -134,6 +254,29 @@ func (o *Onset) GetAWhitening() bool {
 	return o.ApplyAWhitening
 }
 
+// PrimeWhitening runs the adaptive whitening over the samples, up to its relax
+// time, without detecting onsets, so the whitening starts from the spectral
+// peaks of the signal instead of adapting during the first onsets. Call it
+// before the first Do, e.g. with the whole signal when it is known in
+// advance. It does nothing when whitening is disabled.
+func (o *Onset) PrimeWhitening(samples []float64) {
+	if !o.ApplyAWhitening {
+		return
+	}
+
+	hop := int(o.HopSize)
+	limit := min(len(samples), int(o.SpectralWhitening.RelaxTime*float64(o.Samplerate)))
+	input := NewFvec(o.HopSize)
+	for start := 0; start < limit; start += hop {
+		n := copy(input.Data, samples[start:min(start+hop, len(samples))])
+		for i := n; i < hop; i++ {
+			input.Data[i] = 0
+		}
+		o.Pv.Do(input, o.Fftgrain)
+		o.SpectralWhitening.Do(o.Fftgrain)
+	}
+}
+
 // SetCompression sets the compression lambda value
 func (o *Onset) SetCompression(lambda float64) {
 	if lambda < 0 {
@@ -151,16 +294,34 @@ func (o *Onset) GetCompression() float64 {
 	return 0
 }
 
-// SetSilence sets the silence threshold
+// SetSilence sets the silence threshold, which both opens and closes the
+// silence gate (no hysteresis)
 func (o *Onset) SetSilence(silence float64) {
 	o.Silence = silence
+	o.SilenceClose = silence
 }
 
-// GetSilence returns the silence threshold
+// GetSilence returns the silence threshold, the level at which the gate opens
 func (o *Onset) GetSilence() float64 {
 	return o.Silence
 }
 
+// SetSilenceHysteresis sets separate thresholds in dB for the silence gate:
+// it opens when the level of a frame reaches openDb and closes only when it
+// falls below closeDb, so a signal hovering around a single threshold does
+// not switch the gate on and off and produce bursts of onsets. A closeDb
+// above openDb is clamped to openDb, which is the same as SetSilence(openDb).
+func (o *Onset) SetSilenceHysteresis(openDb, closeDb float64) {
+	o.Silence = openDb
+	o.SilenceClose = math.Min(closeDb, openDb)
+}
+
+// GetSilenceHysteresis returns the levels in dB at which the silence gate
+// opens and closes
+func (o *Onset) GetSilenceHysteresis() (openDb, closeDb float64) {
+	return o.Silence, o.SilenceClose
+}
+
 // SetThreshold sets the peak picking threshold
 func (o *Onset) SetThreshold(threshold float64) {
 	o.Pp.SetThreshold(threshold)
@@ -171,6 +332,17 @@ func (o *Onset) GetThreshold() float64 {
 	return o.Pp.GetThreshold()
 }
 
+// SetThresholdDb sets the peak picking threshold in dB relative to the running
+// mean of the novelty (0 dB is a linear threshold of 1). See PeakPicker.SetThresholdDb.
+func (o *Onset) SetThresholdDb(db float64) {
+	o.Pp.SetThresholdDb(db)
+}
+
+// GetThresholdDb returns the peak picking threshold in dB
+func (o *Onset) GetThresholdDb() float64 {
+	return o.Pp.GetThresholdDb()
+}
+
 // SetMinioi sets the minimum inter-onset interval in samples
 func (o *Onset) SetMinioi(minioi uint) {
 	o.Minioi = minioi
@@ -191,7 +363,11 @@ func (o *Onset) GetMinioiS() float64 {
 	return float64(o.Minioi) / float64(o.Samplerate)
 }
 
-// SetMinioiMs sets the minimum inter-onset interval in milliseconds
+// SetMinioiMs sets the minimum inter-onset interval in milliseconds. The
+// interval is rounded to minioi*Samplerate/1000 samples and an onset is kept
+// only when it lies more than that many samples after the previous one, so
+// the number of hops it spans depends on the sample rate and hop size. Use
+// SetMinioiFrames to set it as an exact number of hops.
 func (o *Onset) SetMinioiMs(minioi float64) {
 	o.SetMinioiS(minioi / 1000.0)
 }
@@ -201,6 +377,120 @@ func (o *Onset) GetMinioiMs() float64 {
 	return o.GetMinioiS() * 1000.0
 }
 
+// SetMinioiFrames sets the minimum inter-onset interval in hops (frames):
+// an onset is kept only when it lies at least n*HopSize samples after the
+// previous one. Zero or a negative n disables the interval.
+func (o *Onset) SetMinioiFrames(n int) {
+	if n <= 0 {
+		o.SetMinioi(0)
+		return
+	}
+	// Minioi is exclusive, so n hops apart must exceed n*HopSize-1 samples
+	o.SetMinioi(uint(n)*o.HopSize - 1)
+}
+
+// GetMinioiFrames returns the minimum inter-onset interval as the smallest
+// number of whole hops allowed between two onsets, or 0 if it is disabled
+func (o *Onset) GetMinioiFrames() int {
+	if o.Minioi == 0 {
+		return 0
+	}
+	return int(o.Minioi/o.HopSize) + 1
+}
+
+// SetMelBands reduces the spectrum to numBands mel bands before the spectral
+// descriptor, so low-frequency onsets such as a bassline weigh as much as
+// high-frequency ones. Magnitude descriptors (energy, hfc, specdiff, kl, mkl,
+// specflux, complexity) then work on the bands; the band phases are zero, so
+// phase-based descriptors should be used on the linear spectrum. The
+// descriptor is recreated for the new size, which clears its history and
+// bin weighting. Zero restores the linear spectrum.
+func (o *Onset) SetMelBands(numBands uint) {
+	bufSize := o.Pv.WinSize
+	numBins := bufSize/2 + 1
+	if numBands > 0 {
+		o.Mel = NewMelFilterbank(numBands, bufSize, o.Samplerate)
+		o.MelGrain = o.Mel.NewBands()
+		numBins = numBands
+	} else {
+		o.Mel = nil
+		o.MelGrain = nil
+	}
+
+	od := NewSpecdesc("", 2*(numBins-1))
+	od.OnsetType = o.Od.OnsetType
+	od.Threshold = o.Od.Threshold
+	o.Od = od
+}
+
+// SetNoveltySmoothing smooths the novelty with a moving average over the
+// last frames frames before peak picking, so a jittery detection function
+// does not trigger twice on one onset. Unlike the adaptive median threshold of
+// the peak picker, it smooths the novelty itself. Onset times are corrected
+// for the delay of the average. Zero or 1 disables the smoothing; the
+// smoothing history is cleared.
+func (o *Onset) SetNoveltySmoothing(frames uint) {
+	o.SmoothFrames = frames
+	o.SmoothHistory = nil
+	o.Smoothed = nil
+	if frames > 1 {
+		o.SmoothHistory = NewFvec(frames)
+		o.Smoothed = NewFvec(1)
+	}
+}
+
+// GetNoveltySmoothing returns the length in frames of the moving average of
+// the novelty, or 0 if it is disabled
+func (o *Onset) GetNoveltySmoothing() uint {
+	if o.SmoothFrames <= 1 {
+		return 0
+	}
+	return o.SmoothFrames
+}
+
+// SetWindow sets the analysis window of the phase vocoder (see
+// Pvoc.SetWindow). It returns an error for an unknown window.
+func (o *Onset) SetWindow(name string) error {
+	return o.Pv.SetWindow(name)
+}
+
+// GetWindow returns the name of the analysis window
+func (o *Onset) GetWindow() string {
+	return o.Pv.WindowName
+}
+
+// GetMelBands returns the number of mel bands, or 0 for the linear spectrum
+func (o *Onset) GetMelBands() uint {
+	if o.Mel == nil {
+		return 0
+	}
+	return o.Mel.NumBands
+}
+
+// SetLookahead sets the lookahead in samples. A peak is only reported once no
+// stronger peak follows within the lookahead (rounded up to whole hops), which
+// suppresses a small bump right before a larger peak. The onset is reported up
+// to the lookahead later, but GetLast still returns the time of the peak.
+// Zero disables the lookahead.
+func (o *Onset) SetLookahead(lookahead uint) {
+	o.Lookahead = lookahead
+}
+
+// GetLookahead returns the lookahead in samples
+func (o *Onset) GetLookahead() uint {
+	return o.Lookahead
+}
+
+// SetLookaheadMs sets the lookahead in milliseconds
+func (o *Onset) SetLookaheadMs(lookahead float64) {
+	o.SetLookahead(uint(Round(lookahead / 1000.0 * float64(o.Samplerate))))
+}
+
+// GetLookaheadMs returns the lookahead in milliseconds
+func (o *Onset) GetLookaheadMs() float64 {
+	return float64(o.Lookahead) / float64(o.Samplerate) * 1000.0
+}
+
 // SetDelay sets the constant delay in samples
 func (o *Onset) SetDelay(delay uint) {
 	o.Delay = delay
@@ -245,7 +535,10 @@ func (o *Onset) GetThresholdedDescriptor() float64 {
 // Reset resets the onset detection state
 func (o *Onset) Reset() {
 	o.LastOnset = 0
+	o.LastStrength = 0
 	o.TotalFrames = 0
+	o.pending = false
+	o.gateOpen = false
 }
 
 // SetDefaultParameters sets default parameters based on onset mode
@@ -63,6 +63,54 @@ func TestPeakPicker(t *testing.T) {
 	}
 }
 
+func TestThresholdDb(t *testing.T) {
+	pp := NewPeakPicker()
+
+	// The default threshold is the -20 dB reference point
+	if math.Abs(pp.GetThresholdDb()+20) > 1e-9 {
+		t.Errorf("Expected default threshold -20 dB, got %f", pp.GetThresholdDb())
+	}
+
+	pp.SetThresholdDb(0)
+	if pp.GetThreshold() != 1 {
+		t.Errorf("Expected 0 dB to be a linear threshold of 1, got %f", pp.GetThreshold())
+	}
+
+	pp.SetThresholdDb(-20)
+	if math.Abs(pp.GetThreshold()-NewPeakPicker().GetThreshold()) > 1e-12 {
+		t.Errorf("Expected -20 dB to match the default threshold, got %f", pp.GetThreshold())
+	}
+
+	// Setting the default hfc threshold in dB detects the same onsets
+	samplerate := uint(44100)
+	hopSize := uint(256)
+	samples := clickTrack(samplerate, 2.0, []float64{0.1, 0.5, 0.9, 1.3, 1.7}, 0.8)
+
+	linear := NewOnset("hfc", 512, hopSize, samplerate)
+	db := NewOnset("hfc", 512, hopSize, samplerate)
+	db.SetThresholdDb(20 * math.Log10(linear.GetThreshold()))
+
+	input := NewFvec(hopSize)
+	linearOut := NewFvec(1)
+	dbOut := NewFvec(1)
+	count := 0
+	for pos := 0; pos+int(hopSize) < len(samples); pos += int(hopSize) {
+		copy(input.Data, samples[pos:pos+int(hopSize)])
+		linear.Do(input, linearOut)
+		db.Do(input, dbOut)
+		if linearOut.Data[0] != dbOut.Data[0] {
+			t.Fatalf("Outputs differ at sample %d: %f vs %f", pos, linearOut.Data[0], dbOut.Data[0])
+		}
+		if linearOut.Data[0] > 0 {
+			count++
+		}
+	}
+
+	if count == 0 {
+		t.Error("Expected some onsets to be detected")
+	}
+}
+
 func TestSpecdesc(t *testing.T) {
 	bufSize := uint(512)
 	s := NewSpecdesc("hfc", bufSize)
@@ -78,6 +126,123 @@ func TestSpecdesc(t *testing.T) {
 	}
 }
 
+func TestSpecdescComplexity(t *testing.T) {
+	// Notes are added every 250 ms, building a chord
+	sampleRate := 44100
+	freqs := []float64{330, 1250, 2900, 5200}
+	samples := make([]float64, sampleRate*5/4)
+	for i := range samples {
+		tm := float64(i) / float64(sampleRate)
+		for k, freq := range freqs {
+			if tm >= 0.25*float64(k) {
+				samples[i] += 0.2 * math.Sin(2*math.Pi*freq*tm)
+			}
+		}
+	}
+
+	s := NewSpecdesc("complexity", 512)
+	if s.OnsetType != OnsetComplexity {
+		t.Fatalf("Expected Complexity onset type")
+	}
+
+	// The peak count follows the number of notes in the middle of each section
+	pv := NewPvoc(512, 256)
+	spectrum := NewCvec(512)
+	input := NewFvec(256)
+	for k := range freqs {
+		start := (sampleRate/4*k + sampleRate/8) / 256 * 256
+		for _, offset := range []int{0, 256} {
+			copy(input.Data, samples[start+offset:start+offset+256])
+			pv.Do(input, spectrum)
+			novelty := s.DoFrame(spectrum)
+			if s.OldCount != float64(k+1) {
+				t.Errorf("Section %d: expected %d peaks, got %.0f", k, k+1, s.OldCount)
+			}
+			if offset > 0 && novelty != 0 {
+				t.Errorf("Section %d: expected no novelty within a steady chord, got %f", k, novelty)
+			}
+		}
+	}
+
+	// Each added note is an onset
+	result, err := AnalyzeSamples(samples, uint(sampleRate), SliceAnalyzerOptions{Method: "complexity"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != len(freqs) {
+		t.Fatalf("Expected %d onsets, got %v", len(freqs), result.Onsets)
+	}
+	for k, onset := range result.Onsets {
+		if math.Abs(onset-0.25*float64(k)) > 0.015 {
+			t.Errorf("Onset %d: expected near %.2fs, got %.4fs", k, 0.25*float64(k), onset)
+		}
+	}
+}
+
+func TestSpecdescDoFrame(t *testing.T) {
+	bufSize := uint(512)
+
+	// spectrum returns a frame with the given magnitude in bins [from, to)
+	// and the given phase in every bin
+	spectrum := func(from, to int, mag, phase float64) *Cvec {
+		c := NewCvec(bufSize)
+		for j := from; j < to; j++ {
+			c.Norm[j] = mag
+		}
+		for j := range c.Phas {
+			c.Phas[j] = phase
+		}
+		return c
+	}
+
+	// Energy is the sum of squared magnitudes
+	s := NewSpecdesc("energy", bufSize)
+	if got := s.DoFrame(spectrum(0, 10, 2, 0)); math.Abs(got-40) > 1e-9 {
+		t.Errorf("energy: expected 40, got %f", got)
+	}
+
+	// HFC grows as energy moves to higher bins
+	s = NewSpecdesc("hfc", bufSize)
+	low := s.DoFrame(spectrum(0, 10, 1, 0))
+	high := s.DoFrame(spectrum(200, 210, 1, 0))
+	if high <= low {
+		t.Errorf("hfc: expected high bins (%f) to score above low bins (%f)", high, low)
+	}
+
+	// Magnitude-difference descriptors respond to a rise and not to a steady spectrum
+	for _, method := range []string{"specdiff", "specflux", "kl", "mkl"} {
+		s := NewSpecdesc(method, bufSize)
+		s.DoFrame(spectrum(0, 0, 0, 0))
+		rise := s.DoFrame(spectrum(0, 100, 1, 0))
+		steady := s.DoFrame(spectrum(0, 100, 1, 0))
+		if rise <= steady {
+			t.Errorf("%s: expected a rise (%f) to score above a steady frame (%f)", method, rise, steady)
+		}
+	}
+
+	// Phase descriptors respond to a phase jump and not to a constant phase
+	for _, method := range []string{"phase", "wphase"} {
+		s := NewSpecdesc(method, bufSize)
+		s.DoFrame(spectrum(0, 100, 1, 0))
+		steady := s.DoFrame(spectrum(0, 100, 1, 0))
+		jump := s.DoFrame(spectrum(0, 100, 1, 1))
+		if steady != 0 || jump <= 0 {
+			t.Errorf("%s: expected 0 for constant phase and > 0 for a jump, got %f and %f", method, steady, jump)
+		}
+	}
+
+	// Complex domain predicts a linear phase advance, so only a break in it scores
+	s = NewSpecdesc("complex", bufSize)
+	for i := 0; i < 3; i++ {
+		s.DoFrame(spectrum(0, 100, 1, 0.5*float64(i)))
+	}
+	predicted := s.DoFrame(spectrum(0, 100, 1, 1.5))
+	unexpected := s.DoFrame(spectrum(0, 100, 1, 0))
+	if predicted > 1e-6 || unexpected <= predicted {
+		t.Errorf("complex: expected ~0 for a predicted frame and more for a break, got %f and %f", predicted, unexpected)
+	}
+}
+
 func TestOnsetCreation(t *testing.T) {
 	bufSize := uint(512)
 	hopSize := uint(256)
@@ -122,7 +287,7 @@ func TestOnsetMethods(t *testing.T) {
 	hopSize := uint(256)
 	samplerate := uint(44100)
 
-	methods := []string{"energy", "hfc", "complex", "phase", "specdiff", "kl", "mkl", "specflux"}
+	methods := []string{"energy", "hfc", "complex", "phase", "specdiff", "kl", "mkl", "specflux", "complexity"}
 
 	for _, method := range methods {
 		o := NewOnset(method, bufSize, hopSize, samplerate)
@@ -162,6 +327,125 @@ func TestOnsetThresholds(t *testing.T) {
 	}
 }
 
+// burstTrain returns short identical noise bursts every spacing hops
+func burstTrain(hopSize uint, spacing, count int) []float64 {
+	samples := make([]float64, (20+spacing*count+20)*int(hopSize))
+	for b := 0; b < count; b++ {
+		start := (20+b*spacing)*int(hopSize) + int(hopSize)/4
+		seed := uint32(1)
+		for i := 0; i < 200; i++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(math.MaxUint32)*2 - 1
+			samples[start+i] = 0.8 * noise * math.Exp(-float64(i)/40)
+		}
+	}
+	return samples
+}
+
+func TestMinioiFrames(t *testing.T) {
+	bufSize := uint(512)
+	hopSize := uint(256)
+	samplerate := uint(44100)
+
+	detect := func(samples []float64, frames int) []uint {
+		o := NewOnset("hfc", bufSize, hopSize, samplerate)
+		o.SetSilence(-200)
+		o.SetMinioiFrames(frames)
+		if o.GetMinioiFrames() != frames {
+			t.Fatalf("Expected minioi of %d frames, got %d", frames, o.GetMinioiFrames())
+		}
+		input := NewFvec(hopSize)
+		output := NewFvec(1)
+		var onsets []uint
+		for start := 0; start+int(hopSize) <= len(samples); start += int(hopSize) {
+			copy(input.Data, samples[start:start+int(hopSize)])
+			o.Do(input, output)
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLast())
+			}
+		}
+		// Skip the start of file onset and the first burst while the
+		// peak picker settles
+		return onsets[2:]
+	}
+
+	checkGaps := func(onsets []uint, hops uint) {
+		t.Helper()
+		if len(onsets) < 4 {
+			t.Fatalf("Expected at least 4 onsets, got %d", len(onsets))
+		}
+		for i := 1; i < len(onsets); i++ {
+			if gap := onsets[i] - onsets[i-1]; gap != hops*hopSize {
+				t.Errorf("Onset %d: expected a gap of %d hops, got %d samples", i, hops, gap)
+			}
+		}
+	}
+
+	// Bursts exactly 4 hops apart are all kept, 3 hops apart every other one is dropped
+	checkGaps(detect(burstTrain(hopSize, 4, 12), 4), 4)
+	checkGaps(detect(burstTrain(hopSize, 3, 12), 4), 6)
+	// One more hop of refractory thins the 4 hop train
+	checkGaps(detect(burstTrain(hopSize, 4, 12), 5), 8)
+}
+
+// addBurst adds a decaying noise burst of the given amplitude at start
+func addBurst(samples []float64, start int, amplitude float64) {
+	seed := uint32(7)
+	for i := 0; i < 2000 && start+i < len(samples); i++ {
+		seed = seed*1664525 + 1013904223
+		noise := float64(seed)/float64(math.MaxUint32)*2 - 1
+		samples[start+i] += amplitude * noise * math.Exp(-float64(i)/400)
+	}
+}
+
+func TestLookahead(t *testing.T) {
+	// A small bump 20 ms before a much larger peak
+	samples := make([]float64, 44100)
+	addBurst(samples, 11025, 0.1)
+	addBurst(samples, 11025+882, 0.8)
+
+	detect := func(lookaheadMs float64) (onsets []uint, strengths []float64, reported []int) {
+		o := NewOnset("hfc", 512, 256, 44100)
+		o.SetLookaheadMs(lookaheadMs)
+		input := NewFvec(256)
+		output := NewFvec(1)
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLast())
+				strengths = append(strengths, o.GetLastStrength())
+				reported = append(reported, start)
+			}
+		}
+		return onsets, strengths, reported
+	}
+
+	// Without lookahead the bump is reported and masks the peak through minioi
+	bump, bumpStrengths, _ := detect(0)
+	if len(bump) != 1 || bump[0] >= 11025+441 {
+		t.Fatalf("Expected only the bump without lookahead, got %v", bump)
+	}
+
+	peak, peakStrengths, reported := detect(30)
+	if len(peak) != 1 {
+		t.Fatalf("Expected a single onset with lookahead, got %v", peak)
+	}
+	if peak[0] < 11025+441 || peak[0] > 11025+882+512 {
+		t.Errorf("Expected the onset at the larger peak near %d, got %d", 11025+882, peak[0])
+	}
+	if peakStrengths[0] <= bumpStrengths[0] {
+		t.Errorf("Expected the peak to be stronger than the bump, got %f and %f", peakStrengths[0], bumpStrengths[0])
+	}
+	// Reported later than the peak time, by no more than the lookahead plus
+	// the detection delay and peak picker lag
+	o := NewOnset("hfc", 512, 256, 44100)
+	maxLag := int(o.Delay) + int(o.Pp.WinPre+3)*256 + 1323
+	if lag := reported[0] - int(peak[0]); lag <= 0 || lag > maxLag {
+		t.Errorf("Expected the onset reported within %d samples of the peak, got %d", maxLag, lag)
+	}
+}
+
 func TestSpectralWhitening(t *testing.T) {
 	bufSize := uint(512)
 	hopSize := uint(256)
@@ -224,6 +508,45 @@ func TestFilter(t *testing.T) {
 	// Just check it doesn't crash
 }
 
+func TestFilterBlocks(t *testing.T) {
+	signal := make([]float64, 1000)
+	for i := range signal {
+		signal[i] = math.Sin(2*math.Pi*float64(i)/37) + 0.5*math.Sin(2*math.Pi*float64(i)/5)
+	}
+
+	whole := NewFvec(uint(len(signal)))
+	copy(whole.Data, signal)
+	f := NewBiquadFilter(0.15998789, 0.31997577, 0.15998789, 0.23484048, 0)
+	f.Do(whole)
+
+	// Filtering in two uneven halves carries the history across the boundary
+	f.Reset()
+	first := NewFvec(437)
+	second := NewFvec(uint(len(signal)) - first.Length)
+	copy(first.Data, signal)
+	copy(second.Data, signal[first.Length:])
+	f.Do(first)
+	f.Do(second)
+
+	blocks := append(append([]float64{}, first.Data...), second.Data...)
+	for i := range blocks {
+		if math.Abs(blocks[i]-whole.Data[i]) > 1e-12 {
+			t.Fatalf("Sample %d: expected %f, got %f", i, whole.Data[i], blocks[i])
+		}
+	}
+
+	// Reset clears the history, so the next block starts fresh
+	f.Reset()
+	restart := NewFvec(first.Length)
+	copy(restart.Data, signal)
+	f.Do(restart)
+	for i := range restart.Data {
+		if restart.Data[i] != first.Data[i] {
+			t.Fatalf("Sample %d after Reset: expected %f, got %f", i, first.Data[i], restart.Data[i])
+		}
+	}
+}
+
 func BenchmarkOnsetDetection(b *testing.B) {
 	bufSize := uint(512)
 	hopSize := uint(256)
@@ -465,3 +788,68 @@ func TestOptimalOnsetParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestNoveltySmoothing(t *testing.T) {
+	// Noise whose level jumps randomly every hop, giving a jittery novelty,
+	// with a strong burst at 1s
+	sampleRate := uint(44100)
+	hopSize := uint(256)
+	samples := clickTrack(sampleRate, 2.0, []float64{1.0}, 0.9)
+	seed := uint32(11)
+	random := func() float64 {
+		seed = seed*1664525 + 1013904223
+		return float64(seed) / float64(math.MaxUint32)
+	}
+	level := 0.0
+	for i := range samples {
+		if i%int(hopSize) == 0 {
+			level = 0.02 + 0.06*random()
+		}
+		samples[i] += level * (2*random() - 1)
+	}
+
+	detect := func(smoothFrames uint) []float64 {
+		o := NewOnset("energy", 2*hopSize, hopSize, sampleRate)
+		o.SetThreshold(0.02)
+		o.SetMinioiMs(10)
+		o.SetNoveltySmoothing(smoothFrames)
+		input := NewFvec(hopSize)
+		output := NewFvec(1)
+		var onsets []float64
+		for start := 0; start+int(hopSize) <= len(samples); start += int(hopSize) {
+			copy(input.Data, samples[start:])
+			o.Do(input, output)
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLastS())
+			}
+		}
+		return onsets
+	}
+
+	nearBurst := func(onsets []float64) (found bool, spurious int) {
+		for _, onsetTime := range onsets {
+			if math.Abs(onsetTime-1.0) < 0.02 {
+				found = true
+			} else if onsetTime > 0.05 {
+				spurious++
+			}
+		}
+		return found, spurious
+	}
+
+	rawFound, rawSpurious := nearBurst(detect(0))
+	found, spurious := nearBurst(detect(5))
+	t.Logf("Spurious onsets: %d raw, %d smoothed", rawSpurious, spurious)
+	if !rawFound || !found {
+		t.Errorf("Expected the burst with and without smoothing, got %v and %v", rawFound, found)
+	}
+	if spurious >= rawSpurious {
+		t.Errorf("Expected smoothing to reduce the spurious onsets (%d), got %d", rawSpurious, spurious)
+	}
+
+	o := NewOnset("energy", 512, 256, sampleRate)
+	o.SetNoveltySmoothing(1)
+	if o.GetNoveltySmoothing() != 0 {
+		t.Errorf("Expected a 1-frame average to disable smoothing, got %d", o.GetNoveltySmoothing())
+	}
+}
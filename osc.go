@@ -0,0 +1,121 @@
+package onset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+)
+
+// oscBundleSize is the maximum number of onset messages sent in one OSC bundle,
+// which keeps every packet well below common UDP size limits
+const oscBundleSize = 32
+
+// StreamOSC analyzes the samples and sends the detected onsets over UDP to addr
+// (e.g. "127.0.0.1:9000") as OSC messages. See WriteOSC for the message schema.
+func StreamOSC(samples []float64, sampleRate uint, addr string, oscPath string, options SliceAnalyzerOptions) error {
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		return err
+	}
+
+	energies := make([]float64, len(result.Onsets))
+	for i, onsetTime := range result.Onsets {
+		energies[i] = calculateOnsetEnergy(samples, sampleRate, onsetTime)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	return WriteOSC(conn, oscPath, result.Onsets, energies)
+}
+
+// WriteOSC writes one OSC 1.0 message per onset to w, grouped in OSC bundles of
+// up to 32 messages with the "immediately" time tag. Each bundle is written with
+// a single Write call, so every bundle is one packet on a UDP connection.
+//
+// Every message is addressed to oscPath and has the type tags ",iff":
+//   - int32: index of the onset
+//   - float32: onset time in seconds
+//   - float32: RMS energy of the onset (0 when energies is shorter than onsets)
+func WriteOSC(w io.Writer, oscPath string, onsets, energies []float64) error {
+	for start := 0; start < len(onsets); start += oscBundleSize {
+		end := start + oscBundleSize
+		if end > len(onsets) {
+			end = len(onsets)
+		}
+
+		messages := make([][]byte, 0, end-start)
+		for i := start; i < end; i++ {
+			energy := 0.0
+			if i < len(energies) {
+				energy = energies[i]
+			}
+			messages = append(messages, encodeOSCMessage(oscPath, int32(i), float32(onsets[i]), float32(energy)))
+		}
+
+		if _, err := w.Write(encodeOSCBundle(messages)); err != nil {
+			return fmt.Errorf("failed to write OSC bundle: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// encodeOSCMessage encodes an OSC message with int32, float32 and string arguments
+func encodeOSCMessage(address string, args ...interface{}) []byte {
+	var buf bytes.Buffer
+	writeOSCString(&buf, address)
+
+	tags := ","
+	for _, arg := range args {
+		switch arg.(type) {
+		case int32:
+			tags += "i"
+		case float32:
+			tags += "f"
+		case string:
+			tags += "s"
+		}
+	}
+	writeOSCString(&buf, tags)
+
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case int32:
+			binary.Write(&buf, binary.BigEndian, v)
+		case float32:
+			binary.Write(&buf, binary.BigEndian, math.Float32bits(v))
+		case string:
+			writeOSCString(&buf, v)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// encodeOSCBundle wraps OSC messages in a bundle with the "immediately" time tag
+func encodeOSCBundle(messages [][]byte) []byte {
+	var buf bytes.Buffer
+	writeOSCString(&buf, "#bundle")
+	binary.Write(&buf, binary.BigEndian, uint64(1))
+
+	for _, message := range messages {
+		binary.Write(&buf, binary.BigEndian, int32(len(message)))
+		buf.Write(message)
+	}
+
+	return buf.Bytes()
+}
+
+// writeOSCString writes a null-terminated string padded to a multiple of 4 bytes
+func writeOSCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	padding := 4 - len(s)%4
+	buf.Write(make([]byte, padding))
+}
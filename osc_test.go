@@ -0,0 +1,183 @@
+package onset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// oscTestMessage is a decoded OSC message with ",iff" arguments
+type oscTestMessage struct {
+	address string
+	index   int32
+	time    float32
+	energy  float32
+}
+
+// readOSCString reads a padded OSC string and returns it with the remaining data
+func readOSCString(t *testing.T, data []byte) (string, []byte) {
+	t.Helper()
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		t.Fatal("OSC string is not null-terminated")
+	}
+	size := (end/4 + 1) * 4
+	if size > len(data) {
+		t.Fatal("OSC string padding exceeds packet")
+	}
+	for _, b := range data[end:size] {
+		if b != 0 {
+			t.Fatal("OSC string padding is not zero")
+		}
+	}
+	return string(data[:end]), data[size:]
+}
+
+// decodeOSCBundle validates an OSC bundle of ",iff" messages and decodes it
+func decodeOSCBundle(t *testing.T, packet []byte) []oscTestMessage {
+	t.Helper()
+	if len(packet)%4 != 0 {
+		t.Fatalf("OSC packet size %d is not a multiple of 4", len(packet))
+	}
+
+	header, rest := readOSCString(t, packet)
+	if header != "#bundle" {
+		t.Fatalf("Expected #bundle header, got %q", header)
+	}
+	if binary.BigEndian.Uint64(rest[:8]) != 1 {
+		t.Errorf("Expected immediate time tag, got %d", binary.BigEndian.Uint64(rest[:8]))
+	}
+	rest = rest[8:]
+
+	var messages []oscTestMessage
+	for len(rest) > 0 {
+		size := int(binary.BigEndian.Uint32(rest[:4]))
+		if size%4 != 0 || size > len(rest)-4 {
+			t.Fatalf("Invalid bundle element size %d", size)
+		}
+		message := rest[4 : 4+size]
+		rest = rest[4+size:]
+
+		var m oscTestMessage
+		var tags string
+		m.address, message = readOSCString(t, message)
+		tags, message = readOSCString(t, message)
+		if tags != ",iff" {
+			t.Fatalf("Expected type tags ,iff, got %q", tags)
+		}
+		if len(message) != 12 {
+			t.Fatalf("Expected 12 bytes of arguments, got %d", len(message))
+		}
+		m.index = int32(binary.BigEndian.Uint32(message[0:4]))
+		m.time = math.Float32frombits(binary.BigEndian.Uint32(message[4:8]))
+		m.energy = math.Float32frombits(binary.BigEndian.Uint32(message[8:12]))
+		messages = append(messages, m)
+	}
+
+	return messages
+}
+
+// packetRecorder records each Write call as a separate packet
+type packetRecorder struct {
+	packets [][]byte
+}
+
+func (p *packetRecorder) Write(data []byte) (int, error) {
+	p.packets = append(p.packets, append([]byte{}, data...))
+	return len(data), nil
+}
+
+func TestWriteOSC(t *testing.T) {
+	onsets := make([]float64, 40)
+	energies := make([]float64, 40)
+	for i := range onsets {
+		onsets[i] = float64(i) * 0.25
+		energies[i] = float64(i) / 40
+	}
+
+	recorder := &packetRecorder{}
+	if err := WriteOSC(recorder, "/onset", onsets, energies); err != nil {
+		t.Fatalf("WriteOSC failed: %v", err)
+	}
+
+	// 40 onsets are split into bundles of 32 and 8 messages
+	if len(recorder.packets) != 2 {
+		t.Fatalf("Expected 2 bundles, got %d", len(recorder.packets))
+	}
+
+	var messages []oscTestMessage
+	for _, packet := range recorder.packets {
+		messages = append(messages, decodeOSCBundle(t, packet)...)
+	}
+
+	if len(messages) != len(onsets) {
+		t.Fatalf("Expected %d messages, got %d", len(onsets), len(messages))
+	}
+
+	for i, m := range messages {
+		if m.address != "/onset" {
+			t.Errorf("Expected address /onset, got %q", m.address)
+		}
+		if m.index != int32(i) {
+			t.Errorf("Expected index %d, got %d", i, m.index)
+		}
+		if m.time != float32(onsets[i]) {
+			t.Errorf("Expected time %f, got %f", onsets[i], m.time)
+		}
+		if m.energy != float32(energies[i]) {
+			t.Errorf("Expected energy %f, got %f", energies[i], m.energy)
+		}
+	}
+}
+
+func TestEncodeOSCMessage(t *testing.T) {
+	// Address "/a" pads to 4 bytes, ",s" pads to 4 bytes, "abcd" pads to 8 bytes
+	message := encodeOSCMessage("/a", "abcd")
+	expected := []byte("/a\x00\x00,s\x00\x00abcd\x00\x00\x00\x00")
+	if !bytes.Equal(message, expected) {
+		t.Errorf("Expected %q, got %q", expected, message)
+	}
+}
+
+func TestStreamOSC(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("UDP not available: %v", err)
+	}
+	defer conn.Close()
+
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 2.0, []float64{0.2, 0.7, 1.2, 1.7}, 0.8)
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+
+	if err := StreamOSC(samples, sampleRate, conn.LocalAddr().String(), "/slice/onset", options); err != nil {
+		t.Fatalf("StreamOSC failed: %v", err)
+	}
+
+	packet := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(packet)
+	if err != nil {
+		t.Fatalf("Failed to receive OSC packet: %v", err)
+	}
+
+	messages := decodeOSCBundle(t, packet[:n])
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 onset messages, got %d", len(messages))
+	}
+
+	for _, m := range messages {
+		if !strings.HasPrefix(m.address, "/slice") {
+			t.Errorf("Unexpected address %q", m.address)
+		}
+		if m.energy <= 0 {
+			t.Errorf("Expected positive energy, got %f", m.energy)
+		}
+	}
+}
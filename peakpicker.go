@@ -1,8 +1,11 @@
 package onset
 
+import "math"
+
 // PeakPicker represents a peak picking object for onset detection
 type PeakPicker struct {
 	Threshold   float64
+	Delta       float64
 	WinPost     uint
 	WinPre      uint
 	Biquad      *Filter
@@ -17,14 +20,12 @@ type PeakPicker struct {
 func NewPeakPicker() *PeakPicker {
 	p := &PeakPicker{
 		Threshold: 0.1,
+		Delta:     1,
 		WinPost:   5,
 		WinPre:    1,
 	}
 
-	bufSize := p.WinPost + p.WinPre + 1
-	p.Scratch = NewFvec(bufSize)
-	p.OnsetKeep = NewFvec(bufSize)
-	p.OnsetProc = NewFvec(bufSize)
+	p.allocWindow()
 	p.OnsetPeek = NewFvec(3)
 	p.Thresholded = NewFvec(1)
 
@@ -49,9 +50,9 @@ func (p *PeakPicker) Do(onset *Fvec, out *Fvec) {
 	// Calculate mean
 	mean := FvecMean(p.OnsetProc)
 
-	// Calculate median
+	// Calculate median, reordering the scratch copy in place
 	p.Scratch.Copy(p.OnsetProc)
-	median := FvecMedian(p.Scratch)
+	median := medianInPlace(p.Scratch.Data[:p.Scratch.Length])
 
 	// Shift peek array
 	for j := uint(0); j < 2; j++ {
@@ -59,7 +60,7 @@ func (p *PeakPicker) Do(onset *Fvec, out *Fvec) {
 	}
 
 	// Calculate new thresholded value
-	p.Thresholded.Data[0] = p.OnsetProc.Data[p.WinPost] - median - mean*p.Threshold
+	p.Thresholded.Data[0] = p.OnsetProc.Data[p.WinPost] - median*p.Delta - mean*p.Threshold
 	p.OnsetPeek.Data[2] = p.Thresholded.Data[0]
 
 	// Check for peak
@@ -80,7 +81,66 @@ func (p *PeakPicker) GetThreshold() float64 {
 	return p.Threshold
 }
 
+// SetThresholdDb sets the peak picking threshold in dB relative to the running
+// mean of the novelty. A frame is a peak candidate when its smoothed novelty
+// exceeds the adaptive median by more than the running mean scaled by this
+// amount, so 0 dB requires the excess to equal the mean (a linear threshold of
+// 1), -20 dB requires a tenth of it (0.1) and +6 dB about twice it.
+func (p *PeakPicker) SetThresholdDb(db float64) {
+	p.Threshold = math.Pow(10, db/20)
+}
+
+// GetThresholdDb returns the peak picking threshold in dB relative to the
+// running mean of the novelty. A threshold of 0 returns -Inf.
+func (p *PeakPicker) GetThresholdDb() float64 {
+	return 20 * math.Log10(p.Threshold)
+}
+
+// SetMedianWindow sets the length in frames of the window over which the
+// adaptive median and mean are computed (default 7). The window always looks
+// WinPre frames ahead, so it must be at least WinPre+2 frames long; shorter
+// values are clamped. A shorter window adapts faster to level changes, e.g.
+// after a loud hit followed by a gap. The history is cleared.
+func (p *PeakPicker) SetMedianWindow(frames uint) {
+	if frames < p.WinPre+2 {
+		frames = p.WinPre + 2
+	}
+	p.WinPost = frames - p.WinPre - 1
+	p.allocWindow()
+}
+
+// GetMedianWindow returns the length in frames of the adaptive median window
+func (p *PeakPicker) GetMedianWindow() uint {
+	return p.WinPost + p.WinPre + 1
+}
+
+// SetDelta sets the multiplier of the adaptive median subtracted from the
+// novelty (default 1). Lower values make the threshold less dependent on the
+// recent novelty level.
+func (p *PeakPicker) SetDelta(delta float64) {
+	p.Delta = delta
+}
+
+// GetDelta returns the multiplier of the adaptive median
+func (p *PeakPicker) GetDelta() float64 {
+	return p.Delta
+}
+
+// allocWindow allocates the history buffers for the current window size
+func (p *PeakPicker) allocWindow() {
+	bufSize := p.WinPost + p.WinPre + 1
+	p.Scratch = NewFvec(bufSize)
+	p.OnsetKeep = NewFvec(bufSize)
+	p.OnsetProc = NewFvec(bufSize)
+}
+
 // GetThresholdedInput returns the thresholded input
 func (p *PeakPicker) GetThresholdedInput() *Fvec {
 	return p.Thresholded
 }
+
+// GetPeakValue returns the thresholded value at the center of the peak window,
+// which is the height of the peak when Do reports one
+func (p *PeakPicker) GetPeakValue() float64 {
+	return p.OnsetPeek.Data[1]
+}
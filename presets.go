@@ -0,0 +1,54 @@
+package onset
+
+import (
+	"fmt"
+	"strings"
+)
+
+// presetNames are the presets accepted by PresetOptions
+var presetNames = []string{"drums", "vocals", "full mix", "field recording"}
+
+// PresetOptions returns options tuned for a kind of material, as a starting
+// point instead of setting the options one by one. Names are case-insensitive
+// and "-" or "_" may be used instead of spaces:
+//   - "drums": hfc with sample-accurate positions and 60 ms spacing, for
+//     breaks and percussion loops
+//   - "vocals": complex domain with a low sensitivity and a 30 ms lookahead,
+//     so slow, tonal attacks give one onset per note
+//   - "full mix": spectral flux on 40 mel bands so the bass counts as much as
+//     the cymbals, with 100 ms spacing
+//   - "field recording": energy on the normalized input, keeping only the
+//     louder half of the sparse events
+//
+// It returns an error listing the valid names for an unknown preset.
+func PresetOptions(name string) (SliceAnalyzerOptions, error) {
+	options := DefaultSliceAnalyzerOptions()
+
+	key := strings.NewReplacer("-", " ", "_", " ").Replace(strings.ToLower(strings.TrimSpace(name)))
+	switch key {
+	case "drums":
+		options.Method = "hfc"
+		options.OptimizeWindowMs = 15.0
+		options.MinimumSpacing = 60.0
+		options.SubsampleRefine = true
+	case "vocals":
+		options.Method = "complex"
+		options.Optimize = false
+		options.Sensitivity = 0.3
+		options.LookaheadMs = 30.0
+	case "full mix":
+		options.Method = "specflux"
+		options.MelBands = 40
+		options.OptimizeWindowMs = 15.0
+		options.MinimumSpacing = 100.0
+	case "field recording":
+		options.Method = "energy"
+		options.NormalizeInput = true
+		options.Sensitivity = 0.2
+		options.EnergyPercentile = 50
+	default:
+		return SliceAnalyzerOptions{}, fmt.Errorf("unknown preset: %q (valid presets: %s)", name, strings.Join(presetNames, ", "))
+	}
+
+	return options, nil
+}
@@ -0,0 +1,47 @@
+package onset
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPresetOptions(t *testing.T) {
+	// amen.wav is a 2.8 second break with about 20 hits
+	counts := map[string][2]int{
+		"drums":           {15, 30},
+		"vocals":          {5, 25},
+		"full mix":        {10, 30},
+		"field recording": {3, 15},
+	}
+
+	for _, name := range presetNames {
+		t.Run(name, func(t *testing.T) {
+			options, err := PresetOptions(name)
+			if err != nil {
+				t.Fatalf("PresetOptions failed: %v", err)
+			}
+			result, err := AnalyzeSlices("amen.wav", options)
+			if err != nil {
+				t.Fatalf("AnalyzeSlices failed: %v", err)
+			}
+			bounds := counts[name]
+			if len(result.Onsets) < bounds[0] || len(result.Onsets) > bounds[1] {
+				t.Errorf("Expected %d to %d onsets, got %d", bounds[0], bounds[1], len(result.Onsets))
+			}
+		})
+	}
+
+	if _, err := PresetOptions("Full-Mix"); err != nil {
+		t.Errorf("Expected Full-Mix to match the full mix preset, got %v", err)
+	}
+
+	_, err := PresetOptions("jazz")
+	if err == nil {
+		t.Fatal("Expected error for an unknown preset, got nil")
+	}
+	for _, name := range presetNames {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected the error to list %q, got %q", name, err)
+		}
+	}
+}
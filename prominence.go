@@ -0,0 +1,81 @@
+package onset
+
+import "math"
+
+// prominenceWindowMs is how far on each side of a novelty peak its
+// prominence looks for the valleys
+const prominenceWindowMs = 100.0
+
+// noveltyProminence returns the topographic prominence of the novelty peak at
+// index peak as a fraction of its height: how far the peak rises above the
+// higher of the lowest novelty on its left and on its right. Each side is
+// searched up to window values away, stopping where the novelty rises above
+// the peak, so a bump on a high plateau has a small prominence and a peak
+// rising from a low baseline one close to 1. A side without values, at the
+// start or end of the curve, does not limit the prominence. It is 0 for a
+// peak of no height.
+func noveltyProminence(novelty []float64, peak, window int) float64 {
+	height := novelty[peak]
+	if height <= 0 {
+		return 0
+	}
+
+	// valley returns the lowest novelty from the peak in the direction step,
+	// or -Inf when there is no value on that side
+	valley := func(step int) float64 {
+		lowest := math.Inf(1)
+		for i := peak + step; i >= 0 && i < len(novelty) && absInt(i-peak) <= window; i += step {
+			if novelty[i] > height {
+				break
+			}
+			lowest = math.Min(lowest, novelty[i])
+		}
+		if math.IsInf(lowest, 1) {
+			return math.Inf(-1)
+		}
+		return lowest
+	}
+
+	base := math.Max(valley(-1), valley(1))
+	if math.IsInf(base, -1) {
+		return 1
+	}
+	return math.Max(height-base, 0) / height
+}
+
+// dropShallowPeaks drops the onsets whose novelty peak has a prominence, as
+// a fraction of its height (see noveltyProminence), below minProminence. The
+// peak of an onset is the highest novelty within two frames of the frame
+// its time maps back to. It returns the kept onsets with their strengths and
+// the dropped onsets.
+func (d *streamingDetector) dropShallowPeaks(minProminence float64) ([]float64, []float64, []float64) {
+	o := d.o
+	hopSize := float64(o.HopSize)
+	window := max(Round(prominenceWindowMs*float64(o.Samplerate)/1000.0/hopSize), 1)
+
+	kept := []float64{}
+	keptStrengths := []float64{}
+	var dropped []float64
+	for i, onsetTime := range d.onsets {
+		// The peak picker reports a peak WinPre+2 frames after it, and onset
+		// times are moved back by the delay
+		position := (onsetTime+d.offset)*float64(o.Samplerate) + float64(o.Delay)
+		frame := Round(position/hopSize) - int(o.Pp.WinPre) - 2
+
+		peak := -1
+		for k := max(frame-2, 0); k <= min(frame+2, len(d.curve)-1); k++ {
+			if peak < 0 || d.curve[k] > d.curve[peak] {
+				peak = k
+			}
+		}
+
+		if peak >= 0 && noveltyProminence(d.curve, peak, window) < minProminence {
+			dropped = append(dropped, onsetTime)
+		} else {
+			kept = append(kept, onsetTime)
+			keptStrengths = append(keptStrengths, d.strengths[i])
+		}
+	}
+
+	return kept, keptStrengths, dropped
+}
@@ -1,11 +1,41 @@
 package onset
 
 import (
+	"fmt"
 	"math"
 
 	"github.com/mjibson/go-dsp/fft"
 )
 
+// windowShapes maps the analysis window names to the window function of
+// sample i of n. The coherent gain of each window, the mean of its values, is
+// in windowCoherentGains.
+var windowShapes = map[string]func(i, n uint) float64{
+	"hann": func(i, n uint) float64 {
+		return 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(i)/float64(n))
+	},
+	"hamming": func(i, n uint) float64 {
+		return 0.54 - 0.46*math.Cos(2.0*math.Pi*float64(i)/float64(n))
+	},
+	"blackman": func(i, n uint) float64 {
+		x := 2.0 * math.Pi * float64(i) / float64(n)
+		return 0.42 - 0.5*math.Cos(x) + 0.08*math.Cos(2*x)
+	},
+	"rectangular": func(i, n uint) float64 {
+		return 1
+	},
+}
+
+// windowCoherentGains is the coherent gain of each analysis window, the
+// amplitude a sinusoid at a bin center keeps in the spectrum: 0.5 for hann,
+// 0.54 for hamming, 0.42 for blackman and 1 for rectangular
+var windowCoherentGains = map[string]float64{
+	"hann":        0.5,
+	"hamming":     0.54,
+	"blackman":    0.42,
+	"rectangular": 1,
+}
+
 // Pvoc represents a phase vocoder
 type Pvoc struct {
 	WinSize  uint      // window size
@@ -18,6 +48,12 @@ type Pvoc struct {
 	Grain    *Cvec     // current grain (FFT output)
 	OldGrain *Cvec     // previous grain
 	PrevPhas []float64 // previous phase values
+	// WindowName is the analysis window and WindowCorrection the factor the
+	// magnitudes are scaled by to match those of the hann window
+	WindowName       string
+	WindowCorrection float64
+
+	fft *fftWorkspace // allocation-free FFT, nil if WinSize is not a power of two
 }
 
 // NewPvoc creates a new phase vocoder
@@ -31,16 +67,35 @@ func NewPvoc(winSize, hopSize uint) *Pvoc {
 		Grain:    NewCvec(winSize),
 		OldGrain: NewCvec(winSize),
 		PrevPhas: make([]float64, winSize/2+1),
+		fft:      newFFTWorkspace(winSize),
 	}
 
 	// Create Hann window
-	for i := uint(0); i < winSize; i++ {
-		p.Window.Data[i] = 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(i)/float64(winSize))
-	}
+	p.SetWindow("hann")
 
 	return p
 }
 
+// SetWindow sets the analysis window: "hann" (the default), "hamming",
+// "blackman" or "rectangular". The magnitudes are corrected by the ratio of
+// the coherent gain of the hann window (0.5) to that of the window (0.54 for
+// hamming, 0.42 for blackman, 1 for rectangular), so the spectral energies,
+// and the novelty and absolute thresholds computed from them, stay on the
+// same scale whichever window is chosen.
+func (p *Pvoc) SetWindow(name string) error {
+	shape, ok := windowShapes[name]
+	if !ok {
+		return fmt.Errorf("unknown window: %q", name)
+	}
+
+	for i := uint(0); i < p.WinSize; i++ {
+		p.Window.Data[i] = shape(i, p.WinSize)
+	}
+	p.WindowName = name
+	p.WindowCorrection = windowCoherentGains["hann"] / windowCoherentGains[name]
+	return nil
+}
+
 // Do processes input through phase vocoder
 func (p *Pvoc) Do(input *Fvec, fftgrain *Cvec) {
 	// Copy input to FFT buffer with windowing
@@ -53,13 +108,18 @@ func (p *Pvoc) Do(input *Fvec, fftgrain *Cvec) {
 	}
 
 	// Perform FFT
-	fftResult := fft.FFTReal(p.Fft.Data)
+	var fftResult []complex128
+	if p.fft != nil {
+		fftResult = p.fft.realFFT(p.Fft.Data)
+	} else {
+		fftResult = fft.FFTReal(p.Fft.Data)
+	}
 
 	// Convert to polar form (magnitude and phase)
 	for i := uint(0); i < fftgrain.Length; i++ {
 		real := real(fftResult[i])
 		imag := imag(fftResult[i])
-		fftgrain.Norm[i] = math.Sqrt(real*real + imag*imag)
+		fftgrain.Norm[i] = math.Sqrt(real*real+imag*imag) * p.WindowCorrection
 		fftgrain.Phas[i] = math.Atan2(imag, real)
 	}
 }
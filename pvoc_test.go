@@ -0,0 +1,36 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPvocWindow(t *testing.T) {
+	// A sinusoid at the center of bin 16 has the same magnitude with every
+	// window once corrected for its coherent gain
+	winSize := uint(1024)
+	input := NewFvec(winSize)
+	for i := range input.Data {
+		input.Data[i] = 0.5 * math.Sin(2*math.Pi*16*float64(i)/float64(winSize))
+	}
+
+	hann := NewCvec(winSize)
+	NewPvoc(winSize, winSize).Do(input, hann)
+
+	for _, name := range []string{"hamming", "blackman", "rectangular"} {
+		p := NewPvoc(winSize, winSize)
+		if err := p.SetWindow(name); err != nil {
+			t.Fatalf("SetWindow(%q) failed: %v", name, err)
+		}
+		grain := NewCvec(winSize)
+		p.Do(input, grain)
+
+		if math.Abs(grain.Norm[16]-hann.Norm[16]) > 1e-9*hann.Norm[16] {
+			t.Errorf("%s: expected magnitude %f at the tone, got %f", name, hann.Norm[16], grain.Norm[16])
+		}
+	}
+
+	if err := NewPvoc(winSize, winSize).SetWindow("triangle"); err == nil {
+		t.Error("Expected error for an unknown window, got nil")
+	}
+}
@@ -0,0 +1,65 @@
+package onset
+
+// RealtimeDetector detects onsets in audio pushed in blocks of any size, e.g.
+// from an audio callback, and reports each onset as soon as the hop that
+// confirms it is complete. Samples are accumulated in a hop-sized buffer so
+// the block size does not have to match the hop size, and the detected onsets
+// are the same as when the samples are processed hop by hop.
+type RealtimeDetector struct {
+	// Onset is the underlying detector, which can be tuned with its setters
+	// before the first write
+	Onset *Onset
+	// OnOnset is called with the time in seconds and the strength of each onset
+	OnOnset func(timeSec, strength float64)
+
+	buf    *Fvec
+	fill   uint
+	output *Fvec
+}
+
+// NewRealtimeDetector creates a realtime detector with the given method, buffer
+// and hop sizes, calling onOnset for every onset detected
+func NewRealtimeDetector(onsetMode string, bufSize, hopSize, samplerate uint, onOnset func(timeSec, strength float64)) *RealtimeDetector {
+	return &RealtimeDetector{
+		Onset:   NewOnset(onsetMode, bufSize, hopSize, samplerate),
+		OnOnset: onOnset,
+		buf:     NewFvec(hopSize),
+		output:  NewFvec(1),
+	}
+}
+
+// Write pushes a block of samples, processing every hop it completes. Blocks
+// may be shorter or longer than a hop and vary in size: the samples left over
+// after the last complete hop stay buffered and start the next hop, so onsets
+// are reported once the block completing their hop is written. A partial hop
+// at the end of the stream is never processed, like when calling Onset.Do hop
+// by hop. It does not allocate, so it is safe to call from an audio callback.
+func (d *RealtimeDetector) Write(block []float64) {
+	for len(block) > 0 {
+		n := copy(d.buf.Data[d.fill:], block)
+		d.fill += uint(n)
+		block = block[n:]
+
+		if d.fill == d.buf.Length {
+			d.fill = 0
+			d.Onset.Do(d.buf, d.output)
+			if d.output.Data[0] > 0 && d.OnOnset != nil {
+				d.OnOnset(d.Onset.GetLastS(), d.Onset.GetLastStrength())
+			}
+		}
+	}
+}
+
+// Latency returns the longest time in seconds between the start of an onset in
+// the audio and its report: the peak picker confirms a peak WinPre+1 hops after
+// the hop containing it, which may only be complete a full hop after the onset.
+func (d *RealtimeDetector) Latency() float64 {
+	o := d.Onset
+	return float64((o.Pp.WinPre+2)*o.HopSize) / float64(o.Samplerate)
+}
+
+// Reset discards the buffered samples and restarts the detector at time zero
+func (d *RealtimeDetector) Reset() {
+	d.fill = 0
+	d.Onset.Reset()
+}
@@ -0,0 +1,108 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRealtimeDetector(t *testing.T) {
+	times := []float64{0.1, 0.35, 0.8, 1.2, 1.55}
+	samples := clickTrack(44100, 2.0, times, 0.8)
+
+	type report struct {
+		time, strength float64
+		at             int
+	}
+	// detect writes the samples in blocks cycling through sizes
+	detect := func(sizes ...int) []report {
+		var reports []report
+		written := 0
+		d := NewRealtimeDetector("hfc", 512, 256, 44100, nil)
+		d.OnOnset = func(timeSec, strength float64) {
+			reports = append(reports, report{timeSec, strength, written})
+		}
+		for start, k := 0, 0; start < len(samples); k++ {
+			end := min(start+sizes[k%len(sizes)], len(samples))
+			written = end
+			d.Write(samples[start:end])
+			start = end
+		}
+		return reports
+	}
+
+	aligned := detect(256)
+	if len(aligned) != len(times) {
+		t.Fatalf("Expected %d onsets, got %d", len(times), len(aligned))
+	}
+
+	// Hop-sized blocks give the onsets of Onset.Do
+	o := NewOnset("hfc", 512, 256, 44100)
+	input := NewFvec(256)
+	output := NewFvec(1)
+	var expected []float64
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		o.Do(input, output)
+		if output.Data[0] > 0 {
+			expected = append(expected, o.GetLastS())
+		}
+	}
+	if len(expected) != len(aligned) {
+		t.Fatalf("Expected %d onsets as with Onset.Do, got %d", len(expected), len(aligned))
+	}
+	for i := range aligned {
+		if aligned[i].time != expected[i] {
+			t.Errorf("Onset %d: expected %.4fs as with Onset.Do, got %.4fs", i, expected[i], aligned[i].time)
+		}
+	}
+
+	// Each click is reported within the latency of its start
+	latency := NewRealtimeDetector("hfc", 512, 256, 44100, nil).Latency()
+	for i, r := range aligned {
+		if delay := float64(r.at)/44100 - times[i]; delay < 0 || delay > latency {
+			t.Errorf("Click %d at %.3fs reported %.4fs later, expected within %.4fs", i, times[i], delay, latency)
+		}
+	}
+
+	for _, blockSize := range []int{64, 37, 100, 1000} {
+		got := detect(blockSize)
+		if len(got) != len(aligned) {
+			t.Errorf("Block size %d: expected %d onsets, got %d", blockSize, len(aligned), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i].time != aligned[i].time || got[i].strength != aligned[i].strength {
+				t.Errorf("Block size %d, onset %d: expected %.4fs (%.3f), got %.4fs (%.3f)",
+					blockSize, i, aligned[i].time, aligned[i].strength, got[i].time, got[i].strength)
+			}
+			// Reported no later than the end of the block completing the same hop
+			if got[i].at-aligned[i].at >= blockSize {
+				t.Errorf("Block size %d, onset %d: reported at sample %d, hop completed at %d",
+					blockSize, i, got[i].at, aligned[i].at)
+			}
+		}
+	}
+
+	// Blocks of varying size, shorter and longer than a hop
+	got := detect(1, 700, 13, 256, 255, 2, 1024, 97)
+	if len(got) != len(aligned) {
+		t.Fatalf("Varying blocks: expected %d onsets, got %d", len(aligned), len(got))
+	}
+	for i := range got {
+		if got[i].time != aligned[i].time || got[i].strength != aligned[i].strength {
+			t.Errorf("Varying blocks, onset %d: expected %.4fs (%.3f), got %.4fs (%.3f)",
+				i, aligned[i].time, aligned[i].strength, got[i].time, got[i].strength)
+		}
+	}
+}
+
+func TestRealtimeDetectorAllocs(t *testing.T) {
+	d := NewRealtimeDetector("hfc", 512, 256, 44100, func(float64, float64) {})
+	block := make([]float64, 64)
+	for i := range block {
+		block[i] = math.Sin(float64(i))
+	}
+	if allocs := testing.AllocsPerRun(100, func() { d.Write(block) }); allocs != 0 {
+		t.Errorf("Expected no allocations per write, got %.1f", allocs)
+	}
+}
@@ -0,0 +1,260 @@
+package onset
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// resultFormatVersion is written ahead of every saved result. Bump it when a
+// change to SliceAnalyzerResult would make old caches decode wrongly.
+const resultFormatVersion = 1
+
+// ForEachOnset calls fn for every onset with a copy of the windowMs long window
+// of samples centered on the onset. Parts of the window outside the audio are
+// zero-padded, so every window has the same length. It does nothing when the
+// result has no samples (see DropSamples).
+func (r *SliceAnalyzerResult) ForEachOnset(windowMs float64, fn func(i int, timeSec float64, window []float64)) {
+	if len(r.Samples) == 0 || r.SampleRate == 0 {
+		return
+	}
+
+	length := int(windowMs * float64(r.SampleRate) / 1000.0)
+	for i, onsetTime := range r.Onsets {
+		center := Round(onsetTime * float64(r.SampleRate))
+		fn(i, onsetTime, sampleWindow(r.Samples, center-length/2, length))
+	}
+}
+
+// Report returns a human-readable multi-line summary of the analysis: duration,
+// sample rate, method, onset count, mean and median inter-onset interval, any
+// warnings, and the onset times. It is meant to be pasted into bug reports.
+func (r *SliceAnalyzerResult) Report() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Duration: %.3f seconds\n", r.Duration)
+	fmt.Fprintf(&b, "Sample Rate: %d Hz\n", r.SampleRate)
+	fmt.Fprintf(&b, "Method: %s\n", r.Method)
+	fmt.Fprintf(&b, "Onsets: %d\n", len(r.Onsets))
+
+	if len(r.Onsets) > 1 {
+		// Intervals between consecutive onsets in time, whatever their order
+		times := append([]float64(nil), r.Onsets...)
+		sort.Float64s(times)
+		iois := make([]float64, len(times)-1)
+		sum := 0.0
+		for i := range iois {
+			iois[i] = times[i+1] - times[i]
+			sum += iois[i]
+		}
+		sort.Float64s(iois)
+		fmt.Fprintf(&b, "Mean IOI: %.1f ms\n", sum/float64(len(iois))*1000)
+		fmt.Fprintf(&b, "Median IOI: %.1f ms\n", calculatePercentile(iois, 50)*1000)
+	}
+
+	for _, warning := range r.Stats.Warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", warning)
+	}
+
+	if len(r.Onsets) > 0 {
+		fmt.Fprintf(&b, "Onset times:\n")
+	}
+	for i, onsetTime := range r.Onsets {
+		fmt.Fprintf(&b, "  %2d: %.4f seconds (sample %d)\n", i+1, onsetTime, int(onsetTime*float64(r.SampleRate)))
+	}
+
+	return b.String()
+}
+
+// NormalizedOnsets returns the onset times as fractions of the duration, in
+// [0,1], e.g. for a UI that draws slice points independent of resolution.
+// Onsets past the end are clamped to 1. It returns no positions when the
+// duration is not known.
+func (r *SliceAnalyzerResult) NormalizedOnsets() []float64 {
+	if r.Duration <= 0 {
+		return []float64{}
+	}
+
+	positions := make([]float64, len(r.Onsets))
+	for i, onsetTime := range r.Onsets {
+		positions[i] = math.Min(math.Max(onsetTime/r.Duration, 0), 1)
+	}
+
+	return positions
+}
+
+// Save writes the result to w in a versioned gob format that LoadResult reads
+// back, e.g. to cache analyses keyed by file hash. Samples and Interleaved are
+// saved when set; to keep a cache small, save a copy with them set to nil.
+func (r *SliceAnalyzerResult) Save(w io.Writer) error {
+	encoder := gob.NewEncoder(w)
+	if err := encoder.Encode(resultFormatVersion); err != nil {
+		return fmt.Errorf("failed to write result format version: %w", err)
+	}
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("failed to write result: %w", err)
+	}
+
+	return nil
+}
+
+// LoadResult reads a result written by Save. It fails for data written with a
+// different format version rather than decoding it wrongly.
+func LoadResult(r io.Reader) (*SliceAnalyzerResult, error) {
+	decoder := gob.NewDecoder(r)
+	var version int
+	if err := decoder.Decode(&version); err != nil {
+		return nil, fmt.Errorf("failed to read result format version: %w", err)
+	}
+	if version != resultFormatVersion {
+		return nil, fmt.Errorf("unsupported result format version %d (expected %d)", version, resultFormatVersion)
+	}
+
+	result := &SliceAnalyzerResult{}
+	if err := decoder.Decode(result); err != nil {
+		return nil, fmt.Errorf("failed to read result: %w", err)
+	}
+
+	return result, nil
+}
+
+// JSONOptions selects the optional fields written by WriteJSON
+type JSONOptions struct {
+	// IncludeSamples adds the samples ("samples"), e.g. to draw the waveform
+	IncludeSamples bool
+	// ComputeGrid adds the tempo estimated from every detected onset,
+	// including the rejected ones ("bpm"), and the times of its beats from
+	// the first strong onset to the end of the audio ("grid", see
+	// SliceToGrid), to draw a tempo grid alongside the onsets. Both are left
+	// out when no tempo is found.
+	ComputeGrid bool
+}
+
+// resultJSON is the JSON form of a result written by WriteJSON
+type resultJSON struct {
+	SampleRate     uint      `json:"sample_rate"`
+	Duration       float64   `json:"duration"`
+	Method         string    `json:"method"`
+	Onsets         []float64 `json:"onsets"`
+	RejectedOnsets []float64 `json:"rejected_onsets"`
+	Samples        []float64 `json:"samples,omitempty"`
+	BPM            float64   `json:"bpm,omitempty"`
+	Grid           []float64 `json:"grid,omitempty"`
+}
+
+// WriteJSON writes the result to w as a JSON object with the sample rate,
+// duration, method, onsets and rejected onsets (in seconds), and the
+// optional fields selected by options.
+func (r *SliceAnalyzerResult) WriteJSON(w io.Writer, options JSONOptions) error {
+	data := resultJSON{
+		SampleRate:     r.SampleRate,
+		Duration:       r.Duration,
+		Method:         r.Method,
+		Onsets:         append([]float64{}, r.Onsets...),
+		RejectedOnsets: append([]float64{}, r.RejectedOnsets...),
+	}
+	if options.IncludeSamples {
+		data.Samples = r.Samples
+	}
+	if options.ComputeGrid {
+		data.BPM, data.Grid = r.tempoGrid()
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+
+	return nil
+}
+
+// tempoGrid estimates the tempo from every detected onset, including those
+// dropped by the slice selection, and returns it with one grid line per beat
+// from the first strong onset to the end of the audio, or 0 and no lines
+// when no tempo is found
+func (r *SliceAnalyzerResult) tempoGrid() (float64, []float64) {
+	detected := append(append([]float64(nil), r.Onsets...), r.RejectedOnsets...)
+	sort.Float64s(detected)
+	bpm := EstimateBPM(detected)
+	if bpm <= 0 {
+		return 0, nil
+	}
+
+	// Enough 4/4 bars to reach the end, trimmed to the duration
+	bars := int(math.Ceil(r.Duration/(4*60/bpm))) + 1
+	grid := []float64{}
+	for _, line := range SliceToGrid(r, bpm, 4, bars) {
+		if line <= r.Duration {
+			grid = append(grid, line)
+		}
+	}
+
+	return bpm, grid
+}
+
+// OnsetMarkerSignal returns a signal of numSamples samples that is 1 at the
+// sample of each onset (round(onset*sampleRate)) and 0 elsewhere, for drawing
+// slice points on a waveform. Each marker covers widthSamples samples starting
+// at the onset sample (at least one); markers past the end are dropped.
+func OnsetMarkerSignal(numSamples int, sampleRate uint, onsets []float64, widthSamples int) []float64 {
+	if numSamples <= 0 {
+		return []float64{}
+	}
+
+	markers := make([]float64, numSamples)
+	width := max(widthSamples, 1)
+	for _, onsetTime := range onsets {
+		start := Round(onsetTime * float64(sampleRate))
+		for idx := max(start, 0); idx < start+width && idx < numSamples; idx++ {
+			markers[idx] = 1
+		}
+	}
+
+	return markers
+}
+
+// histogramEpsilon absorbs the rounding of onset times and durations that
+// are multiples of the bucket length, e.g. 0.3/0.1 = 2.9999999999999996, so
+// they land on the bucket boundary
+const histogramEpsilon = 1e-9
+
+// OnsetHistogram returns the number of onsets in each bucketSec long bucket
+// of the totalDurationSec long audio, e.g. for a density over time view.
+// Bucket i covers [i*bucketSec, (i+1)*bucketSec), so an onset on a boundary
+// counts in the later bucket; the last bucket may be shorter and also counts
+// onsets at totalDurationSec. Onsets outside the audio are ignored. It
+// returns no buckets when the duration or the bucket length is not positive.
+func OnsetHistogram(onsets []float64, totalDurationSec, bucketSec float64) []int {
+	if totalDurationSec <= 0 || bucketSec <= 0 {
+		return []int{}
+	}
+
+	counts := make([]int, max(int(math.Ceil(totalDurationSec/bucketSec-histogramEpsilon)), 1))
+	for _, onsetTime := range onsets {
+		if onsetTime < 0 || onsetTime > totalDurationSec {
+			continue
+		}
+		bucket := int(math.Floor(onsetTime/bucketSec + histogramEpsilon))
+		counts[min(bucket, len(counts)-1)]++
+	}
+
+	return counts
+}
+
+// sampleWindow returns a copy of length samples starting at start,
+// zero-padded where the window extends beyond the samples
+func sampleWindow(samples []float64, start, length int) []float64 {
+	window := make([]float64, length)
+	for k := range window {
+		idx := start + k
+		if idx >= 0 && idx < len(samples) {
+			window[k] = samples[idx]
+		}
+	}
+	return window
+}
@@ -0,0 +1,298 @@
+package onset
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForEachOnset(t *testing.T) {
+	sampleRate := uint(1000)
+
+	// Each sample holds its own index so window contents are easy to check
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = float64(i)
+	}
+
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0.002, 0.5, 0.999},
+		Samples:    samples,
+		SampleRate: sampleRate,
+	}
+
+	calls := 0
+	result.ForEachOnset(10.0, func(i int, timeSec float64, window []float64) {
+		calls++
+
+		if timeSec != result.Onsets[i] {
+			t.Errorf("Expected time %f for onset %d, got %f", result.Onsets[i], i, timeSec)
+		}
+
+		if len(window) != 10 {
+			t.Fatalf("Expected window of 10 samples, got %d", len(window))
+		}
+
+		// The onset sample sits at the center of the window
+		center := Round(timeSec * float64(sampleRate))
+		for k, value := range window {
+			idx := center - 5 + k
+			expected := 0.0
+			if idx >= 0 && idx < len(samples) {
+				expected = float64(idx)
+			}
+			if value != expected {
+				t.Errorf("Onset %d window[%d]: expected %f, got %f", i, k, expected, value)
+			}
+		}
+	})
+
+	if calls != len(result.Onsets) {
+		t.Errorf("Expected %d calls, got %d", len(result.Onsets), calls)
+	}
+
+	// Windows are copies and must not alias the samples
+	result.ForEachOnset(10.0, func(i int, timeSec float64, window []float64) {
+		window[5] = -1
+	})
+	if samples[500] != 500 {
+		t.Error("Expected modifying a window to leave the samples unchanged")
+	}
+}
+
+func TestReport(t *testing.T) {
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0.5, 1.0, 1.25, 2.0},
+		SampleRate: 44100,
+		Duration:   3.0,
+		Method:     "hfc",
+		Stats:      SliceAnalyzerStats{Warnings: []string{"header declares more frames"}},
+	}
+
+	report := result.Report()
+	for _, expected := range []string{
+		"Duration: 3.000 seconds",
+		"Sample Rate: 44100 Hz",
+		"Method: hfc",
+		"Onsets: 4",
+		"Mean IOI: 500.0 ms",
+		"Median IOI: 500.0 ms",
+		"Warning: header declares more frames",
+		"1: 0.5000 seconds (sample 22050)",
+		"4: 2.0000 seconds (sample 88200)",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, report)
+		}
+	}
+
+	// A file analysis fills in the duration and method
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	options.DropSamples = true
+	fromFile, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if !strings.Contains(fromFile.Report(), "Duration: 2.791 seconds") || !strings.Contains(fromFile.Report(), "Method: hfc") {
+		t.Errorf("Expected duration and method in report, got:\n%s", fromFile.Report())
+	}
+}
+
+func TestOnsetMarkerSignal(t *testing.T) {
+	sampleRate := uint(44100)
+	onsets := []float64{0.0, 0.10001, 0.25, 0.99999}
+
+	markers := OnsetMarkerSignal(44100, sampleRate, onsets, 0)
+	if len(markers) != 44100 {
+		t.Fatalf("Expected 44100 samples, got %d", len(markers))
+	}
+
+	expected := make(map[int]bool)
+	for _, onsetTime := range onsets {
+		expected[Round(onsetTime*float64(sampleRate))] = true
+	}
+	count := 0
+	for i, v := range markers {
+		if v == 1 {
+			count++
+			if !expected[i] {
+				t.Errorf("Unexpected marker at sample %d", i)
+			}
+		} else if v != 0 {
+			t.Errorf("Expected 0 or 1 at sample %d, got %f", i, v)
+		}
+	}
+	// The last onset rounds to 44100, past the end
+	if count != 3 {
+		t.Errorf("Expected 3 markers, got %d", count)
+	}
+
+	// Wider markers start at the onset sample
+	markers = OnsetMarkerSignal(1000, 1000, []float64{0.5, 0.998}, 4)
+	for i := 500; i < 504; i++ {
+		if markers[i] != 1 {
+			t.Errorf("Expected marker at sample %d", i)
+		}
+	}
+	if markers[499] != 0 || markers[504] != 0 {
+		t.Error("Expected marker to cover exactly 4 samples")
+	}
+	if markers[998] != 1 || markers[999] != 1 {
+		t.Error("Expected marker near the end to be cut off at the last sample")
+	}
+}
+
+func TestOnsetHistogram(t *testing.T) {
+	onsets := []float64{0.05, 0.12, 0.15, 0.18, 0.45, 0.95}
+	counts := OnsetHistogram(onsets, 1.0, 0.1)
+	expected := []int{1, 3, 0, 0, 1, 0, 0, 0, 0, 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+
+	// Onsets on a boundary count in the later bucket, despite 0.3/0.1 being
+	// just below 3, and the end of the audio in the last, shorter bucket
+	counts = OnsetHistogram([]float64{0, 0.1, 0.3, 0.7, 1.05, -0.1, 1.2}, 1.05, 0.1)
+	expected = []int{1, 1, 0, 1, 0, 0, 0, 1, 0, 0, 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected %v for boundary onsets, got %v", expected, counts)
+	}
+
+	if counts := OnsetHistogram(onsets, 1.0, 0); len(counts) != 0 {
+		t.Errorf("Expected no buckets for a bucket length of 0, got %v", counts)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	result, err := AnalyzeSlices("amen.wav", DefaultSliceAnalyzerOptions())
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	for _, options := range []JSONOptions{{}, {IncludeSamples: true, ComputeGrid: true}} {
+		var buf bytes.Buffer
+		if err := result.WriteJSON(&buf, options); err != nil {
+			t.Fatalf("WriteJSON failed: %v", err)
+		}
+		var data struct {
+			SampleRate uint       `json:"sample_rate"`
+			Onsets     []float64  `json:"onsets"`
+			Samples    []float64  `json:"samples"`
+			BPM        *float64   `json:"bpm"`
+			Grid       *[]float64 `json:"grid"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
+			t.Fatalf("failed to parse JSON: %v", err)
+		}
+		if data.SampleRate != result.SampleRate || !reflect.DeepEqual(data.Onsets, result.Onsets) {
+			t.Errorf("Expected sample rate %d and onsets %v, got %d and %v", result.SampleRate, result.Onsets, data.SampleRate, data.Onsets)
+		}
+
+		if !options.ComputeGrid {
+			if data.BPM != nil || data.Grid != nil || data.Samples != nil {
+				t.Error("Expected no samples, bpm or grid without the options")
+			}
+			continue
+		}
+
+		if len(data.Samples) != len(result.Samples) {
+			t.Errorf("Expected %d samples, got %d", len(result.Samples), len(data.Samples))
+		}
+		if data.BPM == nil || data.Grid == nil || *data.BPM < 80 || *data.BPM > 160 || len(*data.Grid) < 2 {
+			t.Fatalf("Expected a tempo and a grid, got %v and %v", data.BPM, data.Grid)
+		}
+		bpm, grid := *data.BPM, *data.Grid
+		for i := 1; i < len(grid); i++ {
+			if spacing := grid[i] - grid[i-1]; math.Abs(spacing-60/bpm) > 1e-9 {
+				t.Errorf("Grid line %d: expected spacing %f, got %f", i, 60/bpm, spacing)
+			}
+		}
+		if last := grid[len(grid)-1]; last > result.Duration || last+60/bpm <= result.Duration {
+			t.Errorf("Expected the grid to end in the last beat before %.3fs, got a line at %.3fs", result.Duration, last)
+		}
+	}
+}
+
+func TestSaveLoadResult(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 2.0, []float64{0.25, 0.75, 1.25, 1.75}, 0.8)
+	result, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{NumSlices: 3})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadResult(&buf)
+	if err != nil {
+		t.Fatalf("LoadResult failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, result) {
+		t.Errorf("Expected the loaded result to equal the saved one, got onsets %v (saved %v)", loaded.Onsets, result.Onsets)
+	}
+
+	// Saving a copy without samples keeps everything else
+	trimmed := *result
+	trimmed.Samples = nil
+	buf.Reset()
+	if err := trimmed.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err = LoadResult(&buf)
+	if err != nil {
+		t.Fatalf("LoadResult failed: %v", err)
+	}
+	if len(loaded.Samples) != 0 {
+		t.Errorf("Expected no samples, got %d", len(loaded.Samples))
+	}
+	if !reflect.DeepEqual(loaded.Onsets, result.Onsets) || !reflect.DeepEqual(loaded.RejectedOnsets, result.RejectedOnsets) {
+		t.Errorf("Expected onsets %v and rejected %v, got %v and %v", result.Onsets, result.RejectedOnsets, loaded.Onsets, loaded.RejectedOnsets)
+	}
+
+	// Data from another format version is refused
+	buf.Reset()
+	encoder := gob.NewEncoder(&buf)
+	encoder.Encode(resultFormatVersion + 1)
+	encoder.Encode(result)
+	if _, err := LoadResult(&buf); err == nil {
+		t.Error("Expected error for another format version, got nil")
+	}
+	if _, err := LoadResult(strings.NewReader("not a result")); err == nil {
+		t.Error("Expected error for garbage input, got nil")
+	}
+}
+
+func TestNormalizedOnsets(t *testing.T) {
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0, 0.5, 1.999, 2.0},
+		SampleRate: 44100,
+		Duration:   2.0,
+	}
+
+	expected := []float64{0, 0.25, 0.9995, 1}
+	positions := result.NormalizedOnsets()
+	if len(positions) != len(expected) {
+		t.Fatalf("Expected %d positions, got %d", len(expected), len(positions))
+	}
+	for i, position := range positions {
+		if math.Abs(position-expected[i]) > 1e-12 {
+			t.Errorf("Onset %d: expected position %f, got %f", i, expected[i], position)
+		}
+	}
+
+	// An onset past the end is clamped, and no duration gives no positions
+	result.Onsets = []float64{2.01}
+	if positions := result.NormalizedOnsets(); len(positions) != 1 || positions[0] != 1 {
+		t.Errorf("Expected an onset past the end at 1, got %v", positions)
+	}
+	if positions := (&SliceAnalyzerResult{Onsets: []float64{0.5}}).NormalizedOnsets(); len(positions) != 0 {
+		t.Errorf("Expected no positions without a duration, got %v", positions)
+	}
+}
@@ -0,0 +1,60 @@
+package onset
+
+import "math"
+
+// silenceFrameMs is the length of the frames whose level is compared with the
+// silence threshold when splitting on silence
+const silenceFrameMs = 10.0
+
+// SplitOnSilence splits the samples into the non-silent segments separated by
+// silent gaps of at least minSilenceMs, returning the start and end of each
+// segment in seconds. Unlike onset detection it looks only at the level: a
+// 10ms frame is silent when its RMS level is below silenceDb, in dB relative
+// to an amplitude of 1 (e.g. -50). Shorter gaps stay inside their segment, and
+// silence before the first and after the last segment is trimmed. Boundaries
+// are accurate to one frame.
+func SplitOnSilence(samples []float64, sampleRate uint, silenceDb float64, minSilenceMs float64) [][2]float64 {
+	segments := [][2]float64{}
+	if len(samples) == 0 || sampleRate == 0 {
+		return segments
+	}
+
+	frameSize := max(int(silenceFrameMs*float64(sampleRate)/1000.0), 1)
+	minSilentFrames := max(int(math.Ceil(minSilenceMs/silenceFrameMs)), 1)
+	threshold := math.Pow(10, silenceDb/20)
+	seconds := func(sample int) float64 {
+		return float64(min(sample, len(samples))) / float64(sampleRate)
+	}
+
+	start := -1       // first sample of the current segment, -1 when in silence
+	lastSound := 0    // end of the last non-silent frame
+	silentFrames := 0 // silent frames since lastSound
+	for pos := 0; pos < len(samples); pos += frameSize {
+		end := min(pos+frameSize, len(samples))
+		sum := 0.0
+		for _, v := range samples[pos:end] {
+			sum += v * v
+		}
+
+		if math.Sqrt(sum/float64(end-pos)) >= threshold {
+			if start < 0 {
+				start = pos
+			}
+			lastSound = end
+			silentFrames = 0
+			continue
+		}
+
+		silentFrames++
+		if start >= 0 && silentFrames >= minSilentFrames {
+			segments = append(segments, [2]float64{seconds(start), seconds(lastSound)})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		segments = append(segments, [2]float64{seconds(start), seconds(lastSound)})
+	}
+
+	return segments
+}
@@ -0,0 +1,49 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSplitOnSilence(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, 3*int(sampleRate))
+
+	// Tones separated by silence, with a 20ms dropout inside the second tone
+	tones := [][2]float64{{0.2, 0.7}, {1.0, 1.6}, {2.2, 2.5}}
+	for _, tone := range tones {
+		for i := int(tone[0] * float64(sampleRate)); i < int(tone[1]*float64(sampleRate)); i++ {
+			samples[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		}
+	}
+	for i := int(1.3 * float64(sampleRate)); i < int(1.32*float64(sampleRate)); i++ {
+		samples[i] = 0
+	}
+
+	// Low-level noise in the gaps stays below the threshold
+	seed := uint32(5)
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		samples[i] += 0.0001 * (float64(seed)/float64(math.MaxUint32)*2 - 1)
+	}
+
+	segments := SplitOnSilence(samples, sampleRate, -50, 100)
+	if len(segments) != len(tones) {
+		t.Fatalf("Expected %d segments, got %v", len(tones), segments)
+	}
+
+	for i, segment := range segments {
+		if math.Abs(segment[0]-tones[i][0]) > 0.011 || math.Abs(segment[1]-tones[i][1]) > 0.011 {
+			t.Errorf("Segment %d: expected %v, got %v", i, tones[i], segment)
+		}
+	}
+
+	// A shorter minimum silence also splits at the dropout
+	if segments := SplitOnSilence(samples, sampleRate, -50, 10); len(segments) != 4 {
+		t.Errorf("Expected 4 segments with a 10ms minimum silence, got %v", segments)
+	}
+
+	if segments := SplitOnSilence(make([]float64, 1000), sampleRate, -50, 100); len(segments) != 0 {
+		t.Errorf("Expected no segments for silence, got %v", segments)
+	}
+}
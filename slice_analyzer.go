@@ -3,20 +3,71 @@ package onset
 import (
 	"fmt"
 	"math"
-	"os"
 	"sort"
-
-	"github.com/go-audio/wav"
 )
 
 // SliceAnalyzerResult contains the results of slice analysis
 type SliceAnalyzerResult struct {
 	// Onsets contains the detected onset times in seconds
 	Onsets []float64
-	// Samples contains the audio samples (left channel only for stereo files)
+	// Strengths contains the detector's own measure of the strength of each
+	// onset, aligned with Onsets: the height of the peak-picked novelty above
+	// the adaptive threshold. For the "consensus" method it is the average
+	// strength of the cluster's markers calibrated within their method (see
+	// ConsensusCalibration), between 0 and 1.
+	Strengths []float64
+	// Energies contains the RMS level of the 50ms following each onset, aligned
+	// with Onsets: linear, or in dBFS when EnergyDb is set. Not populated when
+	// onsets are detected while decoding (DropSamples set and no stage needing
+	// the samples).
+	Energies []float64
+	// RejectedOnsets contains the onsets that were detected but dropped by a
+	// later stage: the best-N selection (NumSlices, OnsetsPerSecond), the
+	// EnergyPercentile selection, the energy floor, the thinning, the minimum
+	// spacing filter or SuppressPeriodic. They are in seconds, sorted by time,
+	// and all at their detected times: onsets dropped after Optimize are
+	// reported where they were detected, not where Optimize moved them.
+	RejectedOnsets []float64
+	// NumDetected is the number of onsets found by the detection pass before
+	// any of the later stages dropped some, i.e. len(Onsets) plus
+	// len(RejectedOnsets), e.g. to show "detected 142, kept 8". With the
+	// "consensus" method it counts the consensus clusters. Candidates dropped
+	// inside the detector (silence gate, minimum inter-onset interval) are
+	// not counted; they are only reported to OnReject.
+	NumDetected int
+	// Samples contains the audio samples (left channel only for stereo files).
+	// Not populated when DropSamples is set.
 	Samples []float64
+	// Interleaved contains the samples of every channel of the file,
+	// interleaved and in the same scale as Samples, e.g. for a stereo waveform
+	// view. Only populated by AnalyzeSlices when KeepInterleaved is set.
+	Interleaved []float64
+	// NumChannels is the number of channels in Interleaved. Only populated by
+	// AnalyzeSlices when KeepInterleaved is set.
+	NumChannels int
 	// SampleRate is the sample rate of the audio file
 	SampleRate uint
+	// Duration is the duration of the analyzed audio in seconds
+	Duration float64
+	// Method is the detection method that was used
+	Method string
+	// PerMethodOnsets contains the raw onsets (in seconds) found by each method
+	// before clustering, keyed by method name. Only populated for the "consensus" method.
+	PerMethodOnsets map[string][]float64
+	// Stats contains information about the decoded file, including any warnings.
+	// Only populated by AnalyzeSlices.
+	Stats SliceAnalyzerStats
+}
+
+// SliceAnalyzerStats contains information gathered while decoding a file
+type SliceAnalyzerStats struct {
+	// DeclaredDuration is the duration in seconds declared by the WAV header
+	DeclaredDuration float64
+	// DecodedDuration is the duration in seconds of the samples actually decoded
+	DecodedDuration float64
+	// Warnings contains problems found with the file that did not stop the analysis,
+	// e.g. a data chunk shorter than its header declares
+	Warnings []string
 }
 
 // SliceAnalyzerOptions contains configuration options for slice analysis
@@ -25,21 +76,68 @@ type SliceAnalyzerOptions struct {
 	// If 0 (default), all onsets are detected.
 	// If > 0, the best N onsets based on energy are selected.
 	NumSlices int
+	// OnsetsPerSecond sets the number of slices relative to the duration of the audio,
+	// e.g. 4 finds the best 40 onsets in a 10 second file. The target is rounded to the
+	// nearest whole number (at least 1) and selected like NumSlices.
+	// Cannot be combined with NumSlices. Default is 0 (disabled).
+	OnsetsPerSecond float64
+	// EnergyPercentile keeps every onset whose energy is above the given percentile
+	// (0-100) of the energies of all candidate onsets, e.g. 60 keeps about the
+	// loudest 40%, instead of a fixed number of slices. With the "consensus"
+	// method the candidates are the consensus clusters.
+	// Cannot be combined with NumSlices or OnsetsPerSecond. Default is 0 (disabled).
+	EnergyPercentile float64
 	// Optimize enables optimization of onset positions using variance analysis.
+	// Only the onsets kept by the selection and thinning stages are optimized,
+	// which saves time on large files with NumSlices.
 	// Default is true.
 	Optimize bool
 	// OptimizeWindowMs specifies the window size in milliseconds for onset optimization.
+	// Near the start or end of the samples, e.g. in a short one-shot, the
+	// window is shrunk to fit while staying centered on the onset.
 	// Default is 100.0 ms.
 	OptimizeWindowMs float64
 	// Method specifies the onset detection method to use.
-	// Supported methods: "hfc", "energy", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux", "consensus"
+	// Supported methods: "hfc", "energy", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux", "complexity", "consensus"
 	// Default is "hfc" if empty.
 	// The special "consensus" method uses all methods and generates consensus markers.
+	// The special "auto" method chooses a method from the spectral flatness and
+	// transients of the signal: "hfc" for noisy percussive material, "complex" or
+	// "phase" for tonal material. The chosen method is recorded in the result.
+	// The special "stereowidth" method detects abrupt changes of the
+	// correlation of the left and right channels, e.g. a hit panned hard to
+	// one side over a centered mix, which methods on one channel can miss.
+	// It needs both channels, so it is only supported by AnalyzeSlices with
+	// a stereo file; the options of the spectral methods do not apply to it.
 	Method string
 	// MinConsensusClusterSize specifies the minimum number of onset markers required
 	// for a cluster to be considered valid when using the "consensus" method.
+	// It is relative to all methods firing: when some methods find no onsets at
+	// all, e.g. on a very quiet file, it is scaled down in proportion, rounding
+	// up, so the remaining methods can still agree.
 	// Default is 3. Only applies when Method is "consensus".
 	MinConsensusClusterSize int
+	// ConsensusMinStrength drops consensus clusters whose average detection strength
+	// is below this value. Each onset's strength is its peak novelty relative to the
+	// strongest onset found by the same method, or its percentile rank with
+	// ConsensusCalibration "percentile", so the value ranges from 0 to 1.
+	// Default is 0 (no filtering). Only applies when Method is "consensus".
+	ConsensusMinStrength float64
+	// ConsensusKeepOutliers keeps outlying markers in clusters when taking
+	// their midpoint, instead of removing them. Set it for dense material where
+	// early or late markers are genuine and should pull the midpoint.
+	// Default is false (outliers are removed). Only applies when Method is "consensus".
+	ConsensusKeepOutliers bool
+	// ConsensusOutlierMethod selects how outlying markers are found:
+	// "iqr" (interquartile range, clusters of 4 or more) or "mad" (median absolute
+	// deviation, clusters of 3 or more), which is more robust for small clusters.
+	// Default is "iqr" if empty. Does not apply with ConsensusKeepOutliers.
+	ConsensusOutlierMethod string
+	// ConsensusPercentileMethod selects how the quartiles of the "iqr" outlier
+	// method are taken between two values, like numpy's percentile methods:
+	// "linear" (interpolate), "nearest", "lower" or "higher" (nearest, lower or
+	// higher value). Default is "linear" if empty.
+	ConsensusPercentileMethod string
 	// UseMinimumSpacing enables minimum spacing filter between slices.
 	// When true, if multiple slices fall within MinimumSpacing window, only the first is kept.
 	// Default is true.
@@ -47,7 +145,299 @@ type SliceAnalyzerOptions struct {
 	// MinimumSpacing specifies the minimum spacing in milliseconds between slices.
 	// If multiple slices fall within this window, only the first is kept.
 	// Default is 80.0 ms. Only applies when UseMinimumSpacing is true.
+	// When SpacingBPM is set, MinimumSpacing is in grid units instead of milliseconds.
 	MinimumSpacing float64
+	// SpacingBPM switches the minimum spacing to musical time: MinimumSpacing is
+	// expressed in grid steps of SpacingDivision notes per 4/4 bar at this tempo
+	// (e.g. MinimumSpacing 1 with SpacingDivision 16 at 120 BPM is 125 ms).
+	// Default is 0 (MinimumSpacing in milliseconds).
+	SpacingBPM float64
+	// SpacingDivision is the grid subdivision used with SpacingBPM, in notes per bar.
+	// Default is 16 (sixteenth notes) if not set.
+	SpacingDivision int
+	// DropSamples leaves the decoded samples out of the result.
+	// The samples are dropped after analysis, and if no stage needs them
+	// (no consensus, best-N or optimization) onsets are detected while decoding so
+	// the file is never held in memory.
+	// Default is false (samples are returned).
+	DropSamples bool
+	// KeepInterleaved also returns every channel of the file in the result
+	// (Interleaved and NumChannels), while detection still uses the left
+	// channel. It is decoded in the same pass and needs the whole file in
+	// memory, so onsets are not detected while decoding. Only used by
+	// AnalyzeSlices.
+	// Default is false.
+	KeepInterleaved bool
+	// AdaptiveMedianWindow is the length in detection frames (one hop each) of the
+	// window used by the peak picker's adaptive threshold, which subtracts the median
+	// and a fraction of the mean of the recent novelty. A shorter window reacts faster
+	// to level changes, recovering onsets right after a loud hit and a silent gap.
+	// Default is 7 if not set.
+	AdaptiveMedianWindow int
+	// AdaptiveDelta is the multiplier of the median in the adaptive threshold.
+	// Lower values make detection less dependent on the recent novelty level.
+	// Default is 1.0 if not set.
+	AdaptiveDelta float64
+	// SubsampleRefine reports onset times between samples by fitting a parabola
+	// to the raw novelty of the three frames around each detected peak, instead
+	// of rounding to whole samples. Optimization moves onsets back to whole
+	// samples, so use it with Optimize disabled.
+	// Default is false.
+	SubsampleRefine bool
+	// StrictLength makes AnalyzeSlices return an error when the number of decoded
+	// samples does not match the length declared by the WAV header, e.g. for a
+	// truncated file. When false, the mismatch is reported in Stats.Warnings.
+	// Default is false.
+	StrictLength bool
+	// Sensitivity in (0, 1] sets the detection threshold, minimum inter-onset
+	// interval and minimum spacing together, overriding UseMinimumSpacing,
+	// MinimumSpacing and SpacingBPM. Higher values find more onsets:
+	//   - threshold falls exponentially from 0.5 near 0 to 0.01 at 1
+	//   - minimum inter-onset interval falls linearly from 50 ms to 10 ms
+	//   - minimum spacing falls linearly from 200 ms to 30 ms
+	// Default is 0 (use the individual settings).
+	Sensitivity float64
+	// PadStartMs prepends this many milliseconds of silence before detection and
+	// shifts the onsets back, so a transient in the first frames is picked like
+	// any other instead of by the detector's start-of-file rule, which reports a
+	// non-silent start at 0 and can report a transient a few milliseconds in late.
+	// The tradeoff is that an onset already in progress at the start is only
+	// reported if it rises sharply enough after the silence, and onsets that the
+	// delay compensation places before the start are clamped to 0.
+	// Default is 0 (no padding).
+	PadStartMs float64
+	// NormalizeInput scales the samples to a peak of 1 before analysis, so the
+	// onsets do not depend on the input level: samples normalized by other tools
+	// give the same onsets as the WAV decoder's, which divides integer samples by
+	// 32768 (24-bit files are in about [-256, 256]). The result keeps the samples
+	// as given. Detection while decoding is not possible with it.
+	// Default is false.
+	NormalizeInput bool
+	// BinWeighting sets the weight of each spectral bin in the energy and hfc
+	// methods (see Specdesc.SetWeighting), e.g. to emphasize high bins for
+	// cymbals or low bins for toms.
+	// Default is nil (energy weights all bins equally, hfc by bin index).
+	BinWeighting func(bin, numBins uint) float64
+	// EnergyDb reports Energies in dBFS, 20*log10(rms), relative to an amplitude
+	// of 1 (full scale for 16-bit files; the WAV decoder divides by 32768, so
+	// 24-bit files can reach about +48 dBFS). Silence is reported as -120 dBFS.
+	// Default is false (linear RMS).
+	EnergyDb bool
+	// WindowMs is the length of the detector's analysis window in milliseconds.
+	// Default is twice the hop if not set.
+	WindowMs float64
+	// HopMs is the step between detection frames in milliseconds. Set, it is
+	// converted exactly to samples at the file's sample rate.
+	// Default (0) is the power of two closest to 5.8 ms, so 44.1 and 48 kHz use
+	// 256-sample hops with 512-sample windows, 88.2 and 96 kHz 512 and 1024, and
+	// 8 kHz 64 and 128: the detector behaves the same in time at any rate.
+	HopMs float64
+	// LookaheadMs holds each detected peak back this many milliseconds and
+	// replaces it with any stronger peak that follows within that time (see
+	// Onset.SetLookahead), so a small bump right before a larger transient does
+	// not trigger an onset that masks it. Onset times stay at the peaks.
+	// Subsample refinement is not applied with a lookahead.
+	// Default is 0 (no lookahead).
+	LookaheadMs float64
+	// MelBands reduces the spectrum to this many mel bands before the
+	// descriptor (see Onset.SetMelBands), so low-frequency onsets such as a
+	// bassline are not outweighed by high frequencies. It suits the magnitude
+	// methods (hfc, energy, specdiff, kl, mkl, specflux, complexity); BinWeighting
+	// then weights bands instead of bins.
+	// Default is 0 (linear spectrum).
+	MelBands int
+	// LogCompression computes the descriptor on log-compressed magnitudes
+	// log(1+C*|X|) with this constant C (see Onset.SetCompression), so a
+	// transient in an already loud passage changes the descriptor as much as
+	// one in a quiet passage, which helps with loud, compressed mixes. Larger
+	// values compress more. It replaces the method's own constant (1 for hfc
+	// and complex, 0.02 for kl and mkl, 10 for specflux, none for the others).
+	// Default is 0 (the method's own compression).
+	LogCompression float64
+	// SuppressPeriodic removes the onsets of the dominant periodic pulse, e.g.
+	// a steady hi-hat, to surface the non-repetitive hits. The period is the
+	// most common inter-onset interval, and an onset is part of the pulse when
+	// another onset lies one period (within 15 ms) before or after it. Nothing
+	// is removed unless at least three intervals and 30% of them agree on a
+	// period. The removed onsets are reported in RejectedOnsets.
+	// Default is false.
+	SuppressPeriodic bool
+
+	// The analysis is a staged pipeline: a dense detection pass finds every
+	// candidate, NumSlices, OnsetsPerSecond or EnergyPercentile select among
+	// them and MinOnsetEnergyDb drops the quiet ones, KeepSpacingMs and
+	// KeepStrongest thin the result by energy, and Optimize and the minimum
+	// spacing filter refine what is left. The options below control the
+	// detection and thinning stages separately.
+
+	// DetectThreshold is the peak picking threshold of the dense detection
+	// pass. Lower values find more candidates for the later stages. It takes
+	// precedence over the threshold derived from Sensitivity.
+	// Default is 0 (0.02, or the Sensitivity threshold).
+	DetectThreshold float64
+	// KeepSpacingMs thins the detected onsets so no two are closer than this
+	// many milliseconds, keeping the louder onset of a close pair (unlike
+	// MinimumSpacing, which keeps the earlier one).
+	// Default is 0 (no thinning by spacing).
+	KeepSpacingMs float64
+	// KeepStrongest keeps only the N loudest onsets after KeepSpacingMs, so
+	// the N onsets are also well spaced. Cannot be combined with NumSlices,
+	// OnsetsPerSecond or EnergyPercentile, which select before the thinning.
+	// Default is 0 (keep all).
+	KeepStrongest int
+	// OrderBy sets the order of Onsets and the aligned Strengths and Energies
+	// in the result: "time" (chronological) or "energy" (loudest first, e.g.
+	// to assign the loudest hits to the most prominent pads). Onsets of equal
+	// energy stay in time order. Functions that cut the audio at the onsets,
+	// such as ExportSlices, expect them in time order.
+	// Default is "time" if empty.
+	OrderBy string
+	// AutoSpacing sets the minimum spacing to a 32nd note at the tempo
+	// estimated from the detected onsets (see EstimateBPM), so it adapts to
+	// each file. When the tempo is uncertain (fewer than 8 onsets, or fewer
+	// than half of the inter-onset intervals agree on it), the spacing set by
+	// MinimumSpacing, SpacingBPM or Sensitivity is used instead.
+	// Only applies when UseMinimumSpacing is true. Default is false.
+	AutoSpacing bool
+	// EnergyFunc replaces the energy used to rank onsets, the RMS level of
+	// the 50ms following each onset, e.g. with a loudness model. It is called
+	// with the samples being analyzed (normalized with NormalizeInput) and
+	// ranks the onsets for NumSlices, OnsetsPerSecond, EnergyPercentile,
+	// KeepSpacingMs and KeepStrongest, including with the "consensus" method.
+	// Energies in the result, and OrderBy, still use the RMS level.
+	// Default is nil (RMS level).
+	EnergyFunc func(samples []float64, sampleRate uint, onsetTime float64) float64
+	// WhitenWarmup primes the adaptive spectral whitening of the methods that
+	// use it (complex, kl, mkl, specflux) with the signal before detecting
+	// (see Onset.PrimeWhitening). Otherwise the whitening adapts during the
+	// first frames and can suppress onsets near the start of the file.
+	// Default is false.
+	WhitenWarmup bool
+	// UseDifference detects onsets on the first difference of the samples,
+	// x[n]-x[n-1], instead of the samples. The difference is a cheap
+	// high-pass that removes rumble and makes clicks and other sharp
+	// transients stand out. Only detection sees the difference: energies,
+	// ranking and Optimize use the samples.
+	// Default is false.
+	UseDifference bool
+	// OnReject is called with the time in seconds and the reason of every
+	// candidate onset that is dropped, to find out why an onset is missing:
+	//   - "silence": the detector's silence gate
+	//   - "minioi": the detector's minimum inter-onset interval
+	//   - "lookahead": replaced by a stronger peak within LookaheadMs
+	//   - "pre-echo": merged into a louder hit by PreEchoGuardMs
+	//   - "prominence": a novelty peak less prominent than MinProminence
+	//   - "clipped": inside clipped audio with ClipAware
+	//   - "consensus": a consensus cluster with too few markers or too weak
+	//   - "best-n": not among the loudest for NumSlices or OnsetsPerSecond
+	//   - "percentile": below EnergyPercentile
+	//   - "floor": below MinOnsetEnergyDb
+	//   - "thinning": dropped by KeepSpacingMs or KeepStrongest
+	//   - "spacing": the minimum spacing filter
+	//   - "periodic": part of the pulse removed by SuppressPeriodic
+	// The detector reasons are reported by every detection pass, i.e. by each
+	// method with "consensus". Like RejectedOnsets, the times are the
+	// detected times, before Optimize. Default is nil.
+	OnReject func(timeSec float64, reason string)
+	// ConsensusCalibration maps the strengths of each method to a common 0
+	// to 1 scale before clustering, so ConsensusMinStrength and the consensus
+	// strengths compare methods whose novelty has different scales:
+	//   - "max": relative to the strongest onset of the method, which keeps
+	//     the proportions but lets a single outlier squash all other onsets
+	//   - "percentile": the percentile rank of the strength among the onsets
+	//     of the method, the fraction of them that are not stronger, which
+	//     gives every method the same distribution
+	// Default is "max" if empty. Only applies when Method is "consensus".
+	ConsensusCalibration string
+	// MinOnsetEnergyDb drops every onset whose level, the RMS of the 50ms
+	// following it in dBFS as reported by EnergyDb, is below this absolute
+	// floor, so a nearly silent file or a quiet noise floor gives no onsets
+	// instead of the relatively loudest noise. Unlike EnergyPercentile it
+	// does not depend on the other onsets. It is measured in the samples as
+	// given, before NormalizeInput, and applied after the selection stage.
+	// Default is 0 (no floor).
+	MinOnsetEnergyDb float64
+	// SilenceOpenDb is the level in dB, of the samples in a detection hop,
+	// at which the detector's silence gate opens, so onsets in quieter hops
+	// are dropped (reason "silence"). SilenceCloseDb, below it, is the level
+	// under which the open gate closes again: the hysteresis stops a signal
+	// hovering around the gate level from switching it on and off and
+	// producing bursts of onsets at the edge of silence (see
+	// Onset.SetSilenceHysteresis).
+	// Default is 0 for both (a single threshold at -70 dB); SilenceCloseDb 0
+	// is SilenceOpenDb, with no hysteresis.
+	SilenceOpenDb  float64
+	SilenceCloseDb float64
+	// NoveltySmoothFrames smooths the detection function with a moving
+	// average over this many frames before peak picking (see
+	// Onset.SetNoveltySmoothing), so a jittery novelty curve does not
+	// trigger twice on one onset. Unlike AdaptiveMedianWindow, which sets
+	// the threshold, it smooths the novelty itself; onset times are corrected
+	// for its delay. Subsample refinement is not applied with smoothing.
+	// Default is 0 (no smoothing).
+	NoveltySmoothFrames int
+	// Window is the analysis window of the detector: "hann", "hamming",
+	// "blackman" or "rectangular". The spectral magnitudes are corrected for
+	// the coherent gain of the window (see Pvoc.SetWindow), so the novelty
+	// and the energies stay on the same scale, and the energy ranking, which
+	// uses the time-domain RMS level, does not depend on the window.
+	// Default is "hann" if empty.
+	Window string
+	// PreEchoGuardMs merges an onset with a much louder one that follows
+	// within this many milliseconds, keeping only the louder, real hit: some
+	// transients, especially limited ones, have a small pre-spike a few
+	// milliseconds before the hit that triggers the detector too. Unlike the
+	// minimum spacing it only drops the earlier onset when the later one is
+	// at least 6 dB louder, comparing the level between the two onsets with
+	// that of the 50ms after the hit. It is applied by each detection pass,
+	// and dropped onsets are only reported to OnReject (reason "pre-echo").
+	// Default is 0 (no guard).
+	PreEchoGuardMs float64
+	// MinProminence drops the onsets whose novelty peak does not stand out
+	// from the surrounding novelty: the topographic prominence of the peak,
+	// its height above the higher of the valleys within 100ms on each side,
+	// must be at least this fraction of its height. Unlike the threshold and
+	// the adaptive median, it rejects shallow bumps on the high, busy
+	// novelty of dense passages while keeping isolated peaks of the same
+	// height. It is applied by each detection pass except "stereowidth", and
+	// dropped onsets are only reported to OnReject (reason "prominence").
+	// Default is 0 (no prominence required).
+	MinProminence float64
+	// AutoGainTimeMs enables a slow automatic gain control on the signal the
+	// detector sees, for recordings with large level swings between
+	// sections: the RMS level is tracked with this time constant and each
+	// hop is scaled toward AutoGainTargetDb, so one threshold and silence
+	// gate suit the quiet and the loud sections. Unlike NormalizeInput,
+	// which applies one gain to the whole signal, the gain follows the
+	// level, starting from that of the first hop, and is at most +40 dB.
+	// Energies, ranking and Optimize use the samples.
+	// Default is 0 (no gain control).
+	AutoGainTimeMs float64
+	// AutoGainTargetDb is the RMS level in dBFS the automatic gain control
+	// aims for.
+	// Default is -20 if 0.
+	AutoGainTargetDb float64
+	// ClipAware handles clipped audio, whose flat tops railed at full scale
+	// (runs of equal samples within 1% of the peak) the detector and Optimize
+	// otherwise mistake for onsets: candidates whose preceding 5ms are mostly
+	// clipped are dropped by each detection pass and only reported to
+	// OnReject (reason "clipped"), and Optimize never moves an onset inside a
+	// clipped run, so the onset of a clipped transient stays at its attack.
+	// Default is false.
+	ClipAware bool
+
+	// PreviewSec analyzes only the first PreviewSec seconds, e.g. for a quick
+	// preview shown before the full analysis completes. The result is that of
+	// the samples cut to this length: Samples, Duration and the onsets cover
+	// only the preview, and OnsetsPerSecond counts its duration. AnalyzeSlices
+	// stops decoding at the end of the preview, so a preview of a long file
+	// is quick.
+	// Default is 0 (the whole signal).
+	PreviewSec float64
+
+	// rightChannel is the right channel of a stereo file, set by
+	// AnalyzeSlices for the "stereowidth" method
+	rightChannel []float64
 }
 
 // DefaultSliceAnalyzerOptions returns default options for slice analysis
@@ -65,6 +455,8 @@ func DefaultSliceAnalyzerOptions() SliceAnalyzerOptions {
 
 // AnalyzeSlices performs onset detection and slice analysis on a WAV file.
 // It returns the detected onset times along with audio samples and metadata.
+// A file whose samples are all identical (e.g. a silent export) is valid and
+// gives an empty onset list.
 //
 // Parameters:
 //   - wavFile: Path to the WAV file to analyze
@@ -74,11 +466,126 @@ func DefaultSliceAnalyzerOptions() SliceAnalyzerOptions {
 //   - SliceAnalyzerResult containing onsets, samples, and sample rate
 //   - error if the file cannot be read or processed
 func AnalyzeSlices(wavFile string, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
-	// Read audio file (left channel only)
-	samples, sampleRate, err := readWavFileLeftChannel(wavFile)
+	if err := validateOptions(options); err != nil {
+		return nil, err
+	}
+
+	options, err := applySensitivity(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default to "hfc" if method is not specified
+	method := options.Method
+	if method == "" {
+		method = "hfc"
+	}
+
+	// When no stage needs the samples after detection, detect while decoding
+	// so the samples are never held in memory
+	if options.DropSamples && canStreamDetection(method, options) {
+		onsets, strengths, sampleRate, stats, err := streamOnsetsFromWavFile(wavFile, relaxedDetector(method, options), options.StrictLength, options.PreviewSec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audio file: %w", err)
+		}
+
+		var rejected []float64
+		if options.UseMinimumSpacing && len(onsets) > 0 {
+			kept, dropped := applyMinimumSpacing(onsets, onsetSpacingMs(onsets, options))
+			onsets, strengths, rejected = kept, alignStrengths(onsets, strengths, kept), dropped
+			reportRejected(options.OnReject, dropped, "spacing")
+		}
+
+		if options.SuppressPeriodic {
+			kept, removed := suppressPeriodicOnsets(onsets)
+			onsets, strengths = kept, alignStrengths(onsets, strengths, kept)
+			rejected = append(rejected, removed...)
+			reportRejected(options.OnReject, removed, "periodic")
+		}
+
+		return &SliceAnalyzerResult{
+			Onsets:         onsets,
+			Strengths:      strengths,
+			RejectedOnsets: sortedOnsets(rejected),
+			NumDetected:    len(onsets) + len(rejected),
+			SampleRate:     sampleRate,
+			Duration:       stats.DecodedDuration,
+			Method:         method,
+			Stats:          stats,
+		}, nil
+	}
+
+	// Read audio file (left channel only, and all channels if requested or
+	// needed by the method)
+	samples, interleaved, info, stats, err := decodeWavFile(wavFile, options.StrictLength, options.KeepInterleaved || method == "stereowidth", options.PreviewSec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read audio file: %w", err)
 	}
+	if method == "stereowidth" && info.NumChannels >= 2 {
+		options.rightChannel = make([]float64, len(samples))
+		for i := range options.rightChannel {
+			options.rightChannel[i] = interleaved[i*info.NumChannels+1]
+		}
+	}
+
+	result, err := AnalyzeSamples(samples, info.SampleRate, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.KeepInterleaved {
+		result.Interleaved = interleaved
+		result.NumChannels = info.NumChannels
+	}
+	result.Stats = stats
+
+	return result, nil
+}
+
+// AnalyzeSamples performs onset detection and slice analysis on mono audio samples
+// that are already in memory, e.g. decoded by other tooling or generated.
+// Samples are expected in the same scale as AnalyzeSlices decodes them.
+// Constant samples (e.g. all zeros) give an empty onset list and no error.
+// There is no minimum length: the last hops are zero-padded, so even a sound
+// shorter than one detection hop (about 6ms) gives its onset. A sound that
+// starts within the detector's delay (about 25ms) is reported at 0 by the
+// start-of-file rule unless PadStartMs is set.
+//
+// Parameters:
+//   - samples: Mono audio samples
+//   - sampleRate: Sample rate of the samples in Hz
+//   - options: Configuration options for the analysis
+//
+// Returns:
+//   - SliceAnalyzerResult containing onsets, samples, and sample rate
+//   - error if the input cannot be processed
+func AnalyzeSamples(samples []float64, sampleRate uint, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+
+	if err := validateOptions(options); err != nil {
+		return nil, err
+	}
+
+	options, err := applySensitivity(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := outlierFilterFor(options); err != nil {
+		return nil, err
+	}
+
+	// Keep only the preview if requested
+	if options.PreviewSec > 0 {
+		samples = samples[:min(len(samples), int(options.PreviewSec*float64(sampleRate)))]
+	}
+
+	// Derive the number of slices from the duration
+	if options.OnsetsPerSecond > 0 {
+		duration := float64(len(samples)) / float64(sampleRate)
+		options.NumSlices = int(math.Max(1, math.Round(duration*options.OnsetsPerSecond)))
+	}
 
 	// Default to "hfc" if method is not specified
 	method := options.Method
@@ -86,68 +593,420 @@ func AnalyzeSlices(wavFile string, options SliceAnalyzerOptions) (*SliceAnalyzer
 		method = "hfc"
 	}
 
-	var onsets []float64
+	// Analyze a copy at a fixed level, returning the samples as given
+	input := samples
+	if options.NormalizeInput {
+		samples = normalizePeak(samples)
+	}
+
+	// Choose a concrete method from the signal
+	if method == "auto" {
+		method = selectMethod(samples, sampleRate)
+	}
+	if method == "stereowidth" && len(options.rightChannel) == 0 {
+		return nil, fmt.Errorf("the stereowidth method needs both channels of a stereo file")
+	}
+
+	// A constant signal, e.g. a silent export, has no onsets. This is a valid
+	// result rather than an error, and skips the detector, which would report
+	// the start of a non-zero constant as an onset.
+	if isConstant(samples) {
+		if options.DropSamples {
+			input = nil
+		}
+		return &SliceAnalyzerResult{
+			Onsets:     []float64{},
+			Strengths:  []float64{},
+			Energies:   []float64{},
+			Samples:    input,
+			SampleRate: sampleRate,
+			Duration:   float64(len(samples)) / float64(sampleRate),
+			Method:     method,
+		}, nil
+	}
+
+	var onsets, strengths, rejected []float64
+	var perMethod map[string][]float64
 
 	if method == "consensus" {
 		// Use consensus method: run all methods and generate consensus
-		onsets = findConsensusOnsets(samples, sampleRate, options)
+		onsets, strengths, rejected, perMethod = findConsensusOnsets(samples, sampleRate, options)
 	} else if options.NumSlices > 0 {
 		// Find the best N onsets based on energy
-		onsets = findBestOnsets(samples, sampleRate, options.NumSlices, method)
+		onsets, strengths, rejected = findBestOnsets(samples, sampleRate, options.NumSlices, relaxedDetector(method, options), onsetEnergyFunc(options))
+		reportRejected(options.OnReject, rejected, "best-n")
+	} else if options.EnergyPercentile > 0 {
+		// Find the onsets louder than the percentile of all candidates
+		allOnsets, allStrengths := findAllOnsets(samples, sampleRate, relaxedDetector(method, options))
+		onsets, rejected = selectOnsetsAbovePercentile(samples, sampleRate, allOnsets, options.EnergyPercentile, onsetEnergyFunc(options))
+		strengths = alignStrengths(allOnsets, allStrengths, onsets)
+		reportRejected(options.OnReject, rejected, "percentile")
 	} else {
 		// Find all onsets
-		onsets = findAllOnsets(samples, sampleRate, method)
+		onsets, strengths = findAllOnsets(samples, sampleRate, relaxedDetector(method, options))
+	}
+
+	// Drop the onsets below the absolute energy floor if requested
+	if options.MinOnsetEnergyDb != 0 {
+		kept, dropped := selectOnsetsAboveFloor(input, sampleRate, onsets, options.MinOnsetEnergyDb)
+		onsets, strengths = kept, alignStrengths(onsets, strengths, kept)
+		rejected = append(rejected, dropped...)
+		reportRejected(options.OnReject, dropped, "floor")
 	}
 
-	// Optimize onset positions if requested
+	// Thin the onsets by energy if requested
+	if options.KeepSpacingMs > 0 || options.KeepStrongest > 0 {
+		kept, dropped := thinOnsets(samples, sampleRate, onsets, options.KeepSpacingMs, options.KeepStrongest, onsetEnergyFunc(options))
+		onsets, strengths = kept, alignStrengths(onsets, strengths, kept)
+		rejected = append(rejected, dropped...)
+		reportRejected(options.OnReject, dropped, "thinning")
+	}
+
+	// Optimize onset positions if requested. This runs after the selection
+	// and thinning, so only the kept onsets are refined; with "consensus" the
+	// selected onsets keep their cluster midpoints until here.
+	// detected holds the time of each onset before Optimize, aligned with
+	// the onsets, so later stages report rejected onsets at detected times
+	detected := onsets
 	if options.Optimize && len(onsets) > 0 {
-		onsets = optimizeOnsetPositions(samples, sampleRate, onsets, options.OptimizeWindowMs)
+		var clipped []bool
+		if options.ClipAware {
+			clipped = clippedSamples(samples)
+		}
+		onsets = optimizeOnsetPositions(samples, sampleRate, onsets, options.OptimizeWindowMs, clipped)
 	}
 
 	// Apply minimum spacing filter if requested
 	if options.UseMinimumSpacing && len(onsets) > 0 {
-		onsets = applyMinimumSpacing(onsets, options.MinimumSpacing)
+		kept, _ := applyMinimumSpacing(onsets, onsetSpacingMs(onsets, options))
+		var dropped []float64
+		detected, dropped = splitAligned(onsets, detected, kept)
+		onsets, strengths = kept, alignStrengths(onsets, strengths, kept)
+		rejected = append(rejected, dropped...)
+		reportRejected(options.OnReject, dropped, "spacing")
+	}
+
+	// Remove the onsets of a steady pulse if requested
+	if options.SuppressPeriodic {
+		kept, _ := suppressPeriodicOnsets(onsets)
+		var removed []float64
+		detected, removed = splitAligned(onsets, detected, kept)
+		onsets, strengths = kept, alignStrengths(onsets, strengths, kept)
+		rejected = append(rejected, removed...)
+		reportRejected(options.OnReject, removed, "periodic")
+	}
+
+	// Levels of the onsets in the samples as given
+	energies := make([]float64, len(onsets))
+	for i, onsetTime := range onsets {
+		energies[i] = calculateOnsetEnergy(input, sampleRate, onsetTime)
+		if options.EnergyDb {
+			energies[i] = rmsToDb(energies[i])
+		}
+	}
+
+	// Loudest first if requested
+	if options.OrderBy == "energy" {
+		orderByEnergy(onsets, strengths, energies)
+	}
+
+	duration := float64(len(samples)) / float64(sampleRate)
+	if options.DropSamples {
+		input = nil
 	}
 
 	return &SliceAnalyzerResult{
-		Onsets:     onsets,
-		Samples:    samples,
-		SampleRate: sampleRate,
+		Onsets:          onsets,
+		Strengths:       strengths,
+		Energies:        energies,
+		RejectedOnsets:  sortedOnsets(rejected),
+		NumDetected:     len(onsets) + len(rejected),
+		Samples:         input,
+		SampleRate:      sampleRate,
+		Duration:        duration,
+		Method:          method,
+		PerMethodOnsets: perMethod,
 	}, nil
 }
 
-// readWavFileLeftChannel reads a WAV file and returns only the left channel (or mono)
+// orderByEnergy sorts the onsets and their aligned strengths in place by
+// descending energy, keeping the time order of equal energies
+func orderByEnergy(onsets, strengths, energies []float64) {
+	order := make([]int, len(onsets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return energies[order[a]] > energies[order[b]]
+	})
+
+	for _, values := range [][]float64{onsets, strengths, energies} {
+		if len(values) != len(order) {
+			continue
+		}
+		sorted := make([]float64, len(values))
+		for i, j := range order {
+			sorted[i] = values[j]
+		}
+		copy(values, sorted)
+	}
+}
+
+// reportRejected passes each of the rejected onsets to onReject with the
+// reason, if onReject is set
+func reportRejected(onReject func(timeSec float64, reason string), rejected []float64, reason string) {
+	if onReject == nil {
+		return
+	}
+	for _, onsetTime := range rejected {
+		onReject(onsetTime, reason)
+	}
+}
+
+// sortedOnsets returns the onsets sorted by time, or nil if there are none
+func sortedOnsets(onsets []float64) []float64 {
+	if len(onsets) == 0 {
+		return nil
+	}
+	sort.Float64s(onsets)
+	return onsets
+}
+
+// isConstant reports whether all samples have the same value (including when
+// there are none)
+func isConstant(samples []float64) bool {
+	return len(samples) == 0 || allEqual(samples, samples[0])
+}
+
+// allEqual reports whether all samples equal value
+func allEqual(samples []float64, value float64) bool {
+	for _, v := range samples {
+		if v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// firstDifference returns the first difference of the samples, x[n]-x[n-1],
+// starting from silence
+func firstDifference(samples []float64) []float64 {
+	difference := make([]float64, len(samples))
+	previous := 0.0
+	for i, v := range samples {
+		difference[i] = v - previous
+		previous = v
+	}
+	return difference
+}
+
+// normalizePeak returns a copy of the samples scaled to a peak absolute value
+// of 1, or the samples unchanged when they are silent
+func normalizePeak(samples []float64) []float64 {
+	peak := 0.0
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak == 0 {
+		return samples
+	}
+
+	normalized := make([]float64, len(samples))
+	for i, v := range samples {
+		normalized[i] = v / peak
+	}
+	return normalized
+}
+
+// validateOptions checks that at most one way of choosing the number of slices is
+// set and that the numeric options are in range
+func validateOptions(options SliceAnalyzerOptions) error {
+	if options.OnsetsPerSecond < 0 {
+		return fmt.Errorf("invalid onsets per second: %f", options.OnsetsPerSecond)
+	}
+	if options.OnsetsPerSecond > 0 && options.NumSlices > 0 {
+		return fmt.Errorf("NumSlices and OnsetsPerSecond cannot both be set")
+	}
+	if options.EnergyPercentile < 0 || options.EnergyPercentile >= 100 {
+		return fmt.Errorf("invalid energy percentile: %f", options.EnergyPercentile)
+	}
+	if options.EnergyPercentile > 0 && (options.NumSlices > 0 || options.OnsetsPerSecond > 0) {
+		return fmt.Errorf("EnergyPercentile cannot be combined with NumSlices or OnsetsPerSecond")
+	}
+	if options.WindowMs < 0 || options.HopMs < 0 {
+		return fmt.Errorf("invalid window %f ms or hop %f ms", options.WindowMs, options.HopMs)
+	}
+	if options.LookaheadMs < 0 {
+		return fmt.Errorf("invalid lookahead %f ms", options.LookaheadMs)
+	}
+	if options.PreviewSec < 0 {
+		return fmt.Errorf("invalid preview length %f s", options.PreviewSec)
+	}
+	if options.AutoGainTimeMs < 0 {
+		return fmt.Errorf("invalid auto gain time constant %f ms", options.AutoGainTimeMs)
+	}
+	if options.PreEchoGuardMs < 0 {
+		return fmt.Errorf("invalid pre-echo guard %f ms", options.PreEchoGuardMs)
+	}
+	if options.MinProminence < 0 || options.MinProminence > 1 {
+		return fmt.Errorf("invalid minimum prominence %f (must be between 0 and 1)", options.MinProminence)
+	}
+	if options.NoveltySmoothFrames < 0 {
+		return fmt.Errorf("invalid novelty smoothing of %d frames", options.NoveltySmoothFrames)
+	}
+	if options.MelBands < 0 {
+		return fmt.Errorf("invalid number of mel bands %d", options.MelBands)
+	}
+	if options.LogCompression < 0 {
+		return fmt.Errorf("invalid log compression %f", options.LogCompression)
+	}
+	if options.DetectThreshold < 0 {
+		return fmt.Errorf("invalid detection threshold: %f", options.DetectThreshold)
+	}
+	if options.KeepSpacingMs < 0 || options.KeepStrongest < 0 {
+		return fmt.Errorf("invalid thinning: keep %d strongest with %f ms spacing", options.KeepStrongest, options.KeepSpacingMs)
+	}
+	if options.KeepStrongest > 0 && (options.NumSlices > 0 || options.OnsetsPerSecond > 0 || options.EnergyPercentile > 0) {
+		return fmt.Errorf("KeepStrongest cannot be combined with NumSlices, OnsetsPerSecond or EnergyPercentile")
+	}
+	if options.WindowMs > 0 && options.HopMs > options.WindowMs {
+		return fmt.Errorf("hop (%f ms) cannot be longer than the window (%f ms)", options.HopMs, options.WindowMs)
+	}
+	if options.SilenceCloseDb != 0 && (options.SilenceOpenDb == 0 || options.SilenceCloseDb > options.SilenceOpenDb) {
+		return fmt.Errorf("invalid silence gate: close at %f dB must be set with and below open at %f dB", options.SilenceCloseDb, options.SilenceOpenDb)
+	}
+	if _, ok := windowShapes[options.Window]; options.Window != "" && !ok {
+		return fmt.Errorf("unknown window: %q (must be \"hann\", \"hamming\", \"blackman\" or \"rectangular\")", options.Window)
+	}
+	if options.ConsensusCalibration != "" && options.ConsensusCalibration != "max" && options.ConsensusCalibration != "percentile" {
+		return fmt.Errorf("unknown consensus calibration: %q (must be \"max\" or \"percentile\")", options.ConsensusCalibration)
+	}
+	if options.OrderBy != "" && options.OrderBy != "time" && options.OrderBy != "energy" {
+		return fmt.Errorf("unknown order: %q (must be \"time\" or \"energy\")", options.OrderBy)
+	}
+	return nil
+}
+
+// applySensitivity replaces the spacing options with those derived from
+// Sensitivity when it is set; the detector settings are derived in relaxedDetector
+func applySensitivity(options SliceAnalyzerOptions) (SliceAnalyzerOptions, error) {
+	if options.Sensitivity < 0 || options.Sensitivity > 1 {
+		return options, fmt.Errorf("invalid sensitivity: %f (must be between 0 and 1)", options.Sensitivity)
+	}
+	if options.Sensitivity > 0 {
+		_, _, spacingMs := sensitivityParameters(options.Sensitivity)
+		options.UseMinimumSpacing = true
+		options.MinimumSpacing = spacingMs
+		options.SpacingBPM = 0
+	}
+	return options, nil
+}
+
+// sensitivityParameters maps a sensitivity in [0, 1] to a detection threshold,
+// a minimum inter-onset interval and a minimum spacing in milliseconds
+func sensitivityParameters(sensitivity float64) (threshold, minioiMs, spacingMs float64) {
+	threshold = 0.5 * math.Pow(0.01/0.5, sensitivity)
+	minioiMs = 50.0 - 40.0*sensitivity
+	spacingMs = 200.0 - 170.0*sensitivity
+	return threshold, minioiMs, spacingMs
+}
+
+// canStreamDetection reports whether the analysis only needs the detected onsets
+// and never looks at the samples again, so detection can run while decoding
+func canStreamDetection(method string, options SliceAnalyzerOptions) bool {
+	return method != "consensus" && method != "auto" && method != "stereowidth" && options.NumSlices <= 0 && options.OnsetsPerSecond <= 0 && options.EnergyPercentile <= 0 && options.KeepSpacingMs <= 0 && options.KeepStrongest <= 0 && options.OrderBy != "energy" && options.MinOnsetEnergyDb == 0 && options.PreEchoGuardMs <= 0 && options.MinProminence <= 0 && !options.ClipAware && !options.WhitenWarmup && !options.Optimize && !options.NormalizeInput && !options.KeepInterleaved
+}
+
+// readWavFileLeftChannel reads a WAV file and returns only the left channel (or mono).
+// The PCM data is decoded in fixed-size blocks so only the mono samples are held in memory.
 func readWavFileLeftChannel(filename string) ([]float64, uint, error) {
-	f, err := os.Open(filename)
+	samples, _, info, _, err := decodeWavFile(filename, false, false, 0)
+	return samples, info.SampleRate, err
+}
+
+// decodeWavFile reads the left channel (or mono) of a WAV file, or only its
+// first previewSec seconds (0 = all), and checks the decoded length against
+// the header. With keepInterleaved, it also returns every channel
+// interleaved, in the same scale.
+func decodeWavFile(filename string, strictLength, keepInterleaved bool, previewSec float64) ([]float64, []float64, WavInfo, SliceAnalyzerStats, error) {
+	reader, err := openWavBlockReader(filename)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+		return nil, nil, WavInfo{}, SliceAnalyzerStats{}, err
+	}
+	defer reader.Close()
+	reader.limit(previewSec)
+	reader.keepInterleaved = keepInterleaved
+	if keepInterleaved {
+		reader.interleaved = make([]float64, 0, reader.expectedFrames()*reader.info.NumChannels)
 	}
-	defer f.Close()
 
-	decoder := wav.NewDecoder(f)
-	if !decoder.IsValidFile() {
-		return nil, 0, fmt.Errorf("invalid WAV file")
+	samples := make([]float64, 0, reader.expectedFrames())
+	for {
+		block, err := reader.Next()
+		if err != nil {
+			return nil, nil, WavInfo{}, SliceAnalyzerStats{}, err
+		}
+		if len(block) == 0 {
+			break
+		}
+		samples = append(samples, block...)
+	}
+
+	stats, err := reader.checkLength(strictLength)
+	if err != nil {
+		return nil, nil, WavInfo{}, stats, err
+	}
+
+	var interleaved []float64
+	if keepInterleaved {
+		interleaved = reader.Interleaved()
 	}
 
-	sampleRate := uint(decoder.SampleRate)
+	return samples, interleaved, reader.info, stats, nil
+}
 
-	// Read all audio data
-	buf, err := decoder.FullPCMBuffer()
+// streamOnsetsFromWavFile detects onsets while decoding a WAV file block by block,
+// or only its first previewSec seconds (0 = all), without retaining the samples
+func streamOnsetsFromWavFile(filename string, config detectorConfig, strictLength bool, previewSec float64) ([]float64, []float64, uint, SliceAnalyzerStats, error) {
+	reader, err := openWavBlockReader(filename)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to read PCM data: %w", err)
+		return nil, nil, 0, SliceAnalyzerStats{}, err
 	}
+	defer reader.Close()
+	reader.limit(previewSec)
+
+	detector := newStreamingDetector(reader.info.SampleRate, config)
+	constant := true
+	first := 0.0
+	for {
+		block, err := reader.Next()
+		if err != nil {
+			return nil, nil, 0, SliceAnalyzerStats{}, err
+		}
+		if len(block) == 0 {
+			break
+		}
+		if constant {
+			if reader.frames == len(block) {
+				first = block[0]
+			}
+			constant = allEqual(block, first)
+		}
+		detector.write(block)
+	}
+	detector.flush()
 
-	numChannels := buf.Format.NumChannels
-	numSamples := len(buf.Data) / numChannels
-	samples := make([]float64, numSamples)
+	stats, err := reader.checkLength(strictLength)
+	if err != nil {
+		return nil, nil, 0, stats, err
+	}
 
-	// Extract left channel only (channel 0)
-	for i := 0; i < numSamples; i++ {
-		// Normalize int to float64 [-1.0, 1.0]
-		samples[i] = float64(buf.Data[i*numChannels]) / 32768.0
+	// A constant signal has no onsets (see AnalyzeSamples)
+	if constant {
+		return []float64{}, []float64{}, reader.info.SampleRate, stats, nil
 	}
 
-	return samples, sampleRate, nil
+	return detector.onsets, detector.strengths, reader.info.SampleRate, stats, nil
 }
 
 // onsetWithEnergy stores an onset time and its energy
@@ -156,32 +1015,53 @@ type onsetWithEnergy struct {
 	energy float64
 }
 
-// findBestOnsets uses onset detection to find the best N onsets in the audio.
-// The "best" onsets are those with the highest energy/loudness.
-func findBestOnsets(samples []float64, sampleRate uint, targetSlices int, method string) []float64 {
-	bufSize := uint(512)
-	hopSize := uint(256)
+// onsetEnergy measures the energy of the onset at onsetTime in the samples
+type onsetEnergy func(samples []float64, sampleRate uint, onsetTime float64) float64
 
+// onsetEnergyFunc returns the energy used to rank onsets: EnergyFunc if set,
+// the RMS level after the onset otherwise
+func onsetEnergyFunc(options SliceAnalyzerOptions) onsetEnergy {
+	if options.EnergyFunc != nil {
+		return options.EnergyFunc
+	}
+	return calculateOnsetEnergy
+}
+
+// findBestOnsets uses onset detection to find the best N onsets in the audio.
+// The "best" onsets are those with the highest energy/loudness as measured by
+// energy. It returns the selected onsets, their strengths and the detected
+// onsets that were not selected.
+func findBestOnsets(samples []float64, sampleRate uint, targetSlices int, config detectorConfig, energy onsetEnergy) ([]float64, []float64, []float64) {
 	// Detect all onsets with relaxed parameters to get more candidates
-	allOnsets := detectAllOnsets(samples, sampleRate, method, bufSize, hopSize)
+	allOnsets, allStrengths := findAllOnsets(samples, sampleRate, config)
 
 	if len(allOnsets) == 0 {
-		return []float64{}
+		return []float64{}, []float64{}, nil
 	}
 
+	selected, rejected := selectBestOnsets(samples, sampleRate, allOnsets, targetSlices, energy)
+	return selected, alignStrengths(allOnsets, allStrengths, selected), rejected
+}
+
+// selectBestOnsets keeps the N onsets with the highest energy, in chronological order.
+// It returns the selected onsets and the rejected ones, both sorted by time.
+func selectBestOnsets(samples []float64, sampleRate uint, onsets []float64, targetSlices int, energy onsetEnergy) ([]float64, []float64) {
 	// Calculate energy at each onset
-	onsetsWithEnergy := make([]onsetWithEnergy, len(allOnsets))
-	for i, onsetTime := range allOnsets {
-		energy := calculateOnsetEnergy(samples, sampleRate, onsetTime)
+	onsetsWithEnergy := make([]onsetWithEnergy, len(onsets))
+	for i, onsetTime := range onsets {
 		onsetsWithEnergy[i] = onsetWithEnergy{
 			time:   onsetTime,
-			energy: energy,
+			energy: energy(samples, sampleRate, onsetTime),
 		}
 	}
 
-	// Sort by energy (descending)
-	sort.Slice(onsetsWithEnergy, func(i, j int) bool {
-		return onsetsWithEnergy[i].energy > onsetsWithEnergy[j].energy
+	// Sort by energy (descending), breaking ties by time so the selection
+	// does not depend on the sort algorithm
+	sort.SliceStable(onsetsWithEnergy, func(i, j int) bool {
+		if onsetsWithEnergy[i].energy != onsetsWithEnergy[j].energy {
+			return onsetsWithEnergy[i].energy > onsetsWithEnergy[j].energy
+		}
+		return onsetsWithEnergy[i].time < onsetsWithEnergy[j].time
 	})
 
 	// Take top N onsets
@@ -189,141 +1069,418 @@ func findBestOnsets(samples []float64, sampleRate uint, targetSlices int, method
 	if numToSelect > len(onsetsWithEnergy) {
 		numToSelect = len(onsetsWithEnergy)
 	}
-	bestOnsets := onsetsWithEnergy[:numToSelect]
 
-	// Sort back by time for output
-	sort.Slice(bestOnsets, func(i, j int) bool {
-		return bestOnsets[i].time < bestOnsets[j].time
+	// Extract just the times, sorted back by time for output
+	selected := make([]float64, numToSelect)
+	for i, onset := range onsetsWithEnergy[:numToSelect] {
+		selected[i] = onset.time
+	}
+	sort.Float64s(selected)
+
+	rejected := make([]float64, len(onsetsWithEnergy)-numToSelect)
+	for i, onset := range onsetsWithEnergy[numToSelect:] {
+		rejected[i] = onset.time
+	}
+	sort.Float64s(rejected)
+
+	return selected, rejected
+}
+
+// thinOnsets keeps the loudest onsets, skipping any onset closer than
+// spacingMs to a louder one already kept, until keep onsets are kept (0 for
+// no limit). It returns the kept onsets and the dropped ones, sorted by time.
+func thinOnsets(samples []float64, sampleRate uint, onsets []float64, spacingMs float64, keep int, energy onsetEnergy) ([]float64, []float64) {
+	byEnergy := make([]onsetWithEnergy, len(onsets))
+	for i, onsetTime := range onsets {
+		byEnergy[i] = onsetWithEnergy{
+			time:   onsetTime,
+			energy: energy(samples, sampleRate, onsetTime),
+		}
+	}
+
+	// Loudest first, breaking ties by time so the result does not depend on
+	// the sort algorithm
+	sort.SliceStable(byEnergy, func(i, j int) bool {
+		if byEnergy[i].energy != byEnergy[j].energy {
+			return byEnergy[i].energy > byEnergy[j].energy
+		}
+		return byEnergy[i].time < byEnergy[j].time
 	})
 
-	// Extract just the times
-	result := make([]float64, len(bestOnsets))
-	for i, onset := range bestOnsets {
-		result[i] = onset.time
+	spacingSec := spacingMs / 1000.0
+	kept := []float64{}
+	var dropped []float64
+	for _, onset := range byEnergy {
+		tooClose := false
+		for _, k := range kept {
+			if math.Abs(onset.time-k) < spacingSec {
+				tooClose = true
+				break
+			}
+		}
+		if tooClose || (keep > 0 && len(kept) >= keep) {
+			dropped = append(dropped, onset.time)
+		} else {
+			kept = append(kept, onset.time)
+		}
 	}
 
-	return result
+	sort.Float64s(kept)
+	sort.Float64s(dropped)
+	return kept, dropped
 }
 
-// findAllOnsets detects all onsets in the audio with default parameters
-func findAllOnsets(samples []float64, sampleRate uint, method string) []float64 {
-	bufSize := uint(512)
-	hopSize := uint(256)
+// selectOnsetsAboveFloor keeps the onsets whose RMS level is at least floorDb
+// dBFS. It returns the kept onsets and the dropped ones, in the order of the
+// onsets.
+func selectOnsetsAboveFloor(samples []float64, sampleRate uint, onsets []float64, floorDb float64) ([]float64, []float64) {
+	kept := []float64{}
+	var dropped []float64
+	for _, onsetTime := range onsets {
+		if rmsToDb(calculateOnsetEnergy(samples, sampleRate, onsetTime)) >= floorDb {
+			kept = append(kept, onsetTime)
+		} else {
+			dropped = append(dropped, onsetTime)
+		}
+	}
+	return kept, dropped
+}
+
+// selectOnsetsAbovePercentile keeps the onsets whose energy is above the given
+// percentile of the energies of all onsets. It returns the selected onsets and
+// the rejected ones, in the order of the onsets.
+func selectOnsetsAbovePercentile(samples []float64, sampleRate uint, onsets []float64, percentile float64, energy onsetEnergy) ([]float64, []float64) {
+	energies := make([]float64, len(onsets))
+	for i, onsetTime := range onsets {
+		energies[i] = energy(samples, sampleRate, onsetTime)
+	}
+
+	sorted := append([]float64(nil), energies...)
+	sort.Float64s(sorted)
+	threshold := calculatePercentile(sorted, percentile)
 
-	return detectAllOnsets(samples, sampleRate, method, bufSize, hopSize)
+	selected := []float64{}
+	var rejected []float64
+	for i, onsetTime := range onsets {
+		if energies[i] > threshold {
+			selected = append(selected, onsetTime)
+		} else {
+			rejected = append(rejected, onsetTime)
+		}
+	}
+
+	return selected, rejected
 }
 
+// SuggestSliceCount suggests a natural NumSlices for the samples from the knee
+// of the energy distribution of the candidate onsets, where the strong hits
+// end and the quieter ones begin. All candidates are detected with the given
+// method ("hfc" if empty; not "consensus" or "auto"), their energies (RMS
+// level in dB of the 50ms after each) sorted from loudest to quietest, and
+// the knee is the candidate farthest from the straight line between the
+// loudest and the quietest. It returns the number of candidates up to the
+// knee, all of them when their energies are about the same, and 0 when there
+// are none.
+func SuggestSliceCount(samples []float64, sampleRate uint, method string) int {
+	if sampleRate == 0 || isConstant(samples) {
+		return 0
+	}
+	if method == "" {
+		method = "hfc"
+	}
+
+	onsets, _ := findAllOnsets(samples, sampleRate, relaxedDetector(method, SliceAnalyzerOptions{}))
+	n := len(onsets)
+	if n < 3 {
+		return n
+	}
+
+	energies := make([]float64, n)
+	for i, onsetTime := range onsets {
+		energies[i] = rmsToDb(calculateOnsetEnergy(samples, sampleRate, onsetTime))
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(energies)))
+
+	// A plateau of strong hits followed by a drop lies above the line, the
+	// knee being the last strong hit; a few strong hits followed by a
+	// plateau of weak ones lies below it, the knee being the first weak one
+	above, aboveIdx := 0.0, 0
+	below, belowIdx := 0.0, 0
+	for i, energy := range energies {
+		line := energies[0] + (energies[n-1]-energies[0])*float64(i)/float64(n-1)
+		if d := energy - line; d > above {
+			above, aboveIdx = d, i
+		} else if -d > below {
+			below, belowIdx = -d, i
+		}
+	}
+
+	switch {
+	case above < suggestKneeMinDb && below < suggestKneeMinDb:
+		return n
+	case above >= below:
+		return aboveIdx + 1
+	default:
+		return belowIdx
+	}
+}
+
+// suggestKneeMinDb is how far in dB the knee must lie from the line between
+// the loudest and quietest candidates for SuggestSliceCount to select fewer
+// than all candidates
+const suggestKneeMinDb = 1.0
+
+// findAllOnsets detects all onsets in the audio with the given detector and
+// returns them with their strengths
+func findAllOnsets(samples []float64, sampleRate uint, config detectorConfig) ([]float64, []float64) {
+	return detectOnsetsWithStrength(samples, sampleRate, config)
+}
+
+// alignStrengths returns the strengths of the onsets in subset, which must be
+// a chronological subset of onsets, given the strengths of onsets
+func alignStrengths(onsets, strengths, subset []float64) []float64 {
+	aligned := make([]float64, 0, len(subset))
+	j := 0
+	for _, onsetTime := range subset {
+		for j < len(onsets) && onsets[j] != onsetTime {
+			j++
+		}
+		if j == len(onsets) {
+			break
+		}
+		aligned = append(aligned, strengths[j])
+		j++
+	}
+	return aligned
+}
+
+// splitAligned splits values, aligned with onsets, into those of the kept
+// onsets and those of the others. The kept onsets must be in the order of the
+// onsets; equal onsets are matched first to first, so when two onsets share a
+// time and one is dropped, the later one is.
+func splitAligned(onsets, values, kept []float64) ([]float64, []float64) {
+	keptValues := make([]float64, 0, len(kept))
+	var droppedValues []float64
+	k := 0
+	for j, onsetTime := range onsets {
+		if k < len(kept) && kept[k] == onsetTime {
+			keptValues = append(keptValues, values[j])
+			k++
+		} else {
+			droppedValues = append(droppedValues, values[j])
+		}
+	}
+	return keptValues, droppedValues
+}
+
+// consensusMethods are the methods run by the consensus method, in a fixed
+// order so results are reproducible
+var consensusMethods = []string{"energy", "hfc", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux"}
+
 // findConsensusOnsets runs all detection methods and generates consensus markers
-// by clustering nearby onsets and taking the midpoint of each cluster
-func findConsensusOnsets(samples []float64, sampleRate uint, options SliceAnalyzerOptions) []float64 {
-	bufSize := uint(512)
-	hopSize := uint(256)
+// by clustering nearby onsets and taking the midpoint of each cluster.
+// It returns the consensus onsets, those dropped by the best-N selection, and
+// the raw onsets of each method.
+func findConsensusOnsets(samples []float64, sampleRate uint, options SliceAnalyzerOptions) ([]float64, []float64, []float64, map[string][]float64) {
+	// Collect all onsets from all methods, with their strength calibrated
+	// within the same method so methods are comparable
+	var allOnsets []onsetWithStrength
+	perMethod := make(map[string][]float64, len(consensusMethods))
+	activeMethods := 0
+	for _, method := range consensusMethods {
+		times, strengths := detectOnsetsWithStrength(samples, sampleRate, relaxedDetector(method, options))
+		perMethod[method] = times
+		if len(times) > 0 {
+			activeMethods++
+		}
+		calibrated := calibrateStrengths(strengths, options.ConsensusCalibration)
+		for i, onsetTime := range times {
+			allOnsets = append(allOnsets, onsetWithStrength{time: onsetTime, strength: calibrated[i]})
+		}
+	}
+
+	if len(allOnsets) == 0 {
+		return []float64{}, []float64{}, nil, perMethod
+	}
+
+	// Default minimum cluster size to 3 if not set
+	minClusterSize := options.MinConsensusClusterSize
+	if minClusterSize <= 0 {
+		minClusterSize = 3
+	}
+	minClusterSize = scaleClusterSize(minClusterSize, activeMethods, len(consensusMethods))
+
+	filter, _ := outlierFilterFor(options)
+	consensusOnsets, strengths := clusterConsensusOnsets(allOnsets, minClusterSize, options.ConsensusMinStrength, filter, options.OnReject)
+
+	// If targetSlices is specified, select the best N based on energy
+	if options.NumSlices > 0 && len(consensusOnsets) > options.NumSlices {
+		// For consensus, we could rank by cluster size (more methods agreeing)
+		// But for simplicity, we'll use energy like in findBestOnsets
+		selected, rejected := selectBestOnsets(samples, sampleRate, consensusOnsets, options.NumSlices, onsetEnergyFunc(options))
+		reportRejected(options.OnReject, rejected, "best-n")
+		return selected, alignStrengths(consensusOnsets, strengths, selected), rejected, perMethod
+	}
 
-	// All available methods
-	methods := []string{"energy", "hfc", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux"}
+	// If a percentile is specified, keep the clusters louder than it
+	if options.EnergyPercentile > 0 {
+		selected, rejected := selectOnsetsAbovePercentile(samples, sampleRate, consensusOnsets, options.EnergyPercentile, onsetEnergyFunc(options))
+		reportRejected(options.OnReject, rejected, "percentile")
+		return selected, alignStrengths(consensusOnsets, strengths, selected), rejected, perMethod
+	}
+
+	return consensusOnsets, strengths, nil, perMethod
+}
 
-	// Collect all onsets from all methods
-	var allOnsets []float64
-	for _, method := range methods {
-		methodOnsets := detectAllOnsets(samples, sampleRate, method, bufSize, hopSize)
-		allOnsets = append(allOnsets, methodOnsets...)
+// scaleClusterSize scales a minimum cluster size meant for numMethods methods
+// to the activeMethods that found any onsets, rounding up and keeping at least
+// one marker, so silent methods do not make the size impossible to reach
+func scaleClusterSize(minClusterSize, activeMethods, numMethods int) int {
+	if activeMethods >= numMethods || numMethods <= 0 {
+		return minClusterSize
+	}
+	return max((minClusterSize*activeMethods+numMethods-1)/numMethods, 1)
+}
+
+// calibrateStrengths maps the strengths of the onsets of one method to 0..1
+// with the given ConsensusCalibration: relative to the strongest ("max" or
+// empty, negative strengths count as 0) or as percentile ranks ("percentile")
+func calibrateStrengths(strengths []float64, calibration string) []float64 {
+	calibrated := make([]float64, len(strengths))
+
+	if calibration == "percentile" {
+		sorted := append([]float64(nil), strengths...)
+		sort.Float64s(sorted)
+		for i, strength := range strengths {
+			notStronger := sort.Search(len(sorted), func(k int) bool { return sorted[k] > strength })
+			calibrated[i] = float64(notStronger) / float64(len(sorted))
+		}
+		return calibrated
 	}
 
+	maxStrength := 0.0
+	for _, strength := range strengths {
+		maxStrength = math.Max(maxStrength, strength)
+	}
+	if maxStrength > 0 {
+		for i, strength := range strengths {
+			calibrated[i] = math.Max(strength, 0) / maxStrength
+		}
+	}
+	return calibrated
+}
+
+// onsetWithStrength stores an onset time and its detection strength
+type onsetWithStrength struct {
+	time     float64
+	strength float64
+}
+
+// clusterConsensusOnsets clusters nearby onsets from all methods and returns the
+// midpoint and average strength of every cluster that has at least minClusterSize
+// markers and whose average strength is at least minStrength. Outlying markers
+// found by filter are left out of the midpoint; a nil filter keeps all markers.
+// The midpoint of every dropped cluster is passed to onReject, if set.
+func clusterConsensusOnsets(allOnsets []onsetWithStrength, minClusterSize int, minStrength float64, filter outlierFilter, onReject func(timeSec float64, reason string)) ([]float64, []float64) {
 	if len(allOnsets) == 0 {
-		return []float64{}
+		return nil, nil
 	}
 
-	// Sort all onsets by time
-	sort.Float64s(allOnsets)
+	// Sort all onsets by time. The sort is stable so markers at the same time
+	// keep the fixed method order, and sums over a cluster are always taken in
+	// the same order.
+	sort.SliceStable(allOnsets, func(i, j int) bool {
+		return allOnsets[i].time < allOnsets[j].time
+	})
 
 	// Cluster nearby onsets together
 	// Two onsets are in the same cluster if they're within clusterThreshold seconds
 	clusterThreshold := 0.05 // 50ms threshold for clustering
 
-	// Default minimum cluster size to 3 if not set
-	minClusterSize := options.MinConsensusClusterSize
-	if minClusterSize <= 0 {
-		minClusterSize = 3
+	var consensusOnsets, consensusStrengths []float64
+
+	// finalize keeps a cluster if it meets the size and strength requirements
+	finalize := func(cluster []onsetWithStrength) {
+		times := make([]float64, len(cluster))
+		strengthSum := 0.0
+		for i, onset := range cluster {
+			times[i] = onset.time
+			strengthSum += onset.strength
+		}
+
+		strength := strengthSum / float64(len(cluster))
+		if len(cluster) < minClusterSize || strength < minStrength {
+			if onReject != nil {
+				onReject(calculateClusterMidpoint(times, filter), "consensus")
+			}
+			return
+		}
+
+		consensusOnsets = append(consensusOnsets, calculateClusterMidpoint(times, filter))
+		consensusStrengths = append(consensusStrengths, strength)
 	}
 
-	var consensusOnsets []float64
-	currentCluster := []float64{allOnsets[0]}
+	currentCluster := []onsetWithStrength{allOnsets[0]}
 
 	for i := 1; i < len(allOnsets); i++ {
-		if allOnsets[i]-currentCluster[len(currentCluster)-1] <= clusterThreshold {
+		if allOnsets[i].time-currentCluster[len(currentCluster)-1].time <= clusterThreshold {
 			// Add to current cluster
 			currentCluster = append(currentCluster, allOnsets[i])
 		} else {
-			// Finalize current cluster if it meets minimum size requirement
-			if len(currentCluster) >= minClusterSize {
-				consensusOnsets = append(consensusOnsets, calculateClusterMidpoint(currentCluster))
-			}
-			currentCluster = []float64{allOnsets[i]}
+			finalize(currentCluster)
+			currentCluster = []onsetWithStrength{allOnsets[i]}
 		}
 	}
 
-	// Don't forget the last cluster if it meets minimum size requirement
-	if len(currentCluster) >= minClusterSize {
-		consensusOnsets = append(consensusOnsets, calculateClusterMidpoint(currentCluster))
-	}
+	// Don't forget the last cluster
+	finalize(currentCluster)
 
-	// If targetSlices is specified, select the best N based on cluster size and energy
-	if options.NumSlices > 0 && len(consensusOnsets) > options.NumSlices {
-		// For consensus, we could rank by cluster size (more methods agreeing)
-		// But for simplicity, we'll use energy like in findBestOnsets
-		onsetsWithEnergy := make([]onsetWithEnergy, len(consensusOnsets))
-		for i, onsetTime := range consensusOnsets {
-			energy := calculateOnsetEnergy(samples, sampleRate, onsetTime)
-			onsetsWithEnergy[i] = onsetWithEnergy{
-				time:   onsetTime,
-				energy: energy,
-			}
-		}
-
-		// Sort by energy (descending)
-		sort.Slice(onsetsWithEnergy, func(i, j int) bool {
-			return onsetsWithEnergy[i].energy > onsetsWithEnergy[j].energy
-		})
+	return consensusOnsets, consensusStrengths
+}
 
-		// Take top N onsets
-		bestOnsets := onsetsWithEnergy[:options.NumSlices]
+// outlierFilter returns the values of a cluster that are not outliers
+type outlierFilter func(data []float64) []float64
 
-		// Sort back by time for output
-		sort.Slice(bestOnsets, func(i, j int) bool {
-			return bestOnsets[i].time < bestOnsets[j].time
-		})
+// outlierFilterFor returns the outlier filter selected by the options,
+// or nil if outliers are kept
+func outlierFilterFor(options SliceAnalyzerOptions) (outlierFilter, error) {
+	switch options.ConsensusPercentileMethod {
+	case "", "linear", "nearest", "lower", "higher":
+	default:
+		return nil, fmt.Errorf("unknown consensus percentile method: %q", options.ConsensusPercentileMethod)
+	}
 
-		// Extract just the times
-		result := make([]float64, len(bestOnsets))
-		for i, onset := range bestOnsets {
-			result[i] = onset.time
+	var filter outlierFilter
+	switch options.ConsensusOutlierMethod {
+	case "", "iqr":
+		method := options.ConsensusPercentileMethod
+		filter = func(data []float64) []float64 {
+			return removeOutliersWithPercentile(data, method)
 		}
-
-		return result
+	case "mad":
+		filter = removeOutliersMAD
+	default:
+		return nil, fmt.Errorf("unknown consensus outlier method: %q", options.ConsensusOutlierMethod)
 	}
 
-	return consensusOnsets
+	if options.ConsensusKeepOutliers {
+		return nil, nil
+	}
+	return filter, nil
 }
 
-// calculateClusterMidpoint calculates the midpoint of a cluster of onset times
-// after removing outliers using the IQR method
-func calculateClusterMidpoint(cluster []float64) float64 {
+// calculateClusterMidpoint calculates the midpoint of a cluster of onset times,
+// after removing outliers with filter if it is not nil
+func calculateClusterMidpoint(cluster []float64, filter outlierFilter) float64 {
 	if len(cluster) == 0 {
 		return 0.0
 	}
 
-	// For small clusters (< 4), don't remove outliers
-	if len(cluster) < 4 {
-		sum := 0.0
-		for _, time := range cluster {
-			sum += time
-		}
-		return sum / float64(len(cluster))
+	cleanedCluster := cluster
+	if filter != nil {
+		cleanedCluster = filter(cluster)
 	}
 
-	// Remove outliers using IQR method
-	cleanedCluster := removeOutliers(cluster)
-
 	// If all values were outliers (shouldn't happen), use original cluster
 	if len(cleanedCluster) == 0 {
 		cleanedCluster = cluster
@@ -339,6 +1496,12 @@ func calculateClusterMidpoint(cluster []float64) float64 {
 
 // removeOutliers removes outliers from a cluster using the IQR (Interquartile Range) method
 func removeOutliers(data []float64) []float64 {
+	return removeOutliersWithPercentile(data, "linear")
+}
+
+// removeOutliersWithPercentile removes outliers from a cluster using the IQR
+// method, with the quartiles taken by the given percentile method
+func removeOutliersWithPercentile(data []float64, method string) []float64 {
 	if len(data) < 4 {
 		return data
 	}
@@ -349,8 +1512,8 @@ func removeOutliers(data []float64) []float64 {
 	sort.Float64s(sorted)
 
 	// Calculate Q1, Q2 (median), and Q3
-	q1 := calculatePercentile(sorted, 25)
-	q3 := calculatePercentile(sorted, 75)
+	q1 := calculatePercentileMethod(sorted, 25, method)
+	q3 := calculatePercentileMethod(sorted, 75, method)
 
 	// Calculate IQR
 	iqr := q3 - q1
@@ -370,8 +1533,56 @@ func removeOutliers(data []float64) []float64 {
 	return result
 }
 
+// madOutlierScore is the modified z-score above which a value is an outlier
+// (Iglewicz and Hoaglin)
+const madOutlierScore = 3.5
+
+// removeOutliersMAD removes outliers from a cluster using the median absolute
+// deviation (MAD). Values whose modified z-score 0.6745*|x-median|/MAD exceeds
+// 3.5 are removed. Unlike the IQR, the MAD is not skewed by a single outlier in
+// clusters as small as 3 values.
+func removeOutliersMAD(data []float64) []float64 {
+	if len(data) < 3 {
+		return data
+	}
+
+	sorted := make([]float64, len(data))
+	copy(sorted, data)
+	sort.Float64s(sorted)
+	median := calculatePercentile(sorted, 50)
+
+	deviations := make([]float64, len(data))
+	for i, value := range data {
+		deviations[i] = math.Abs(value - median)
+	}
+	sort.Float64s(deviations)
+	mad := calculatePercentile(deviations, 50)
+
+	// More than half of the values are identical, nothing to compare against
+	if mad == 0 {
+		return data
+	}
+
+	var result []float64
+	for _, value := range data {
+		if 0.6745*math.Abs(value-median)/mad <= madOutlierScore {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
 // calculatePercentile calculates the nth percentile of a sorted array
 func calculatePercentile(sorted []float64, percentile float64) float64 {
+	return calculatePercentileMethod(sorted, percentile, "linear")
+}
+
+// calculatePercentileMethod calculates the nth percentile of a sorted array.
+// Between two values, method "linear" (or "") interpolates, and "nearest",
+// "lower" and "higher" take the nearest (ties to the even index), lower or
+// higher value, as numpy's percentile does.
+func calculatePercentileMethod(sorted []float64, percentile float64, method string) float64 {
 	if len(sorted) == 0 {
 		return 0.0
 	}
@@ -380,8 +1591,10 @@ func calculatePercentile(sorted []float64, percentile float64) float64 {
 		return sorted[0]
 	}
 
-	// Calculate the rank
+	// Calculate the rank, clamped so out-of-range percentiles give the
+	// minimum or maximum
 	rank := (percentile / 100.0) * float64(len(sorted)-1)
+	rank = math.Max(0, math.Min(float64(len(sorted)-1), rank))
 	lowerIndex := int(math.Floor(rank))
 	upperIndex := int(math.Ceil(rank))
 
@@ -393,22 +1606,156 @@ func calculatePercentile(sorted []float64, percentile float64) float64 {
 		upperIndex = len(sorted) - 1
 	}
 
-	// Linear interpolation between the two nearest ranks
 	if lowerIndex == upperIndex {
 		return sorted[lowerIndex]
 	}
 
+	switch method {
+	case "nearest":
+		return sorted[int(math.RoundToEven(rank))]
+	case "lower":
+		return sorted[lowerIndex]
+	case "higher":
+		return sorted[upperIndex]
+	}
+
+	// Linear interpolation between the two nearest ranks
+
 	weight := rank - float64(lowerIndex)
 	return sorted[lowerIndex]*(1-weight) + sorted[upperIndex]*weight
 }
 
-// detectAllOnsets detects all onsets with relaxed parameters
-func detectAllOnsets(samples []float64, sampleRate uint, method string, bufSize, hopSize uint) []float64 {
-	// Use low threshold and short minioi to detect all possible onsets
-	threshold := 0.02
-	minioi := 10.0 // milliseconds
+// Relaxed detection parameters used to detect all possible onsets
+const (
+	relaxedThreshold = 0.02
+	relaxedMinioiMs  = 10.0
+)
 
-	return detectOnsetsInternal(samples, sampleRate, method, bufSize, hopSize, threshold, minioi)
+// defaultHopMs is the target duration of a detection hop, which is 256 samples at 44.1 kHz
+const defaultHopMs = 256 * 1000.0 / 44100.0
+
+// detectorConfig contains the parameters of an onset detector
+type detectorConfig struct {
+	method string
+	// windowMs and hopMs are the analysis window and hop durations (0 = default)
+	windowMs  float64
+	hopMs     float64
+	threshold float64
+	minioiMs  float64
+	// medianWindow is the peak picker's adaptive median window in frames (0 = default)
+	medianWindow int
+	// delta is the multiplier of the adaptive median (0 = default)
+	delta float64
+	// subsampleRefine interpolates onset times between frames from the raw novelty
+	subsampleRefine bool
+	// padStartMs is the silence prepended before detection in milliseconds
+	padStartMs float64
+	// weighting sets the spectral bin weights of the descriptor (nil = default)
+	weighting func(bin, numBins uint) float64
+	// lookaheadMs holds peaks back for stronger ones that follow (0 = none)
+	lookaheadMs float64
+	// melBands reduces the spectrum to mel bands (0 = linear spectrum)
+	melBands int
+	// compression is the log compression constant of the magnitudes (0 = the method's)
+	compression float64
+	// whitenWarmup primes the adaptive whitening with the samples before detection
+	whitenWarmup bool
+	// difference detects on the first difference of the samples
+	difference bool
+	// onReject is called for every candidate dropped by the detector
+	onReject func(timeSec float64, reason string)
+	// silenceOpenDb and silenceCloseDb are the silence gate levels (0 = default)
+	silenceOpenDb  float64
+	silenceCloseDb float64
+	// smoothFrames is the moving average length of the novelty (0 = none)
+	smoothFrames int
+	// window is the analysis window ("" = hann)
+	window string
+	// preEchoGuardMs merges onsets into a much louder one that follows (0 = off)
+	preEchoGuardMs float64
+	// minProminence drops onsets whose novelty peak is less prominent (0 = off)
+	minProminence float64
+	// clipAware drops the onsets inside clipped runs
+	clipAware bool
+	// right is the right channel for the "stereowidth" method
+	right []float64
+	// autoGainTimeMs is the time constant of the automatic gain control
+	// (0 = off) and autoGainTargetDb its target level (0 = default)
+	autoGainTimeMs   float64
+	autoGainTargetDb float64
+}
+
+// relaxedDetector returns the detector used to detect all possible onsets,
+// with a low threshold and short minioi (or those derived from Sensitivity or
+// DetectThreshold), and the adaptive threshold settings of the options
+func relaxedDetector(method string, options SliceAnalyzerOptions) detectorConfig {
+	config := detectorConfig{
+		method:           method,
+		windowMs:         options.WindowMs,
+		hopMs:            options.HopMs,
+		threshold:        relaxedThreshold,
+		minioiMs:         relaxedMinioiMs,
+		medianWindow:     options.AdaptiveMedianWindow,
+		delta:            options.AdaptiveDelta,
+		subsampleRefine:  options.SubsampleRefine,
+		padStartMs:       options.PadStartMs,
+		weighting:        options.BinWeighting,
+		lookaheadMs:      options.LookaheadMs,
+		melBands:         options.MelBands,
+		compression:      options.LogCompression,
+		whitenWarmup:     options.WhitenWarmup,
+		difference:       options.UseDifference,
+		onReject:         options.OnReject,
+		silenceOpenDb:    options.SilenceOpenDb,
+		silenceCloseDb:   options.SilenceCloseDb,
+		smoothFrames:     options.NoveltySmoothFrames,
+		window:           options.Window,
+		preEchoGuardMs:   options.PreEchoGuardMs,
+		minProminence:    options.MinProminence,
+		clipAware:        options.ClipAware,
+		right:            options.rightChannel,
+		autoGainTimeMs:   options.AutoGainTimeMs,
+		autoGainTargetDb: options.AutoGainTargetDb,
+	}
+	if options.Sensitivity > 0 {
+		config.threshold, config.minioiMs, _ = sensitivityParameters(options.Sensitivity)
+	}
+	if options.DetectThreshold > 0 {
+		config.threshold = options.DetectThreshold
+	}
+	return config
+}
+
+// HopSize returns the step in samples between the detection frames the
+// options use at the given sample rate, from HopMs and WindowMs or their
+// defaults, e.g. to compute a novelty curve on the same frames (see
+// NoveltyCurve).
+func (options SliceAnalyzerOptions) HopSize(sampleRate uint) uint {
+	config := detectorConfig{windowMs: options.WindowMs, hopMs: options.HopMs}
+	_, hopSize := config.sizes(sampleRate)
+	return hopSize
+}
+
+// sizes returns the window and hop sizes in samples of the detector at the
+// given sample rate
+func (c detectorConfig) sizes(sampleRate uint) (bufSize, hopSize uint) {
+	samplesPerMs := float64(sampleRate) / 1000.0
+
+	hop := c.hopMs
+	if hop <= 0 && c.windowMs > 0 {
+		hop = c.windowMs / 2
+	}
+	if hop > 0 {
+		hopSize = uint(max(math.Round(hop*samplesPerMs), 1))
+	} else {
+		hopSize = 1 << uint(max(math.Round(math.Log2(defaultHopMs*samplesPerMs)), 0))
+	}
+
+	bufSize = 2 * hopSize
+	if c.windowMs > 0 {
+		bufSize = uint(max(math.Round(c.windowMs*samplesPerMs), 1))
+	}
+	return bufSize, hopSize
 }
 
 // calculateOnsetEnergy calculates the RMS energy around an onset
@@ -446,13 +1793,25 @@ func calculateOnsetEnergy(samples []float64, sampleRate uint, onsetTime float64)
 	return math.Sqrt(sumSquares / float64(count))
 }
 
+// energyFloorDb is the level reported for silence in dBFS
+const energyFloorDb = -120.0
+
+// rmsToDb converts a linear RMS level to dBFS, with silence at energyFloorDb
+func rmsToDb(rms float64) float64 {
+	if rms <= 0 {
+		return energyFloorDb
+	}
+	return math.Max(20*math.Log10(rms), energyFloorDb)
+}
+
 // optimizeOnsetPositions refines onset positions by finding the point of maximum variance difference
-// within a window around each detected onset
-func optimizeOnsetPositions(samples []float64, sampleRate uint, onsets []float64, windowMs float64) []float64 {
+// within a window around each detected onset, never inside the clipped runs
+// marked in clipped (nil = none)
+func optimizeOnsetPositions(samples []float64, sampleRate uint, onsets []float64, windowMs float64, clipped []bool) []float64 {
 	optimized := make([]float64, len(onsets))
 
 	for i, onsetTime := range onsets {
-		optimized[i] = findOptimalOnsetPosition(samples, sampleRate, onsetTime, windowMs)
+		optimized[i] = findOptimalOnsetPosition(samples, sampleRate, onsetTime, windowMs, clipped)
 	}
 
 	return optimized
@@ -460,9 +1819,10 @@ func optimizeOnsetPositions(samples []float64, sampleRate uint, onsets []float64
 
 // applyMinimumSpacing filters onsets to ensure minimum spacing between them.
 // If multiple onsets fall within the minimum spacing window, only the first is kept.
-func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) []float64 {
+// It returns the kept onsets and the dropped ones.
+func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) ([]float64, []float64) {
 	if len(onsets) == 0 {
-		return onsets
+		return onsets, nil
 	}
 
 	// Convert minimum spacing from milliseconds to seconds
@@ -470,6 +1830,7 @@ func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) []float64 {
 
 	// First onset is always kept
 	filtered := []float64{onsets[0]}
+	var dropped []float64
 
 	// Check each subsequent onset
 	for i := 1; i < len(onsets); i++ {
@@ -479,16 +1840,60 @@ func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) []float64 {
 		// Only keep this onset if it's far enough from the previous one
 		if timeDiff >= minimumSpacingSec {
 			filtered = append(filtered, onsets[i])
+		} else {
+			// Otherwise, skip this onset (it's too close to the previous one)
+			dropped = append(dropped, onsets[i])
 		}
-		// Otherwise, skip this onset (it's too close to the previous one)
 	}
 
-	return filtered
+	return filtered, dropped
+}
+
+// minimumSpacingMs returns the minimum spacing in milliseconds, converting
+// from grid units when a spacing tempo is set
+func minimumSpacingMs(options SliceAnalyzerOptions) float64 {
+	if options.SpacingBPM <= 0 {
+		return options.MinimumSpacing
+	}
+
+	division := options.SpacingDivision
+	if division <= 0 {
+		division = 16
+	}
+
+	return options.MinimumSpacing * gridInterval(options.SpacingBPM, division) * 1000.0
+}
+
+const (
+	// autoSpacingDivision is the grid subdivision, in notes per bar, of the
+	// spacing chosen by AutoSpacing
+	autoSpacingDivision = 32
+	// minAutoSpacingOnsets and minAutoSpacingConfidence are the number of
+	// onsets and the fraction of agreeing intervals needed to trust the tempo
+	// for AutoSpacing. Random onsets rarely reach half agreement.
+	minAutoSpacingOnsets     = 8
+	minAutoSpacingConfidence = 0.5
+)
+
+// onsetSpacingMs returns the minimum spacing in milliseconds to apply to the
+// onsets: a 32nd note at their tempo with AutoSpacing when the tempo estimate
+// is confident, and the configured spacing otherwise
+func onsetSpacingMs(onsets []float64, options SliceAnalyzerOptions) float64 {
+	if options.AutoSpacing && len(onsets) >= minAutoSpacingOnsets {
+		bpm, confidence := estimateBPMWithConfidence(onsets)
+		if bpm > 0 && confidence >= minAutoSpacingConfidence {
+			return gridInterval(bpm, autoSpacingDivision) * 1000.0
+		}
+	}
+	return minimumSpacingMs(options)
 }
 
 // findOptimalOnsetPosition finds the exact onset position by locating the midpoint
-// with the maximum variance difference between right and left sides within a window
-func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime float64, windowMs float64) float64 {
+// with the maximum variance difference between right and left sides within a window.
+// Midpoints following a sample marked in clipped (nil = none) are skipped: the
+// flat top of a railed waveform has no variance, so the difference peaks
+// inside it rather than at the attack.
+func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime float64, windowMs float64, clipped []bool) float64 {
 	// Convert onset time to sample index
 	onsetSample := int(onsetTime * float64(sampleRate))
 
@@ -496,18 +1901,15 @@ func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime floa
 	windowSamples := int(windowMs * float64(sampleRate) / 1000.0)
 	halfWindow := windowSamples / 2
 
+	// Shrink the window to fit in the samples while keeping it centered, so
+	// near the start or end of the file (or in a short one-shot) the search
+	// does not lean towards the middle of the sound
+	halfWindow = max(min(halfWindow, onsetSample, len(samples)-onsetSample), 0)
+
 	// Define search window boundaries
 	windowStart := onsetSample - halfWindow
 	windowEnd := onsetSample + halfWindow
 
-	// Clamp to valid range
-	if windowStart < 0 {
-		windowStart = 0
-	}
-	if windowEnd > len(samples) {
-		windowEnd = len(samples)
-	}
-
 	// If window is too small, return original onset
 	if windowEnd-windowStart < 10 {
 		return onsetTime
@@ -521,6 +1923,10 @@ func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime floa
 	// Leave some margin on both sides to calculate variance
 	minMargin := 5 // minimum samples on each side
 	for midpoint := windowStart + minMargin; midpoint < windowEnd-minMargin; midpoint++ {
+		if clipped != nil && clipped[midpoint-1] {
+			continue
+		}
+
 		// Calculate variance of left side (from window start to midpoint)
 		leftVariance := calculateVariance(samples, windowStart, midpoint)
 
@@ -571,36 +1977,366 @@ func calculateVariance(samples []float64, start, end int) float64 {
 }
 
 // detectOnsetsInternal processes audio samples and returns onset times in seconds
-func detectOnsetsInternal(samples []float64, sampleRate uint, method string, bufSize, hopSize uint, threshold float64, minioi float64) []float64 {
-	o := NewOnset(method, bufSize, hopSize, sampleRate)
-	o.SetThreshold(threshold)
-	o.SetMinioiMs(minioi)
+func detectOnsetsInternal(samples []float64, sampleRate uint, config detectorConfig) []float64 {
+	onsets, _ := detectOnsetsWithStrength(samples, sampleRate, config)
+	return onsets
+}
 
-	input := NewFvec(hopSize)
-	output := NewFvec(1)
+// detectOnsetsWithStrength processes audio samples and returns onset times in seconds
+// along with the detection strength (peak novelty value) of each onset
+func detectOnsetsWithStrength(samples []float64, sampleRate uint, config detectorConfig) ([]float64, []float64) {
+	var onsets, strengths []float64
+	if config.method == "stereowidth" {
+		onsets, strengths = detectStereoWidth(samples, config.right, sampleRate, config)
+	} else {
+		d := newStreamingDetector(sampleRate, config)
+		if config.whitenWarmup {
+			if config.difference {
+				d.o.PrimeWhitening(firstDifference(samples))
+			} else {
+				d.o.PrimeWhitening(samples)
+			}
+		}
+		d.write(samples)
+		d.flush()
+		onsets, strengths = d.onsets, d.strengths
+		if config.minProminence > 0 {
+			var dropped []float64
+			onsets, strengths, dropped = d.dropShallowPeaks(config.minProminence)
+			reportRejected(config.onReject, dropped, "prominence")
+		}
+	}
 
-	var onsets []float64
+	if config.clipAware {
+		var dropped []float64
+		onsets, strengths, dropped = dropClippedOnsets(clippedSamples(samples), sampleRate, onsets, strengths)
+		reportRejected(config.onReject, dropped, "clipped")
+	}
+	if config.preEchoGuardMs > 0 {
+		var dropped []float64
+		onsets, strengths, dropped = guardPreEcho(samples, sampleRate, onsets, strengths, config.preEchoGuardMs)
+		reportRejected(config.onReject, dropped, "pre-echo")
+	}
+	return onsets, strengths
+}
 
-	// Process audio in chunks
-	for pos := uint(0); pos+hopSize < uint(len(samples)); pos += hopSize {
-		// Fill input buffer
-		for i := uint(0); i < hopSize; i++ {
-			if pos+i < uint(len(samples)) {
-				input.Data[i] = samples[pos+i]
-			} else {
-				input.Data[i] = 0
+// segmentRMS returns the RMS level of the samples from start to end, clamped
+// to the samples, or 0 when the range is empty
+func segmentRMS(samples []float64, start, end int) float64 {
+	start, end = max(start, 0), min(end, len(samples))
+	if start >= end {
+		return 0
+	}
+	sumSquares := 0.0
+	for _, v := range samples[start:end] {
+		sumSquares += v * v
+	}
+	return math.Sqrt(sumSquares / float64(end-start))
+}
+
+// preEchoRatioDb is how much louder than a pre-echo the hit that follows it
+// must be for the pre-echo to be dropped
+const preEchoRatioDb = 6.0
+
+// guardPreEcho drops every onset followed within guardMs by an onset whose
+// level is at least preEchoRatioDb above it, keeping the louder hit. The level
+// of an onset is the RMS of the samples up to the next onset, so it does not
+// include the hit, and that of the hit is the RMS of the 50ms following it.
+// The onsets must be in time order. It returns the kept onsets and their
+// strengths and the dropped onsets.
+func guardPreEcho(samples []float64, sampleRate uint, onsets, strengths []float64, guardMs float64) ([]float64, []float64, []float64) {
+	guardSec := guardMs / 1000.0
+	ratio := math.Pow(10, preEchoRatioDb/20)
+
+	kept := make([]float64, 0, len(onsets))
+	keptStrengths := make([]float64, 0, len(strengths))
+	var dropped []float64
+	for i, onsetTime := range onsets {
+		if i+1 < len(onsets) && onsets[i+1]-onsetTime <= guardSec {
+			start := int(onsetTime * float64(sampleRate))
+			end := int(onsets[i+1] * float64(sampleRate))
+			preEcho := segmentRMS(samples, start, end)
+			if calculateOnsetEnergy(samples, sampleRate, onsets[i+1]) >= ratio*preEcho {
+				dropped = append(dropped, onsetTime)
+				continue
+			}
+		}
+		kept = append(kept, onsetTime)
+		if i < len(strengths) {
+			keptStrengths = append(keptStrengths, strengths[i])
+		}
+	}
+
+	return kept, keptStrengths, dropped
+}
+
+// defaultAutoGainTargetDb is the RMS level in dBFS that the automatic gain
+// control aims for when AutoGainTargetDb is not set
+const defaultAutoGainTargetDb = -20.0
+
+// maxAutoGainDb is the largest gain in dB the automatic gain control
+// applies, so silence and faint noise are not raised to the target level
+const maxAutoGainDb = 40.0
+
+// applyAutoGain updates the tracked power with the hop and scales the hop by
+// the gain that brings the tracked level to the target
+func (d *streamingDetector) applyAutoGain(hop []float64) {
+	power := 0.0
+	for _, v := range hop {
+		power += v * v
+	}
+	power /= float64(len(hop))
+	if d.gainPower < 0 {
+		// Start from the level of the first hop
+		d.gainPower = power
+	} else {
+		d.gainPower = d.gainCoeff*d.gainPower + (1-d.gainCoeff)*power
+	}
+
+	gain := math.Pow(10, maxAutoGainDb/20)
+	if d.gainPower > 0 {
+		gain = math.Min(gain, d.gainTarget/math.Sqrt(d.gainPower))
+	}
+	for i := range hop {
+		hop[i] *= gain
+	}
+}
+
+// streamingDetector feeds blocks of any size to an onset detector one hop at a
+// time and collects the detected onset times in seconds
+type streamingDetector struct {
+	o         *Onset
+	input     *Fvec
+	output    *Fvec
+	pending   []float64
+	onsets    []float64
+	strengths []float64
+	// subsample enables parabolic refinement of the onset times
+	subsample bool
+	// difference detects on the first difference of the samples, and
+	// previous is the last sample processed
+	difference bool
+	previous   float64
+	// autoGain scales each hop toward an RMS level of gainTarget, tracked
+	// with a one-pole smoothing of the hop power with coefficient gainCoeff
+	// (gainPower is negative until the first hop)
+	autoGain   bool
+	gainTarget float64
+	gainCoeff  float64
+	gainPower  float64
+	// novelty holds the raw novelty of the last four frames, oldest first
+	novelty [4]float64
+	// curve holds the novelty the peak picker sees of every frame when
+	// keepCurve is set
+	keepCurve bool
+	curve     []float64
+	// written is the number of samples written so far, without the padding
+	written int
+	// offset is the duration of the padding written before the samples in seconds
+	offset float64
+}
+
+// newStreamingDetector creates a streaming detector with the given parameters
+func newStreamingDetector(sampleRate uint, config detectorConfig) *streamingDetector {
+	bufSize, hopSize := config.sizes(sampleRate)
+	o := NewOnset(config.method, bufSize, hopSize, sampleRate)
+	o.SetThreshold(config.threshold)
+	o.SetMinioiMs(config.minioiMs)
+	if config.medianWindow > 0 {
+		o.Pp.SetMedianWindow(uint(config.medianWindow))
+	}
+	if config.delta > 0 {
+		o.Pp.SetDelta(config.delta)
+	}
+	if config.melBands > 0 {
+		o.SetMelBands(uint(config.melBands))
+	}
+	if config.compression > 0 {
+		o.SetCompression(config.compression)
+	}
+	if config.weighting != nil {
+		o.Od.SetWeighting(config.weighting)
+	}
+	o.SetLookaheadMs(config.lookaheadMs)
+	if config.window != "" {
+		o.SetWindow(config.window)
+	}
+	if config.smoothFrames > 1 {
+		o.SetNoveltySmoothing(uint(config.smoothFrames))
+	}
+	if config.silenceOpenDb != 0 {
+		closeDb := config.silenceCloseDb
+		if closeDb == 0 {
+			closeDb = config.silenceOpenDb
+		}
+		o.SetSilenceHysteresis(config.silenceOpenDb, closeDb)
+	}
+
+	d := &streamingDetector{
+		o:          o,
+		input:      NewFvec(hopSize),
+		output:     NewFvec(1),
+		subsample:  config.subsampleRefine && config.lookaheadMs <= 0 && config.smoothFrames <= 1,
+		difference: config.difference,
+		keepCurve:  config.minProminence > 0,
+	}
+	if config.autoGainTimeMs > 0 {
+		targetDb := config.autoGainTargetDb
+		if targetDb == 0 {
+			targetDb = defaultAutoGainTargetDb
+		}
+		hopSec := float64(hopSize) / float64(sampleRate)
+		d.autoGain = true
+		d.gainTarget = math.Pow(10, targetDb/20)
+		d.gainCoeff = math.Exp(-hopSec * 1000 / config.autoGainTimeMs)
+		d.gainPower = -1
+	}
+
+	if config.padStartMs > 0 {
+		pad := int(config.padStartMs * float64(sampleRate) / 1000.0)
+		d.write(make([]float64, pad))
+		d.written = 0
+		d.offset = float64(pad) / float64(sampleRate)
+	}
+
+	// Report dropped candidates at their delay-compensated time, like onsets,
+	// skipping any in the padding after the end
+	if config.onReject != nil {
+		o.onReject = func(position uint, reason string) {
+			timeSec := math.Max(0, float64(position-min(position, o.Delay))/float64(sampleRate)-d.offset)
+			if timeSec <= float64(d.written)/float64(sampleRate) {
+				config.onReject(timeSec, reason)
 			}
 		}
+	}
+
+	return d
+}
 
-		// Process
-		o.Do(input, output)
+// onsetTime returns the time in seconds of the onset just detected. With
+// subsample refinement, the peak position is taken from a parabola through the
+// raw novelty of the three frames around the peak instead of the peak picker's
+// smoothed and thresholded values, and the time is not rounded to a sample.
+func (d *streamingDetector) onsetTime() float64 {
+	o := d.o
+	hopSize := float64(o.HopSize)
+	frameStart := float64(o.TotalFrames) - hopSize
+
+	// Onsets at the start of the file are not peaks and are not refined
+	if !d.subsample || frameStart <= float64(o.Delay) {
+		return o.GetLastS()
+	}
+
+	// The peak picker looks at its peak one frame behind its newest value,
+	// which lags the current frame by WinPre frames
+	lag := int(o.Pp.WinPre) + 1
+	if lag+1 > len(d.novelty)-1 {
+		return o.GetLastS()
+	}
+	peak := len(d.novelty) - 1 - lag
+	offset := parabolicPeakOffset(d.novelty[peak-1], d.novelty[peak], d.novelty[peak+1])
 
-		// Check for onset
-		if output.Data[0] > 0 {
-			onsetTime := o.GetLastS()
-			onsets = append(onsets, onsetTime)
+	// Same position as the peak picker reports (one frame into its window)
+	// with the refined offset, minus the detection delay
+	position := frameStart + (1+offset)*hopSize - float64(o.Delay)
+	return math.Max(0, position) / float64(o.Samplerate)
+}
+
+// parabolicPeakOffset returns the position, relative to the middle value and
+// between -0.5 and 0.5, of the vertex of the parabola through three equally
+// spaced values. It returns 0 when the values do not form a peak.
+func parabolicPeakOffset(x0, x1, x2 float64) float64 {
+	denominator := x0 - 2*x1 + x2
+	if denominator >= 0 {
+		return 0
+	}
+	return math.Max(-0.5, math.Min(0.5, 0.5*(x0-x2)/denominator))
+}
+
+// write processes every complete hop that is followed by at least one more sample.
+// The remainder is kept until the next write or flush.
+func (d *streamingDetector) write(samples []float64) {
+	d.written += len(samples)
+	data := samples
+	if len(d.pending) > 0 {
+		d.pending = append(d.pending, samples...)
+		data = d.pending
+	}
+
+	hopSize := int(d.o.HopSize)
+	pos := 0
+
+	// Process audio in chunks
+	for ; pos+hopSize < len(data); pos += hopSize {
+		d.process(data[pos : pos+hopSize])
+	}
+
+	d.pending = append(d.pending[:0], data[pos:]...)
+}
+
+// flush processes the remaining samples zero-padded to a full hop, followed by
+// enough zero hops for the peak picker to report a peak in the last frames, so
+// onsets at the very end are detected. Onsets detected past the end of the
+// samples are dropped. The detector must not be written to after a flush.
+func (d *streamingDetector) flush() {
+	if d.written == 0 {
+		return
+	}
+
+	hopSize := int(d.o.HopSize)
+	hop := make([]float64, hopSize)
+	copy(hop, d.pending)
+	d.pending = d.pending[:0]
+	d.process(hop)
+
+	// The peak picker reports a peak WinPre+1 frames later, and the lookahead
+	// holds it back a few more, while the silence gate would reject it for
+	// the padding, so the gate is off while padding
+	clear(hop)
+	silence := d.o.Silence
+	d.o.Silence = math.Inf(-1)
+	lookaheadHops := int((d.o.Lookahead + d.o.HopSize - 1) / d.o.HopSize)
+	for i := 0; i < int(d.o.Pp.WinPre)+1+lookaheadHops; i++ {
+		d.process(hop)
+	}
+	d.o.Silence = silence
+
+	end := float64(d.written) / float64(d.o.Samplerate)
+	for len(d.onsets) > 0 && d.onsets[len(d.onsets)-1] >= end {
+		d.onsets = d.onsets[:len(d.onsets)-1]
+		d.strengths = d.strengths[:len(d.strengths)-1]
+	}
+}
+
+// process runs the detector on one hop of samples
+func (d *streamingDetector) process(hop []float64) {
+	// Fill input buffer
+	copy(d.input.Data, hop)
+	if d.difference {
+		for i, v := range hop {
+			d.input.Data[i] = v - d.previous
+			d.previous = v
 		}
 	}
+	if d.autoGain {
+		d.applyAutoGain(d.input.Data)
+	}
 
-	return onsets
+	// Process
+	d.o.Do(d.input, d.output)
+	copy(d.novelty[:], d.novelty[1:])
+	d.novelty[3] = d.o.Desc.Data[0]
+	if d.keepCurve {
+		if d.o.SmoothFrames > 1 {
+			d.curve = append(d.curve, d.o.Smoothed.Data[0])
+		} else {
+			d.curve = append(d.curve, d.o.Desc.Data[0])
+		}
+	}
+
+	// Check for onset
+	if d.output.Data[0] > 0 {
+		d.onsets = append(d.onsets, math.Max(0, d.onsetTime()-d.offset))
+		d.strengths = append(d.strengths, d.o.GetLastStrength())
+	}
 }
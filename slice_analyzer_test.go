@@ -1,7 +1,13 @@
 package onset
 
 import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestAnalyzeSlices(t *testing.T) {
@@ -180,7 +186,7 @@ func TestSliceAnalyzerResult(t *testing.T) {
 func TestAnalyzeSlicesWithDifferentMethods(t *testing.T) {
 	wavFile := "amen.wav"
 
-	methods := []string{"energy", "hfc", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux"}
+	methods := []string{"energy", "hfc", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux", "complexity"}
 
 	for _, method := range methods {
 		t.Run("Method_"+method, func(t *testing.T) {
@@ -455,3 +461,2302 @@ func TestMinimumSpacing(t *testing.T) {
 		}
 	})
 }
+
+func TestDropSamples(t *testing.T) {
+	wavFile := "amen.wav"
+
+	// With DropSamples and no stage needing samples, onsets are
+	// detected while decoding and must match the in-memory analysis
+	streamedOptions := SliceAnalyzerOptions{
+		Method:            "hfc",
+		UseMinimumSpacing: true,
+		MinimumSpacing:    80.0,
+		DropSamples:       true,
+	}
+
+	streamed, err := AnalyzeSlices(wavFile, streamedOptions)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if streamed.Samples != nil {
+		t.Errorf("Expected no samples with DropSamples, got %d", len(streamed.Samples))
+	}
+
+	if streamed.SampleRate != 44100 {
+		t.Errorf("Expected sample rate 44100, got %d", streamed.SampleRate)
+	}
+
+	keptOptions := streamedOptions
+	keptOptions.DropSamples = false
+
+	kept, err := AnalyzeSlices(wavFile, keptOptions)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(kept.Samples) == 0 {
+		t.Error("Expected samples without DropSamples, got empty array")
+	}
+
+	if len(streamed.Onsets) != len(kept.Onsets) {
+		t.Fatalf("Expected %d streamed onsets, got %d", len(kept.Onsets), len(streamed.Onsets))
+	}
+
+	for i := range kept.Onsets {
+		if streamed.Onsets[i] != kept.Onsets[i] {
+			t.Errorf("Onset %d differs: streamed %f, in-memory %f", i, streamed.Onsets[i], kept.Onsets[i])
+		}
+	}
+}
+
+func TestRejectedOnsets(t *testing.T) {
+	wavFile := "amen.wav"
+
+	// The full detected set, without any filtering
+	all, err := AnalyzeSlices(wavFile, SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(all.RejectedOnsets) != 0 {
+		t.Errorf("Expected no rejected onsets without filtering, got %d", len(all.RejectedOnsets))
+	}
+
+	options := SliceAnalyzerOptions{
+		Method:            "hfc",
+		NumSlices:         8,
+		UseMinimumSpacing: true,
+		MinimumSpacing:    200.0,
+	}
+
+	result, err := AnalyzeSlices(wavFile, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(result.RejectedOnsets) == 0 {
+		t.Fatal("Expected rejected onsets with best-N and spacing, got none")
+	}
+
+	// Rejected and kept onsets together must be exactly the detected set
+	union := append(append([]float64{}, result.Onsets...), result.RejectedOnsets...)
+	sort.Float64s(union)
+
+	if len(union) != len(all.Onsets) {
+		t.Fatalf("Expected %d onsets in union, got %d", len(all.Onsets), len(union))
+	}
+
+	for i := range union {
+		if union[i] != all.Onsets[i] {
+			t.Errorf("Onset %d differs: union %f, detected %f", i, union[i], all.Onsets[i])
+		}
+	}
+
+	// Rejected onsets are reported in chronological order
+	for i := 1; i < len(result.RejectedOnsets); i++ {
+		if result.RejectedOnsets[i] < result.RejectedOnsets[i-1] {
+			t.Errorf("Rejected onsets not in chronological order at index %d", i)
+		}
+	}
+
+	// With Optimize, the onsets dropped before it (best-N) and after it
+	// (spacing) are all reported at their detected times
+	options.Optimize = true
+	options.OptimizeWindowMs = 100
+	options.NumSlices = 12
+	optimized, err := AnalyzeSlices(wavFile, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	detected := make(map[float64]bool, len(all.Onsets))
+	for _, onsetTime := range all.Onsets {
+		detected[onsetTime] = true
+	}
+	if len(optimized.RejectedOnsets) <= len(all.Onsets)-12 {
+		t.Errorf("Expected onsets rejected by the spacing too, got %d rejected", len(optimized.RejectedOnsets))
+	}
+	for _, onsetTime := range optimized.RejectedOnsets {
+		if !detected[onsetTime] {
+			t.Errorf("Rejected onset %f is not a detected time", onsetTime)
+		}
+	}
+
+	// Three growing hits 30ms apart that Optimize moves to the same sample:
+	// the two dropped by the spacing are each reported at their own time
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 1, []float64{0.2}, 0.05)
+	for k, amplitude := range []float64{0.2, 0.9} {
+		hit := clickTrack(sampleRate, 1, []float64{0.23 + 0.03*float64(k)}, amplitude)
+		for i := range samples {
+			samples[i] += hit[i]
+		}
+	}
+	snapOptions := SliceAnalyzerOptions{Method: "hfc", Optimize: true, OptimizeWindowMs: 200}
+	snapped, err := AnalyzeSamples(samples, sampleRate, snapOptions)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(snapped.Onsets) != 3 || snapped.Onsets[0] != snapped.Onsets[2] {
+		t.Fatalf("Expected three onsets optimized to the same sample, got %v", snapped.Onsets)
+	}
+	unoptimized, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	snapOptions.UseMinimumSpacing = true
+	snapOptions.MinimumSpacing = 80
+	spaced, err := AnalyzeSamples(samples, sampleRate, snapOptions)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if !reflect.DeepEqual(spaced.RejectedOnsets, unoptimized.Onsets[1:]) {
+		t.Errorf("Expected rejected onsets at the detected times %v, got %v", unoptimized.Onsets[1:], spaced.RejectedOnsets)
+	}
+}
+
+func TestNumDetected(t *testing.T) {
+	all, err := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if all.NumDetected != len(all.Onsets) {
+		t.Errorf("Expected %d detected without filtering, got %d", len(all.Onsets), all.NumDetected)
+	}
+
+	for name, options := range map[string]SliceAnalyzerOptions{
+		"best-n":    {Method: "hfc", NumSlices: 8},
+		"spacing":   {Method: "hfc", UseMinimumSpacing: true, MinimumSpacing: 200},
+		"streaming": {Method: "hfc", UseMinimumSpacing: true, MinimumSpacing: 200, DropSamples: true},
+		"thinning":  {Method: "hfc", KeepStrongest: 4},
+		"consensus": {Method: "consensus", MinConsensusClusterSize: 3, NumSlices: 4},
+	} {
+		result, err := AnalyzeSlices("amen.wav", options)
+		if err != nil {
+			t.Fatalf("%s: AnalyzeSlices failed: %v", name, err)
+		}
+		t.Logf("%s: detected %d, kept %d", name, result.NumDetected, len(result.Onsets))
+		if result.NumDetected <= len(result.Onsets) {
+			t.Errorf("%s: expected more detected than kept onsets (%d), got %d", name, len(result.Onsets), result.NumDetected)
+		}
+		if result.NumDetected != len(result.Onsets)+len(result.RejectedOnsets) {
+			t.Errorf("%s: expected detected to be kept plus rejected, got %d", name, result.NumDetected)
+		}
+		if name != "consensus" && result.NumDetected != all.NumDetected {
+			t.Errorf("%s: expected the %d onsets of the detection pass, got %d", name, all.NumDetected, result.NumDetected)
+		}
+	}
+}
+
+func TestConsensusMinStrength(t *testing.T) {
+	// Three clusters of four markers each, with average strengths 0.2, 0.8 and 0.5
+	var markers []onsetWithStrength
+	for c, strength := range []float64{0.2, 0.8, 0.5} {
+		for i := 0; i < 4; i++ {
+			markers = append(markers, onsetWithStrength{
+				time:     float64(c) + float64(i)*0.005,
+				strength: strength,
+			})
+		}
+	}
+
+	expectedCounts := map[float64]int{0.0: 3, 0.3: 2, 0.6: 1, 0.9: 0}
+	for minStrength, expected := range expectedCounts {
+		onsets, _ := clusterConsensusOnsets(append([]onsetWithStrength{}, markers...), 3, minStrength, removeOutliers, nil)
+		if len(onsets) != expected {
+			t.Errorf("With min strength %.1f expected %d clusters, got %d", minStrength, expected, len(onsets))
+		}
+	}
+
+	// Raising the threshold removes the weakest cluster first
+	onsets, _ := clusterConsensusOnsets(append([]onsetWithStrength{}, markers...), 3, 0.3, removeOutliers, nil)
+	if len(onsets) == 2 && (onsets[0] < 0.5 || onsets[1] < 1.5) {
+		t.Errorf("Expected the weakest cluster at 0s to be dropped, got %v", onsets)
+	}
+
+	t.Run("OnAmen", func(t *testing.T) {
+		options := SliceAnalyzerOptions{Method: "consensus"}
+
+		all, err := AnalyzeSlices("amen.wav", options)
+		if err != nil {
+			t.Fatalf("AnalyzeSlices failed: %v", err)
+		}
+
+		options.ConsensusMinStrength = 0.1
+		strong, err := AnalyzeSlices("amen.wav", options)
+		if err != nil {
+			t.Fatalf("AnalyzeSlices failed: %v", err)
+		}
+
+		t.Logf("All clusters: %d, strong clusters: %d", len(all.Onsets), len(strong.Onsets))
+
+		if len(strong.Onsets) >= len(all.Onsets) {
+			t.Errorf("Expected fewer onsets with ConsensusMinStrength, got %d (was %d)", len(strong.Onsets), len(all.Onsets))
+		}
+
+		// Every remaining onset must be one of the unfiltered consensus onsets
+		for _, onsetTime := range strong.Onsets {
+			found := false
+			for _, other := range all.Onsets {
+				if onsetTime == other {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("Onset %f is not part of the unfiltered consensus", onsetTime)
+			}
+		}
+	})
+}
+
+func TestAnalyzeSamples(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", NumSlices: 8}
+
+	fromSamples, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	fromFile, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(fromSamples.Onsets) != len(fromFile.Onsets) {
+		t.Fatalf("Expected %d onsets, got %d", len(fromFile.Onsets), len(fromSamples.Onsets))
+	}
+
+	for i := range fromFile.Onsets {
+		if fromSamples.Onsets[i] != fromFile.Onsets[i] {
+			t.Errorf("Onset %d differs: %f vs %f", i, fromSamples.Onsets[i], fromFile.Onsets[i])
+		}
+	}
+
+	if _, err := AnalyzeSamples(samples, 0, options); err == nil {
+		t.Error("Expected error for zero sample rate, got nil")
+	}
+}
+
+func TestGridMinimumSpacing(t *testing.T) {
+	options := SliceAnalyzerOptions{
+		UseMinimumSpacing: true,
+		MinimumSpacing:    1,
+		SpacingBPM:        120,
+		SpacingDivision:   16,
+	}
+
+	if spacing := minimumSpacingMs(options); math.Abs(spacing-125.0) > 1e-9 {
+		t.Errorf("Expected one sixteenth at 120 BPM to be 125ms, got %f", spacing)
+	}
+
+	// The division defaults to sixteenths
+	options.SpacingDivision = 0
+	options.MinimumSpacing = 2
+	if spacing := minimumSpacingMs(options); math.Abs(spacing-250.0) > 1e-9 {
+		t.Errorf("Expected two sixteenths at 120 BPM to be 250ms, got %f", spacing)
+	}
+
+	// Without a tempo, MinimumSpacing stays in milliseconds
+	if spacing := minimumSpacingMs(SliceAnalyzerOptions{MinimumSpacing: 80}); spacing != 80 {
+		t.Errorf("Expected 80ms, got %f", spacing)
+	}
+
+	options.MinimumSpacing = 1
+	options.Method = "hfc"
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	for i := 1; i < len(result.Onsets); i++ {
+		if spacing := result.Onsets[i] - result.Onsets[i-1]; spacing < 0.125 {
+			t.Errorf("Onset at index %d has spacing %.4fs, expected at least 0.125s", i, spacing)
+		}
+	}
+}
+
+func TestAutoSpacing(t *testing.T) {
+	// Quarter notes at 120 BPM with flams 40 ms after three of them
+	var beats, times []float64
+	for i := 0; i < 16; i++ {
+		beats = append(beats, 0.1+float64(i)*0.25)
+	}
+	times = append(times, beats...)
+	times = append(times, beats[2]+0.04, beats[6]+0.04, beats[10]+0.04)
+	samples := clickTrack(44100, 4.2, times, 0.7)
+
+	options := SliceAnalyzerOptions{
+		Method:            "hfc",
+		UseMinimumSpacing: true,
+		MinimumSpacing:    20,
+	}
+	fixed, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(fixed.Onsets) != len(times) {
+		t.Fatalf("Expected the %d clicks and flams with 20ms spacing, got %d", len(times), len(fixed.Onsets))
+	}
+
+	// A 32nd note at 120 BPM is 62.5 ms, which removes the flams
+	if spacing := onsetSpacingMs(fixed.Onsets, SliceAnalyzerOptions{AutoSpacing: true}); math.Abs(spacing-62.5) > 1.0 {
+		t.Errorf("Expected a 32nd note at 120 BPM (62.5ms), got %.2fms", spacing)
+	}
+
+	options.AutoSpacing = true
+	auto, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(auto.Onsets) != len(beats) {
+		t.Fatalf("Expected %d onsets with auto spacing, got %d: %v", len(beats), len(auto.Onsets), auto.Onsets)
+	}
+	for i, beat := range beats {
+		if math.Abs(auto.Onsets[i]-beat) > 0.02 {
+			t.Errorf("Onset %d: expected %.3fs, got %.3fs", i, beat, auto.Onsets[i])
+		}
+	}
+
+	// Too few onsets to trust a tempo: the configured spacing is used
+	if spacing := onsetSpacingMs(times[:4], options); spacing != 20 {
+		t.Errorf("Expected fallback to 20ms for 4 onsets, got %.2fms", spacing)
+	}
+}
+
+func TestOnsetsPerSecond(t *testing.T) {
+	// 10 seconds of clicks every 250 ms with varying loudness
+	var times []float64
+	for i := 0; i < 40; i++ {
+		times = append(times, 0.1+float64(i)*0.245)
+	}
+	samples := clickTrack(44100, 10.0, times, 0.8)
+	for i, onsetTime := range times {
+		start := int(onsetTime * 44100)
+		gain := 0.3 + 0.7*float64(i%5)/4
+		for j := start; j < start+1323 && j < len(samples); j++ {
+			samples[j] *= gain
+		}
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", OnsetsPerSecond: 2}
+	result, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if math.Abs(float64(len(result.Onsets))-20) > 1 {
+		t.Errorf("Expected about 20 onsets, got %d", len(result.Onsets))
+	}
+
+	options.NumSlices = 8
+	if _, err := AnalyzeSamples(samples, 44100, options); err == nil {
+		t.Error("Expected error when both NumSlices and OnsetsPerSecond are set, got nil")
+	}
+	if _, err := AnalyzeSlices("amen.wav", options); err == nil {
+		t.Error("Expected error from AnalyzeSlices when both are set, got nil")
+	}
+}
+
+func TestAdaptiveMedianWindow(t *testing.T) {
+	// Half a second of steady noise, a 60ms silent gap, then a hit at 0.76s
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 1.0, []float64{0.76}, 0.5)
+	seed := uint32(3)
+	for i := int(0.2 * float64(sampleRate)); i < int(0.7*float64(sampleRate)); i++ {
+		seed = seed*1664525 + 1013904223
+		samples[i] += 0.5 * (float64(seed)/float64(math.MaxUint32)*2 - 1)
+	}
+
+	hasHit := func(window int) bool {
+		options := SliceAnalyzerOptions{Method: "hfc", AdaptiveMedianWindow: window}
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		for _, onsetTime := range result.Onsets {
+			if math.Abs(onsetTime-0.76) < 0.02 {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A long window still holds the noise after the gap and misses the hit
+	if hasHit(60) {
+		t.Error("Expected a 60 frame window to miss the hit after the gap")
+	}
+
+	if !hasHit(5) {
+		t.Error("Expected a 5 frame window to recover the hit after the gap")
+	}
+
+	pp := NewPeakPicker()
+	if pp.GetMedianWindow() != 7 {
+		t.Errorf("Expected default median window 7, got %d", pp.GetMedianWindow())
+	}
+	pp.SetMedianWindow(1)
+	if pp.GetMedianWindow() != 3 {
+		t.Errorf("Expected median window clamped to 3, got %d", pp.GetMedianWindow())
+	}
+}
+
+func TestConsensusKeepOutliers(t *testing.T) {
+	// A dense cluster with one genuinely late marker
+	var markers []onsetWithStrength
+	for _, onsetTime := range []float64{1.000, 1.002, 1.004, 1.006, 1.040} {
+		markers = append(markers, onsetWithStrength{time: onsetTime, strength: 1})
+	}
+
+	withRemoval, _ := clusterConsensusOnsets(append([]onsetWithStrength{}, markers...), 3, 0, removeOutliers, nil)
+	withoutRemoval, _ := clusterConsensusOnsets(append([]onsetWithStrength{}, markers...), 3, 0, nil, nil)
+	if len(withRemoval) != 1 || len(withoutRemoval) != 1 {
+		t.Fatalf("Expected 1 cluster each, got %v and %v", withRemoval, withoutRemoval)
+	}
+
+	if math.Abs(withRemoval[0]-1.003) > 1e-9 {
+		t.Errorf("Expected midpoint 1.003 without the late marker, got %f", withRemoval[0])
+	}
+
+	if math.Abs(withoutRemoval[0]-1.0104) > 1e-9 {
+		t.Errorf("Expected plain mean 1.0104, got %f", withoutRemoval[0])
+	}
+
+	// Outliers are removed for options built as a struct literal, and kept
+	// only when asked
+	filter, err := outlierFilterFor(SliceAnalyzerOptions{Method: "consensus"})
+	if err != nil || filter == nil {
+		t.Errorf("Expected outlier removal by default, got filter %v and error %v", filter != nil, err)
+	}
+	if got := calculateClusterMidpoint([]float64{1.000, 1.002, 1.004, 1.006, 1.040}, filter); math.Abs(got-1.003) > 1e-9 {
+		t.Errorf("Expected default midpoint 1.003 without the late marker, got %f", got)
+	}
+	if filter, _ := outlierFilterFor(SliceAnalyzerOptions{Method: "consensus", ConsensusKeepOutliers: true}); filter != nil {
+		t.Error("Expected no outlier filter with ConsensusKeepOutliers")
+	}
+}
+
+func TestRemoveOutliersMAD(t *testing.T) {
+	// A tight cluster with a moderately late marker and a single far outlier
+	cluster := []float64{1.000, 1.001, 1.001, 1.002, 1.002, 1.003, 1.010, 1.050}
+
+	iqr := removeOutliers(cluster)
+	mad := removeOutliersMAD(cluster)
+
+	for name, kept := range map[string][]float64{"iqr": iqr, "mad": mad} {
+		for _, value := range kept {
+			if value == 1.050 {
+				t.Errorf("%s: expected the far outlier to be rejected, got %v", name, kept)
+			}
+		}
+	}
+
+	// MAD is stricter and also rejects the late marker
+	if len(iqr) != 7 || len(mad) != 6 {
+		t.Errorf("Expected IQR to keep 7 and MAD to keep 6 values, got %v and %v", iqr, mad)
+	}
+
+	filter, err := outlierFilterFor(SliceAnalyzerOptions{ConsensusOutlierMethod: "mad"})
+	if err != nil || filter == nil {
+		t.Fatalf("Expected a MAD filter, got error %v", err)
+	}
+	if got := calculateClusterMidpoint(cluster, filter); math.Abs(got-1.0015) > 1e-9 {
+		t.Errorf("Expected MAD midpoint 1.0015, got %f", got)
+	}
+
+	if _, err := AnalyzeSamples(make([]float64, 44100), 44100, SliceAnalyzerOptions{ConsensusOutlierMethod: "zscore"}); err == nil {
+		t.Error("Expected error for unknown outlier method, got nil")
+	}
+}
+
+func TestCalculatePercentileMethod(t *testing.T) {
+	// Values from numpy.percentile with the same methods
+	sorted := []float64{1, 2, 3, 4}
+	tests := []struct {
+		method     string
+		percentile float64
+		expected   float64
+	}{
+		{"linear", 25, 1.75},
+		{"nearest", 25, 2},
+		{"lower", 25, 1},
+		{"higher", 25, 2},
+		{"linear", 50, 2.5},
+		{"nearest", 50, 3}, // rank 1.5 rounds to the even index 2
+		{"lower", 50, 2},
+		{"higher", 50, 3},
+		{"linear", 75, 3.25},
+		{"nearest", 75, 3},
+		{"lower", 75, 3},
+		{"higher", 75, 4},
+		{"nearest", 100, 4},
+	}
+	for _, tt := range tests {
+		if got := calculatePercentileMethod(sorted, tt.percentile, tt.method); math.Abs(got-tt.expected) > 1e-12 {
+			t.Errorf("%s %.0fth percentile: expected %g, got %g", tt.method, tt.percentile, tt.expected, got)
+		}
+	}
+
+	filter, err := outlierFilterFor(SliceAnalyzerOptions{ConsensusPercentileMethod: "lower"})
+	if err != nil || filter == nil {
+		t.Fatalf("Expected an IQR filter, got error %v", err)
+	}
+	if _, err := AnalyzeSamples(make([]float64, 44100), 44100, SliceAnalyzerOptions{ConsensusPercentileMethod: "midpoint"}); err == nil {
+		t.Error("Expected error for unknown percentile method, got nil")
+	}
+}
+
+func TestConsensusSilentMethods(t *testing.T) {
+	// Quiet clicks are below the silence gate of the phase-based methods, so
+	// only some methods fire
+	samples := clickTrack(44100, 2.0, []float64{0.5, 1.0, 1.5}, 0.01)
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "consensus"
+	options.NumSlices = 0
+	// Requiring every method to agree means every method that fired
+	options.MinConsensusClusterSize = len(consensusMethods)
+
+	result, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	silent := 0
+	for _, method := range consensusMethods {
+		if len(result.PerMethodOnsets[method]) == 0 {
+			silent++
+		}
+	}
+	if silent == 0 {
+		t.Fatal("Expected some methods to find no onsets on the quiet clicks")
+	}
+	if len(result.Onsets) != 3 {
+		t.Errorf("Expected 3 onsets with %d silent methods, got %d: %v", silent, len(result.Onsets), result.Onsets)
+	}
+}
+
+func TestScaleClusterSize(t *testing.T) {
+	tests := []struct {
+		minClusterSize, activeMethods, expected int
+	}{
+		{3, 9, 3},
+		{3, 6, 2},
+		{3, 4, 2},
+		{3, 3, 1},
+		{3, 1, 1},
+		{9, 6, 6},
+		{5, 0, 1},
+	}
+	for _, tt := range tests {
+		got := scaleClusterSize(tt.minClusterSize, tt.activeMethods, 9)
+		if got != tt.expected {
+			t.Errorf("scaleClusterSize(%d, %d, 9) = %d, expected %d", tt.minClusterSize, tt.activeMethods, got, tt.expected)
+		}
+	}
+}
+
+func TestConsensusDeterministic(t *testing.T) {
+	var times []float64
+	for i := 0; i < 6; i++ {
+		times = append(times, 0.05+float64(i)*0.16)
+	}
+	samples := clickTrack(44100, 1.0, times, 0.7)
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "consensus"
+	options.NumSlices = 4
+	options.Optimize = false
+
+	first, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	for run := 1; run < 20; run++ {
+		result, err := AnalyzeSamples(samples, 44100, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		if len(result.Onsets) != len(first.Onsets) || len(result.RejectedOnsets) != len(first.RejectedOnsets) {
+			t.Fatalf("Run %d: expected %d onsets and %d rejected, got %d and %d", run,
+				len(first.Onsets), len(first.RejectedOnsets), len(result.Onsets), len(result.RejectedOnsets))
+		}
+		for i := range first.Onsets {
+			if result.Onsets[i] != first.Onsets[i] {
+				t.Fatalf("Run %d: onset %d differs: %v vs %v", run, i, result.Onsets[i], first.Onsets[i])
+			}
+		}
+		for i := range first.RejectedOnsets {
+			if result.RejectedOnsets[i] != first.RejectedOnsets[i] {
+				t.Fatalf("Run %d: rejected onset %d differs: %v vs %v", run, i, result.RejectedOnsets[i], first.RejectedOnsets[i])
+			}
+		}
+	}
+}
+
+func TestSubsampleRefine(t *testing.T) {
+	cases := []struct {
+		x0, x1, x2 float64
+		expected   float64
+	}{
+		{1, 2, 1, 0},
+		{0, 2, 1, 1.0 / 6},
+		{1, 2, 0, -1.0 / 6},
+		{0, 1, 1, 0.5},
+		{1, 1, 1, 0},
+		{2, 1, 2, 0},
+	}
+	for _, c := range cases {
+		if got := parabolicPeakOffset(c.x0, c.x1, c.x2); math.Abs(got-c.expected) > 1e-12 {
+			t.Errorf("parabolicPeakOffset(%v, %v, %v) = %v, expected %v", c.x0, c.x1, c.x2, got, c.expected)
+		}
+	}
+
+	// A click halfway between two frame boundaries
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 0.5, []float64{float64(256*40+128) / float64(sampleRate)}, 0.8)
+
+	options := SliceAnalyzerOptions{Method: "hfc"}
+	plain, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	options.SubsampleRefine = true
+	refined, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(plain.Onsets) != 1 || len(refined.Onsets) != 1 {
+		t.Fatalf("Expected 1 onset each, got %v and %v", plain.Onsets, refined.Onsets)
+	}
+
+	plainPos := plain.Onsets[0] * float64(sampleRate)
+	refinedPos := refined.Onsets[0] * float64(sampleRate)
+	t.Logf("Plain position %.3f, refined position %.3f", plainPos, refinedPos)
+
+	if math.Abs(plainPos-math.Round(plainPos)) > 1e-6 {
+		t.Errorf("Expected the plain onset on a whole sample, got %f", plainPos)
+	}
+	if math.Abs(refinedPos-math.Round(refinedPos)) < 1e-3 {
+		t.Errorf("Expected the refined onset between samples, got %f", refinedPos)
+	}
+	if math.Abs(refinedPos-plainPos) > 256 {
+		t.Errorf("Expected the refined onset within a hop of the plain one, got %f vs %f", refinedPos, plainPos)
+	}
+}
+
+func TestPerMethodOnsets(t *testing.T) {
+	samples := clickTrack(44100, 1.0, []float64{0.1, 0.4, 0.7}, 0.8)
+	options := SliceAnalyzerOptions{Method: "consensus"}
+
+	result, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(result.PerMethodOnsets) != len(consensusMethods) {
+		t.Fatalf("Expected %d methods, got %d", len(consensusMethods), len(result.PerMethodOnsets))
+	}
+
+	// Each entry holds exactly what the method detects on its own
+	for _, method := range consensusMethods {
+		expected := detectOnsetsInternal(samples, 44100, relaxedDetector(method, options))
+		got, ok := result.PerMethodOnsets[method]
+		if !ok {
+			t.Errorf("Missing onsets for method %s", method)
+			continue
+		}
+		if len(got) != len(expected) {
+			t.Errorf("%s: expected %d onsets, got %d", method, len(expected), len(got))
+			continue
+		}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Errorf("%s: onset %d differs: %f vs %f", method, i, got[i], expected[i])
+			}
+		}
+	}
+
+	// Other methods leave the map empty
+	single, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if single.PerMethodOnsets != nil {
+		t.Errorf("Expected no per-method onsets for hfc, got %v", single.PerMethodOnsets)
+	}
+}
+
+func TestSensitivity(t *testing.T) {
+	counts := make(map[float64]int)
+	for _, sensitivity := range []float64{0.1, 0.9} {
+		options := DefaultSliceAnalyzerOptions()
+		options.Optimize = false
+		options.DropSamples = true
+		options.Sensitivity = sensitivity
+		// Overridden by the sensitivity
+		options.UseMinimumSpacing = false
+
+		result, err := AnalyzeSlices("amen.wav", options)
+		if err != nil {
+			t.Fatalf("AnalyzeSlices failed (sensitivity %.1f): %v", sensitivity, err)
+		}
+		counts[sensitivity] = len(result.Onsets)
+
+		_, _, spacingMs := sensitivityParameters(sensitivity)
+		for i := 1; i < len(result.Onsets); i++ {
+			if (result.Onsets[i]-result.Onsets[i-1])*1000 < spacingMs-1e-6 {
+				t.Errorf("Sensitivity %.1f: onsets %d and %d closer than %.0f ms", sensitivity, i-1, i, spacingMs)
+			}
+		}
+	}
+
+	t.Logf("Onsets: sensitivity 0.1 -> %d, 0.9 -> %d", counts[0.1], counts[0.9])
+
+	if counts[0.9] <= counts[0.1] {
+		t.Errorf("Expected more onsets at sensitivity 0.9 (%d) than at 0.1 (%d)", counts[0.9], counts[0.1])
+	}
+
+	if _, err := AnalyzeSamples(make([]float64, 1000), 44100, SliceAnalyzerOptions{Sensitivity: 1.5}); err == nil {
+		t.Error("Expected error for sensitivity above 1, got nil")
+	}
+}
+
+func TestEnergyPercentile(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+	allOnsets, _ := findAllOnsets(samples, sampleRate, relaxedDetector("hfc", SliceAnalyzerOptions{}))
+	candidates := len(allOnsets)
+
+	for _, percentile := range []float64{25, 60, 90} {
+		options := SliceAnalyzerOptions{Method: "hfc", EnergyPercentile: percentile}
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+
+		expected := float64(candidates) * (100 - percentile) / 100
+		t.Logf("Percentile %.0f: kept %d of %d candidates", percentile, len(result.Onsets), candidates)
+		if math.Abs(float64(len(result.Onsets))-expected) > 1 {
+			t.Errorf("Percentile %.0f: expected about %.1f onsets, got %d", percentile, expected, len(result.Onsets))
+		}
+		if len(result.Onsets)+len(result.RejectedOnsets) != candidates {
+			t.Errorf("Percentile %.0f: expected kept and rejected onsets to add up to %d", percentile, candidates)
+		}
+	}
+
+	// The consensus clusters are selected by the percentile too
+	all, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "consensus"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	louder, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "consensus", EnergyPercentile: 90})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(louder.Onsets) == 0 || len(louder.Onsets) >= len(all.Onsets) {
+		t.Errorf("Expected fewer consensus onsets above the 90th percentile than %d, got %d", len(all.Onsets), len(louder.Onsets))
+	}
+	if len(louder.Onsets)+len(louder.RejectedOnsets) != len(all.Onsets)+len(all.RejectedOnsets) {
+		t.Errorf("Expected the percentile to reject the other consensus onsets, got %d kept and %d rejected", len(louder.Onsets), len(louder.RejectedOnsets))
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", EnergyPercentile: 60, NumSlices: 8}
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error when both NumSlices and EnergyPercentile are set, got nil")
+	}
+}
+
+func TestOnsetInLastHop(t *testing.T) {
+	// Silence with a single click starting 200 samples before the end
+	sampleRate := uint(44100)
+	samples := make([]float64, 22050)
+	start := len(samples) - 200
+	click := clickTrack(sampleRate, 0.01, []float64{0}, 0.8)
+	copy(samples[start:], click)
+
+	config := relaxedDetector("hfc", SliceAnalyzerOptions{})
+	onsets := detectOnsetsInternal(samples, sampleRate, config)
+	if len(onsets) != 1 {
+		t.Fatalf("Expected 1 onset in the last hop, got %v", onsets)
+	}
+
+	// The delay compensation places clicks up to two hops early anywhere in a file
+	duration := float64(len(samples)) / float64(sampleRate)
+	expected := float64(start) / float64(sampleRate)
+	if onsets[0] >= duration || math.Abs(onsets[0]-expected) > 2*256/float64(sampleRate) {
+		t.Errorf("Expected onset near %.4fs, got %.4fs", expected, onsets[0])
+	}
+
+	// Streaming detection while decoding covers the tail too
+	path := t.TempDir() + "/tail.wav"
+	writeTestWav(t, path, samples, sampleRate, 1)
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	options.DropSamples = true
+	result, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Onsets) != 1 {
+		t.Errorf("Expected 1 onset from AnalyzeSlices, got %v", result.Onsets)
+	}
+}
+
+func TestPadStart(t *testing.T) {
+	sampleRate := uint(44100)
+	hop := 256.0 / float64(sampleRate)
+	samples := clickTrack(sampleRate, 0.5, []float64{0, 0.25}, 0.8)
+
+	plain := detectOnsetsInternal(samples, sampleRate, relaxedDetector("hfc", SliceAnalyzerOptions{}))
+	result, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "hfc", PadStartMs: 50})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	t.Logf("Without padding: %v, with padding: %v", plain, result.Onsets)
+
+	// The transient at sample 0 is reported near 0 and later onsets stay put
+	if len(result.Onsets) != 2 {
+		t.Fatalf("Expected 2 onsets with padding, got %v", result.Onsets)
+	}
+	if result.Onsets[0] > hop {
+		t.Errorf("Expected the first onset near 0, got %.4fs", result.Onsets[0])
+	}
+	if len(plain) != 2 || math.Abs(result.Onsets[1]-plain[1]) > hop {
+		t.Errorf("Expected the second onset within a hop of %v, got %.4fs", plain, result.Onsets[1])
+	}
+}
+
+func TestNormalizeInput(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+
+	half := make([]float64, len(samples))
+	for i, v := range samples {
+		half[i] = v * 0.5
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.NormalizeInput = true
+	expected, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	result, err := AnalyzeSamples(half, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	// Halving the level does not change the onsets
+	if len(result.Onsets) != len(expected.Onsets) {
+		t.Fatalf("Expected %d onsets at half level, got %d", len(expected.Onsets), len(result.Onsets))
+	}
+	for i := range result.Onsets {
+		if result.Onsets[i] != expected.Onsets[i] {
+			t.Errorf("Onset %d differs at half level: got %f, expected %f", i, result.Onsets[i], expected.Onsets[i])
+		}
+	}
+
+	// The samples are returned as given
+	if len(result.Samples) != len(half) || result.Samples[1000] != half[1000] {
+		t.Error("Expected the result to keep the input samples")
+	}
+
+	if len(expected.Onsets) < 8 {
+		t.Errorf("Expected at least 8 onsets for normalized amen.wav, got %d", len(expected.Onsets))
+	}
+}
+
+func TestBinWeighting(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, 2*int(sampleRate))
+
+	// Quiet hi-hats on sixteenth notes, with a low kick on every beat instead
+	seed := uint32(9)
+	var hats []float64
+	for k := 0; k < 29; k++ {
+		at := 0.1 + float64(k)*0.0625
+		start := int(at * float64(sampleRate))
+		if k%4 == 0 {
+			for i := 0; i < 8000 && start+i < len(samples); i++ {
+				tm := float64(i) / float64(sampleRate)
+				samples[start+i] += 0.5 * math.Exp(-tm*20) * math.Sin(2*math.Pi*60*tm)
+			}
+			continue
+		}
+
+		// Differenced noise has most of its energy in the high bins
+		hats = append(hats, at)
+		prev := 0.0
+		for i := 0; i < 441; i++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(math.MaxUint32)*2 - 1
+			samples[start+i] += 0.05 * (noise - prev) * math.Exp(-float64(i)/100)
+			prev = noise
+		}
+	}
+
+	detectedHats := func(weighting func(bin, numBins uint) float64) int {
+		config := relaxedDetector("hfc", SliceAnalyzerOptions{BinWeighting: weighting})
+		onsets := detectOnsetsInternal(samples, sampleRate, config)
+		count := 0
+		for _, hat := range hats {
+			for _, onsetTime := range onsets {
+				if math.Abs(onsetTime-hat) < 0.02 {
+					count++
+					break
+				}
+			}
+		}
+		return count
+	}
+
+	// A weighting equal to the default changes nothing
+	linear := detectedHats(func(bin, numBins uint) float64 { return float64(bin + 1) })
+	plain := detectedHats(nil)
+	if linear != plain {
+		t.Errorf("Expected the default weighting to detect %d hats, got %d", plain, linear)
+	}
+
+	squared := detectedHats(func(bin, numBins uint) float64 { return float64((bin + 1) * (bin + 1)) })
+	t.Logf("Hats detected of %d: default %d, squared weighting %d", len(hats), plain, squared)
+	if squared <= plain {
+		t.Errorf("Expected upweighting high bins to detect more hats than %d, got %d", plain, squared)
+	}
+
+	// Resetting the weighting restores the default
+	desc := NewSpecdesc("hfc", 512)
+	desc.SetWeighting(func(bin, numBins uint) float64 { return 0 })
+	desc.SetWeighting(nil)
+	spectrum := NewCvec(512)
+	for j := range spectrum.Norm {
+		spectrum.Norm[j] = 1
+	}
+	if value := desc.DoFrame(spectrum); value != 257*258/2 {
+		t.Errorf("Expected default hfc of %d, got %f", 257*258/2, value)
+	}
+}
+
+func TestStrengths(t *testing.T) {
+	streaming := DefaultSliceAnalyzerOptions()
+	streaming.Optimize = false
+	streaming.DropSamples = true
+
+	bestN := DefaultSliceAnalyzerOptions()
+	bestN.NumSlices = 8
+
+	percentile := DefaultSliceAnalyzerOptions()
+	percentile.EnergyPercentile = 50
+
+	consensus := DefaultSliceAnalyzerOptions()
+	consensus.Method = "consensus"
+	consensus.NumSlices = 8
+
+	for name, options := range map[string]SliceAnalyzerOptions{
+		"default":    DefaultSliceAnalyzerOptions(),
+		"streaming":  streaming,
+		"best-n":     bestN,
+		"percentile": percentile,
+		"consensus":  consensus,
+	} {
+		result, err := AnalyzeSlices("amen.wav", options)
+		if err != nil {
+			t.Fatalf("%s: AnalyzeSlices failed: %v", name, err)
+		}
+
+		if len(result.Onsets) == 0 {
+			t.Errorf("%s: expected onsets", name)
+		}
+		if len(result.Strengths) != len(result.Onsets) {
+			t.Errorf("%s: expected %d strengths, got %d", name, len(result.Onsets), len(result.Strengths))
+		}
+		for i, strength := range result.Strengths {
+			if strength <= 0 {
+				t.Errorf("%s: expected positive strength for onset %d, got %f", name, i, strength)
+			}
+		}
+	}
+
+	// Strengths follow the onsets through the minimum spacing filter
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+	allOnsets, allStrengths := findAllOnsets(samples, sampleRate, relaxedDetector("hfc", SliceAnalyzerOptions{}))
+	result, err := AnalyzeSamples(samples, sampleRate, streaming)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	for i, onsetTime := range result.Onsets {
+		for j := range allOnsets {
+			if allOnsets[j] == onsetTime && allStrengths[j] != result.Strengths[i] {
+				t.Errorf("Onset %d: expected strength %f, got %f", i, allStrengths[j], result.Strengths[i])
+			}
+		}
+	}
+}
+
+func TestEnergyDb(t *testing.T) {
+	// A full-scale click and one at half amplitude
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 1.0, []float64{0.2}, 1.0)
+	half := clickTrack(sampleRate, 0.3, []float64{0}, 0.5)
+	copy(samples[int(0.6*float64(sampleRate)):], half)
+
+	options := SliceAnalyzerOptions{Method: "hfc", EnergyDb: true}
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 2 || len(result.Energies) != 2 {
+		t.Fatalf("Expected 2 onsets with energies, got %v and %v", result.Onsets, result.Energies)
+	}
+
+	t.Logf("Energies: %.2f dBFS, %.2f dBFS", result.Energies[0], result.Energies[1])
+	if diff := result.Energies[1] - result.Energies[0]; math.Abs(diff+6.02) > 0.5 {
+		t.Errorf("Expected the half-amplitude onset about 6 dB lower, got %.2f dB", diff)
+	}
+	if result.Energies[0] >= 0 {
+		t.Errorf("Expected a level below full scale, got %.2f dBFS", result.Energies[0])
+	}
+
+	// Linear energies by default, and a floor for silence
+	options.EnergyDb = false
+	linear, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if math.Abs(20*math.Log10(linear.Energies[0])-result.Energies[0]) > 1e-9 {
+		t.Errorf("Expected dBFS to match the linear RMS %f, got %f", linear.Energies[0], result.Energies[0])
+	}
+	if rmsToDb(0) != energyFloorDb {
+		t.Errorf("Expected silence at %f dBFS, got %f", energyFloorDb, rmsToDb(0))
+	}
+}
+
+func TestSampleRateScaling(t *testing.T) {
+	// The same clicks at 88.2 kHz and decimated to 44.1 kHz
+	times := []float64{0.1, 0.35, 0.5, 0.8, 1.15}
+	high := clickTrack(88200, 1.5, times, 0.8)
+	low := make([]float64, len(high)/2)
+	for i := range low {
+		low[i] = high[2*i]
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc"}
+	lowResult, err := AnalyzeSamples(low, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	highResult, err := AnalyzeSamples(high, 88200, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	t.Logf("44.1 kHz: %v", lowResult.Onsets)
+	t.Logf("88.2 kHz: %v", highResult.Onsets)
+
+	if len(lowResult.Onsets) != len(times) || len(highResult.Onsets) != len(times) {
+		t.Fatalf("Expected %d onsets at both rates, got %d and %d", len(times), len(lowResult.Onsets), len(highResult.Onsets))
+	}
+	for i := range times {
+		if math.Abs(lowResult.Onsets[i]-highResult.Onsets[i]) > 0.003 {
+			t.Errorf("Onset %d: %.4fs at 44.1 kHz but %.4fs at 88.2 kHz", i, lowResult.Onsets[i], highResult.Onsets[i])
+		}
+	}
+
+	// Explicit durations are converted at the sample rate
+	bufSize, hopSize := relaxedDetector("hfc", SliceAnalyzerOptions{WindowMs: 20, HopMs: 5}).sizes(48000)
+	if bufSize != 960 || hopSize != 240 {
+		t.Errorf("Expected 960/240 samples for 20/5 ms at 48 kHz, got %d/%d", bufSize, hopSize)
+	}
+	if hop := (SliceAnalyzerOptions{WindowMs: 20, HopMs: 5}).HopSize(48000); hop != hopSize {
+		t.Errorf("Expected HopSize %d, got %d", hopSize, hop)
+	}
+	for _, tc := range []struct {
+		sampleRate uint
+		hop        uint
+	}{{8000, 64}, {44100, 256}, {48000, 256}, {96000, 512}} {
+		if hop := (SliceAnalyzerOptions{}).HopSize(tc.sampleRate); hop != tc.hop {
+			t.Errorf("Expected a default hop of %d samples at %d Hz, got %d", tc.hop, tc.sampleRate, hop)
+		}
+	}
+	if _, err := AnalyzeSamples(low, 44100, SliceAnalyzerOptions{WindowMs: 5, HopMs: 10}); err == nil {
+		t.Error("Expected error for a hop longer than the window, got nil")
+	}
+}
+
+func BenchmarkConsensus(b *testing.B) {
+	samples := clickTrack(44100, 1.0, []float64{0.1, 0.3, 0.5, 0.7}, 0.8)
+	options := SliceAnalyzerOptions{Method: "consensus"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := AnalyzeSamples(samples, 44100, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConsensusOptimizeSelected(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+
+	options := SliceAnalyzerOptions{
+		Method:                  "consensus",
+		NumSlices:               8,
+		Optimize:                true,
+		OptimizeWindowMs:        15,
+		MinConsensusClusterSize: 3,
+	}
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	// Optimizing every consensus onset, then selecting
+	options.NumSlices = 0
+	all, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 8 || len(all.Onsets) <= 8 {
+		t.Fatalf("Expected 8 of more than 8 onsets, got %d of %d", len(result.Onsets), len(all.Onsets))
+	}
+
+	sorted := append([]float64(nil), all.Onsets...)
+	sort.Float64s(sorted)
+	for _, onsetTime := range result.Onsets {
+		if nearest := nearestValue(sorted, onsetTime); math.Abs(nearest-onsetTime) > 0.002 {
+			t.Errorf("Onset %.4fs is %.4fs from the nearest onset optimized with all of them", onsetTime, nearest-onsetTime)
+		}
+	}
+}
+
+func BenchmarkConsensusOptimize(b *testing.B) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		b.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+	options := SliceAnalyzerOptions{Method: "consensus", NumSlices: 4, Optimize: true, OptimizeWindowMs: 100}
+
+	// Optimizing the selected onsets, as AnalyzeSamples does
+	b.Run("Selected", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			onsets, _, _, _ := findConsensusOnsets(samples, sampleRate, options)
+			optimizeOnsetPositions(samples, sampleRate, onsets, options.OptimizeWindowMs, nil)
+		}
+	})
+
+	// Optimizing every consensus onset before selecting
+	b.Run("All", func(b *testing.B) {
+		all := options
+		all.NumSlices = 0
+		for i := 0; i < b.N; i++ {
+			onsets, _, _, _ := findConsensusOnsets(samples, sampleRate, all)
+			onsets = optimizeOnsetPositions(samples, sampleRate, onsets, options.OptimizeWindowMs, nil)
+			selectBestOnsets(samples, sampleRate, onsets, options.NumSlices, calculateOnsetEnergy)
+		}
+	})
+}
+
+func TestLookaheadMs(t *testing.T) {
+	// A small bump 20 ms before a larger transient, and a transient near the end
+	samples := make([]float64, 44100)
+	addBurst(samples, 11025, 0.1)
+	addBurst(samples, 11025+882, 0.8)
+	addBurst(samples, 44100-1500, 0.8)
+
+	without, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	with, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{Method: "hfc", LookaheadMs: 30})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(without.Onsets) != 3 {
+		t.Fatalf("Expected the bump and both transients without lookahead, got %v", without.Onsets)
+	}
+	if len(with.Onsets) != 2 {
+		t.Fatalf("Expected only the two transients with lookahead, got %v", with.Onsets)
+	}
+	for i := range with.Onsets {
+		if with.Onsets[i] != without.Onsets[i+1] {
+			t.Errorf("Onset %d: expected %.4fs as without lookahead, got %.4fs", i, without.Onsets[i+1], with.Onsets[i])
+		}
+	}
+
+	if _, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{LookaheadMs: -1}); err == nil {
+		t.Error("Expected error for a negative lookahead, got nil")
+	}
+}
+
+func TestSuppressPeriodic(t *testing.T) {
+	// A steady pulse every 250 ms with a few off-grid accents
+	var times []float64
+	for k := 0; k < 16; k++ {
+		times = append(times, 0.1+0.25*float64(k))
+	}
+	accents := []float64{0.7, 1.47, 2.98}
+	samples := clickTrack(44100, 4.3, append(times, accents...), 0.8)
+
+	result, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{SuppressPeriodic: true})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(result.Onsets) != len(accents) {
+		t.Fatalf("Expected only the %d accents, got %v", len(accents), result.Onsets)
+	}
+	for i, accent := range accents {
+		if math.Abs(result.Onsets[i]-accent) > 0.02 {
+			t.Errorf("Onset %d: expected the accent at %.2fs, got %.4fs", i, accent, result.Onsets[i])
+		}
+	}
+	if len(result.Strengths) != len(result.Onsets) {
+		t.Errorf("Expected %d strengths, got %d", len(result.Onsets), len(result.Strengths))
+	}
+	if len(result.RejectedOnsets) != len(times) {
+		t.Errorf("Expected the %d pulse onsets to be rejected, got %d", len(times), len(result.RejectedOnsets))
+	}
+}
+
+func TestKeepInterleaved(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	options.KeepInterleaved = true
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if result.NumChannels != 2 {
+		t.Fatalf("Expected 2 channels, got %d", result.NumChannels)
+	}
+	if len(result.Interleaved) != 2*len(result.Samples) {
+		t.Fatalf("Expected %d interleaved samples, got %d", 2*len(result.Samples), len(result.Interleaved))
+	}
+	differs := false
+	for i, sample := range result.Samples {
+		if result.Interleaved[2*i] != sample {
+			t.Fatalf("Frame %d: expected left channel %f, got %f", i, sample, result.Interleaved[2*i])
+		}
+		differs = differs || result.Interleaved[2*i+1] != sample
+	}
+	if !differs {
+		t.Error("Expected the right channel to differ from the left")
+	}
+
+	// Detection still runs on the left channel only
+	mono, err := AnalyzeSlices("amen.wav", DefaultSliceAnalyzerOptions())
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(mono.Onsets) != len(result.Onsets) {
+		t.Errorf("Expected %d onsets as without KeepInterleaved, got %d", len(mono.Onsets), len(result.Onsets))
+	}
+	if mono.Interleaved != nil || mono.NumChannels != 0 {
+		t.Error("Expected no interleaved samples without KeepInterleaved")
+	}
+}
+
+func TestConstantSignal(t *testing.T) {
+	for _, value := range []float64{0, 0.5} {
+		samples := make([]float64, 44100)
+		for i := range samples {
+			samples[i] = value
+		}
+
+		for _, method := range []string{"hfc", "complex", "consensus", "auto"} {
+			for _, numSlices := range []int{0, 4} {
+				options := DefaultSliceAnalyzerOptions()
+				options.Method = method
+				options.NumSlices = numSlices
+				result, err := AnalyzeSamples(samples, 44100, options)
+				if err != nil {
+					t.Fatalf("Constant %.1f, %s: expected no error, got %v", value, method, err)
+				}
+				if result.Onsets == nil || len(result.Onsets) != 0 {
+					t.Errorf("Constant %.1f, %s: expected an empty onset list, got %v", value, method, result.Onsets)
+				}
+				if len(result.Samples) != len(samples) {
+					t.Errorf("Constant %.1f, %s: expected %d samples, got %d", value, method, len(samples), len(result.Samples))
+				}
+				if result.Duration != 1.0 {
+					t.Errorf("Constant %.1f, %s: expected a duration of 1s, got %f", value, method, result.Duration)
+				}
+			}
+		}
+
+		// Same result when detecting while decoding
+		path := t.TempDir() + "/constant.wav"
+		writeTestWav(t, path, samples, 44100, 1)
+		options := DefaultSliceAnalyzerOptions()
+		options.DropSamples = true
+		options.Optimize = false
+		result, err := AnalyzeSlices(path, options)
+		if err != nil {
+			t.Fatalf("Constant %.1f: expected no error while decoding, got %v", value, err)
+		}
+		if len(result.Onsets) != 0 {
+			t.Errorf("Constant %.1f: expected no onsets while decoding, got %v", value, result.Onsets)
+		}
+	}
+
+	// Percentiles outside 0-100 are clamped instead of indexing out of range
+	sorted := []float64{1, 2, 3}
+	if p := calculatePercentile(sorted, 150); p != 3 {
+		t.Errorf("Expected the maximum for the 150th percentile, got %f", p)
+	}
+	if p := calculatePercentile(sorted, -10); p != 1 {
+		t.Errorf("Expected the minimum for a negative percentile, got %f", p)
+	}
+}
+
+func TestStagedPipeline(t *testing.T) {
+	// Loud hits with quieter ones in between, one 70 ms before a loud hit
+	samples := clickTrack(44100, 2.0, []float64{0.2, 0.8, 1.4}, 0.8)
+	quiet := clickTrack(44100, 2.0, []float64{0.13, 0.5, 1.1, 1.7}, 0.2)
+	for i := range samples {
+		samples[i] += quiet[i]
+	}
+	near := func(onsets []float64, times ...float64) bool {
+		if len(onsets) != len(times) {
+			return false
+		}
+		for i := range times {
+			if math.Abs(onsets[i]-times[i]) > 0.015 {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("detect", func(t *testing.T) {
+		// A lower detection threshold never finds fewer candidates
+		previous := -1
+		for _, threshold := range []float64{0.3, 0.1, 0.02, 0.005} {
+			result, err := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{DetectThreshold: threshold})
+			if err != nil {
+				t.Fatalf("AnalyzeSlices failed: %v", err)
+			}
+			if len(result.Onsets) < previous {
+				t.Errorf("Threshold %.3f: expected at least %d onsets, got %d", threshold, previous, len(result.Onsets))
+			}
+			previous = len(result.Onsets)
+		}
+		strict, _ := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{DetectThreshold: 0.3})
+		if len(strict.Onsets) >= previous {
+			t.Errorf("Expected fewer onsets at threshold 0.3 than 0.005, got %d and %d", len(strict.Onsets), previous)
+		}
+	})
+
+	t.Run("keep spacing", func(t *testing.T) {
+		// The louder hit of the close pair survives, unlike MinimumSpacing
+		result, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{KeepSpacingMs: 80})
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		if !near(result.Onsets, 0.19, 0.49, 0.79, 1.09, 1.39, 1.69) {
+			t.Errorf("Expected the loud hit at 0.2s to be kept, got %v", result.Onsets)
+		}
+		if !near(result.RejectedOnsets, 0.12) {
+			t.Errorf("Expected the quiet hit at 0.13s to be rejected, got %v", result.RejectedOnsets)
+		}
+
+		first, _ := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{UseMinimumSpacing: true, MinimumSpacing: 80})
+		if !near(first.RejectedOnsets, 0.19) {
+			t.Errorf("Expected MinimumSpacing to drop the later hit, got %v", first.RejectedOnsets)
+		}
+	})
+
+	t.Run("keep strongest", func(t *testing.T) {
+		result, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{KeepStrongest: 3})
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		if !near(result.Onsets, 0.19, 0.79, 1.39) || len(result.Strengths) != 3 {
+			t.Errorf("Expected the three loud hits, got %v", result.Onsets)
+		}
+
+		// With spacing, the close quiet hit does not take a place
+		spaced, _ := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{KeepStrongest: 4, KeepSpacingMs: 80})
+		if len(spaced.Onsets) != 4 {
+			t.Fatalf("Expected 4 onsets, got %v", spaced.Onsets)
+		}
+		for i := 1; i < len(spaced.Onsets); i++ {
+			if spaced.Onsets[i]-spaced.Onsets[i-1] < 0.08 {
+				t.Errorf("Expected onsets at least 80 ms apart, got %v", spaced.Onsets)
+			}
+		}
+	})
+
+	if _, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{KeepStrongest: 3, NumSlices: 3}); err == nil {
+		t.Error("Expected error for KeepStrongest with NumSlices, got nil")
+	}
+	if _, err := AnalyzeSamples(samples, 44100, SliceAnalyzerOptions{DetectThreshold: -1}); err == nil {
+		t.Error("Expected error for a negative detection threshold, got nil")
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	byTime, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	options.OrderBy = "energy"
+	byEnergy, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(byEnergy.Onsets) != len(byTime.Onsets) || len(byEnergy.Energies) != len(byEnergy.Onsets) || len(byEnergy.Strengths) != len(byEnergy.Onsets) {
+		t.Fatalf("Expected %d aligned onsets, got %d onsets, %d energies and %d strengths",
+			len(byTime.Onsets), len(byEnergy.Onsets), len(byEnergy.Energies), len(byEnergy.Strengths))
+	}
+
+	// Loudest first
+	for i := 1; i < len(byEnergy.Energies); i++ {
+		if byEnergy.Energies[i] > byEnergy.Energies[i-1] {
+			t.Fatalf("Energies not in descending order at index %d: %f > %f", i, byEnergy.Energies[i], byEnergy.Energies[i-1])
+		}
+	}
+
+	// The same onsets, with their energy and strength moved along
+	type entry struct{ energy, strength float64 }
+	expected := make(map[float64]entry, len(byTime.Onsets))
+	for i, onsetTime := range byTime.Onsets {
+		expected[onsetTime] = entry{byTime.Energies[i], byTime.Strengths[i]}
+	}
+	for i, onsetTime := range byEnergy.Onsets {
+		e, ok := expected[onsetTime]
+		if !ok {
+			t.Fatalf("Onset %.4f not found when ordered by time", onsetTime)
+		}
+		if e.energy != byEnergy.Energies[i] || e.strength != byEnergy.Strengths[i] {
+			t.Errorf("Onset %.4f: expected energy %f and strength %f, got %f and %f",
+				onsetTime, e.energy, e.strength, byEnergy.Energies[i], byEnergy.Strengths[i])
+		}
+		delete(expected, onsetTime)
+	}
+
+	if sort.Float64sAreSorted(byEnergy.Onsets) {
+		t.Error("Expected onsets ordered by energy to differ from time order")
+	}
+
+	if _, err := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{OrderBy: "strength"}); err == nil {
+		t.Error("Expected error for an unknown order, got nil")
+	}
+}
+
+func TestEnergyFunc(t *testing.T) {
+	// Six clicks getting louder
+	times := []float64{0.1, 0.3, 0.5, 0.7, 0.9, 1.1}
+	samples := clickTrack(44100, 1.3, times, 0.8)
+	for i, onsetTime := range times {
+		start := int(onsetTime * 44100)
+		for j := start; j < start+2205; j++ {
+			samples[j] *= 0.2 + 0.15*float64(i)
+		}
+	}
+
+	quietest := func(samples []float64, sampleRate uint, onsetTime float64) float64 {
+		return -calculateOnsetEnergy(samples, sampleRate, onsetTime)
+	}
+
+	for _, options := range []SliceAnalyzerOptions{
+		{Method: "hfc", NumSlices: 3},
+		{Method: "consensus", NumSlices: 3, MinConsensusClusterSize: 3},
+		{Method: "hfc", EnergyPercentile: 50},
+		{Method: "consensus", EnergyPercentile: 50, MinConsensusClusterSize: 3},
+	} {
+		method := options.Method
+		loudest, err := AnalyzeSamples(samples, 44100, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+
+		options.EnergyFunc = quietest
+		inverted, err := AnalyzeSamples(samples, 44100, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+
+		// The built-in energy keeps the last three clicks, the inverted one the first three
+		for i, result := range []*SliceAnalyzerResult{loudest, inverted} {
+			expected := times[3:]
+			if i == 1 {
+				expected = times[:3]
+			}
+			if len(result.Onsets) != len(expected) {
+				t.Fatalf("%s: expected %d onsets, got %d: %v", method, len(expected), len(result.Onsets), result.Onsets)
+			}
+			for j, onsetTime := range expected {
+				if math.Abs(result.Onsets[j]-onsetTime) > 0.02 {
+					t.Errorf("%s: expected onset %d at %.3fs, got %.3fs", method, j, onsetTime, result.Onsets[j])
+				}
+			}
+		}
+	}
+}
+
+func TestWhitenWarmup(t *testing.T) {
+	// A noise floor under clicks, the first 50 ms into the file, before the
+	// whitening has adapted to the clicks
+	rng := rand.New(rand.NewSource(1))
+	samples := clickTrack(44100, 1.2, []float64{0.05, 0.3, 0.6, 0.9}, 0.8)
+	for i := range samples {
+		samples[i] += 0.01 * (rng.Float64()*2 - 1)
+	}
+
+	hasOnsetNear := func(onsets []float64, target float64) bool {
+		for _, onsetTime := range onsets {
+			if math.Abs(onsetTime-target) < 0.02 {
+				return true
+			}
+		}
+		return false
+	}
+
+	options := SliceAnalyzerOptions{Method: "complex"}
+	cold, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if hasOnsetNear(cold.Onsets, 0.05) {
+		t.Fatalf("Expected the early click to be suppressed without warmup, got %v", cold.Onsets)
+	}
+
+	options.WhitenWarmup = true
+	warm, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	for _, click := range []float64{0.05, 0.3, 0.6, 0.9} {
+		if !hasOnsetNear(warm.Onsets, click) {
+			t.Errorf("Expected an onset near %.2fs with warmup, got %v", click, warm.Onsets)
+		}
+	}
+}
+
+func TestUseDifference(t *testing.T) {
+	// Quiet clicks over a loud, swelling 35 Hz rumble
+	clicks := []float64{0.5, 1.0, 1.5}
+	samples := clickTrack(44100, 2.0, clicks, 0.05)
+	for i := range samples {
+		tt := float64(i) / 44100
+		samples[i] += 0.6 * (0.6 + 0.4*math.Sin(2*math.Pi*1.7*tt)) * math.Sin(2*math.Pi*35*tt)
+	}
+
+	// Clicks found, and onsets away from the clicks and the start of the file
+	evaluate := func(onsets []float64) (found, spurious int) {
+		for _, onsetTime := range onsets {
+			nearClick := false
+			for _, click := range clicks {
+				if math.Abs(onsetTime-click) < 0.02 {
+					nearClick = true
+				}
+			}
+			if nearClick {
+				found++
+			} else if onsetTime > 0.05 {
+				spurious++
+			}
+		}
+		return found, spurious
+	}
+
+	options := SliceAnalyzerOptions{Method: "energy", DetectThreshold: 0.3}
+	raw, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	options.UseDifference = true
+	difference, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	rawFound, _ := evaluate(raw.Onsets)
+	found, spurious := evaluate(difference.Onsets)
+	if found != len(clicks) || spurious != 0 {
+		t.Errorf("Expected the %d clicks and nothing else on the difference, got %d clicks and %d others: %v",
+			len(clicks), found, spurious, difference.Onsets)
+	}
+	if rawFound >= found {
+		t.Errorf("Expected the difference to find more clicks than the samples (%d), got %d", rawFound, found)
+	}
+
+	// The energies are measured on the samples, not on the difference
+	if len(difference.Energies) > 0 && difference.Energies[len(difference.Energies)-1] < 0.1 {
+		t.Errorf("Expected energies of the samples including the rumble, got %v", difference.Energies)
+	}
+}
+
+func TestOnReject(t *testing.T) {
+	// The click 40 ms after the first is within the 80 ms spacing
+	samples := clickTrack(44100, 1.0, []float64{0.2, 0.24, 0.6}, 0.8)
+
+	type rejection struct {
+		time   float64
+		reason string
+	}
+	var rejections []rejection
+	options := SliceAnalyzerOptions{
+		Method:            "hfc",
+		UseMinimumSpacing: true,
+		MinimumSpacing:    80,
+		OnReject: func(timeSec float64, reason string) {
+			rejections = append(rejections, rejection{timeSec, reason})
+		},
+	}
+
+	result, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 2 {
+		t.Fatalf("Expected 2 onsets, got %d: %v", len(result.Onsets), result.Onsets)
+	}
+
+	spacing := 0
+	for _, r := range rejections {
+		if r.reason == "spacing" {
+			spacing++
+			if math.Abs(r.time-0.24) > 0.02 {
+				t.Errorf("Expected the spacing rejection near 0.24s, got %.3fs", r.time)
+			}
+		}
+	}
+	if spacing != 1 {
+		t.Errorf("Expected 1 rejection for spacing, got %d: %v", spacing, rejections)
+	}
+
+	// Every onset in RejectedOnsets was reported
+	for _, onsetTime := range result.RejectedOnsets {
+		reported := false
+		for _, r := range rejections {
+			reported = reported || r.time == onsetTime
+		}
+		if !reported {
+			t.Errorf("Rejected onset %.3fs was not reported", onsetTime)
+		}
+	}
+
+	// The best-N cut reports the onsets it drops
+	rejections = nil
+	options.UseMinimumSpacing = false
+	options.NumSlices = 1
+	if _, err := AnalyzeSamples(samples, 44100, options); err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	bestN := 0
+	for _, r := range rejections {
+		if r.reason == "best-n" {
+			bestN++
+		}
+	}
+	if bestN != 2 {
+		t.Errorf("Expected 2 rejections for best-n, got %d: %v", bestN, rejections)
+	}
+}
+
+func TestConsensusCalibration(t *testing.T) {
+	// Two methods with the same ordering of four onsets on very different
+	// scales, the first with one outlying peak
+	loud := []float64{1, 2, 3, 100}
+	quiet := []float64{0.01, 0.02, 0.03, 0.04}
+
+	maxLoud, maxQuiet := calibrateStrengths(loud, "max"), calibrateStrengths(quiet, "max")
+	if math.Abs(maxLoud[2]-maxQuiet[2]) < 0.5 {
+		t.Errorf("Expected the outlier to squash the max calibration, got %v and %v", maxLoud, maxQuiet)
+	}
+
+	// Percentile ranks give both methods the same scale
+	rankLoud, rankQuiet := calibrateStrengths(loud, "percentile"), calibrateStrengths(quiet, "percentile")
+	expected := []float64{0.25, 0.5, 0.75, 1}
+	for i := range expected {
+		if rankLoud[i] != expected[i] || rankQuiet[i] != expected[i] {
+			t.Errorf("Onset %d: expected %f for both methods, got %f and %f", i, expected[i], rankLoud[i], rankQuiet[i])
+		}
+	}
+
+	// Ties share the rank of the highest
+	if tied := calibrateStrengths([]float64{2, 1, 2}, "percentile"); tied[0] != 1 || tied[2] != 1 || math.Abs(tied[1]-1.0/3) > 1e-12 {
+		t.Errorf("Expected ranks [1 0.333 1], got %v", tied)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "consensus"
+	options.ConsensusCalibration = "percentile"
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	for i, strength := range result.Strengths {
+		if strength <= 0 || strength > 1 {
+			t.Errorf("Onset %d: expected a calibrated strength in (0, 1], got %f", i, strength)
+		}
+	}
+
+	options.ConsensusCalibration = "rank"
+	if _, err := AnalyzeSlices("amen.wav", options); err == nil {
+		t.Error("Expected error for an unknown calibration, got nil")
+	}
+}
+
+func TestMinOnsetEnergyDb(t *testing.T) {
+	// Low-level noise with swells around -60 dBFS, which the relative
+	// detector still finds onsets in
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 2.0, []float64{0.2, 0.6, 1.0, 1.4}, 0.003)
+	seed := uint32(7)
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		samples[i] += 0.0005 * (float64(seed)/float64(math.MaxUint32)*2 - 1)
+	}
+	path := filepath.Join(t.TempDir(), "noise.wav")
+	writeTestWav(t, path, samples, sampleRate, 1)
+
+	options := SliceAnalyzerOptions{Method: "hfc"}
+	result, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Onsets) == 0 {
+		t.Fatal("Expected onsets in the noise without a floor")
+	}
+
+	var floored []float64
+	options.MinOnsetEnergyDb = -40
+	options.OnReject = func(timeSec float64, reason string) {
+		if reason == "floor" {
+			floored = append(floored, timeSec)
+		}
+	}
+	result, err = AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Onsets) != 0 {
+		t.Errorf("Expected no onsets above -40 dBFS, got %v", result.Onsets)
+	}
+	if len(floored) == 0 || len(floored) != len(result.RejectedOnsets) {
+		t.Errorf("Expected the floored onsets reported and rejected, got %v and %v", floored, result.RejectedOnsets)
+	}
+
+	// A loud click is kept, and the floor is absolute: normalizing the
+	// input does not lift the noise above it
+	loud := clickTrack(sampleRate, 2.0, []float64{0.8}, 0.8)
+	for i := range loud {
+		loud[i] += samples[i]
+	}
+	options.OnReject = nil
+	result, err = AnalyzeSamples(loud, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 1 || math.Abs(result.Onsets[0]-0.8) > 0.02 {
+		t.Errorf("Expected only the loud click at 0.8s, got %v", result.Onsets)
+	}
+
+	options.NormalizeInput = true
+	result, err = AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 0 {
+		t.Errorf("Expected no onsets in normalized noise, got %v", result.Onsets)
+	}
+}
+
+func TestSilenceHysteresis(t *testing.T) {
+	// Noise hovering around -60 dB, stepping 4 dB above and below it every
+	// 40 ms, then silence and a loud click
+	sampleRate := uint(44100)
+	samples := clickTrack(sampleRate, 2.0, []float64{1.7}, 0.8)
+	step := int(0.04 * float64(sampleRate))
+	seed := uint32(3)
+	for i := 0; i < int(1.5*float64(sampleRate)); i++ {
+		// Uniform noise of amplitude a has a level of 20*log10(a/sqrt(3))
+		db := -64.0
+		if (i/step)%2 == 1 {
+			db = -56.0
+		}
+		seed = seed*1664525 + 1013904223
+		samples[i] += math.Sqrt(3) * math.Pow(10, db/20) * (float64(seed)/float64(math.MaxUint32)*2 - 1)
+	}
+
+	countHovering := func(onsets []float64) int {
+		count := 0
+		for _, onsetTime := range onsets {
+			if onsetTime > 0.05 && onsetTime < 1.5 {
+				count++
+			}
+		}
+		return count
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", SilenceOpenDb: -60}
+	single, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	chatter := countHovering(single.Onsets)
+	t.Logf("Single threshold: %d onsets in the hovering noise", chatter)
+	if chatter < 3 {
+		t.Fatalf("Expected the single threshold to chatter, got %v", single.Onsets)
+	}
+
+	options.SilenceOpenDb = -50
+	options.SilenceCloseDb = -70
+	hysteresis, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if count := countHovering(hysteresis.Onsets); count != 0 {
+		t.Errorf("Expected no onsets in the hovering noise with hysteresis, got %v", hysteresis.Onsets)
+	}
+	if len(hysteresis.Onsets) == 0 || math.Abs(hysteresis.Onsets[len(hysteresis.Onsets)-1]-1.7) > 0.02 {
+		t.Errorf("Expected the click at 1.7s, got %v", hysteresis.Onsets)
+	}
+
+	options.SilenceOpenDb = -80
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error when the gate closes above the level it opens at, got nil")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	// Hits of different levels, so the best-N selection has an order to keep
+	sampleRate := uint(44100)
+	samples := make([]float64, 2*int(sampleRate))
+	for i, hit := range []struct{ time, amplitude float64 }{
+		{0.2, 0.3}, {0.5, 0.9}, {0.8, 0.1}, {1.1, 0.6}, {1.4, 0.45}, {1.7, 0.75},
+	} {
+		burst := clickTrack(sampleRate, 0.1, []float64{0}, hit.amplitude*(1+0.01*float64(i)))
+		copy(samples[int(hit.time*float64(sampleRate)):], burst)
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", NumSlices: 4, OrderBy: "energy"}
+	hann, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(hann.Onsets) != 4 {
+		t.Fatalf("Expected 4 onsets, got %v", hann.Onsets)
+	}
+
+	for _, window := range []string{"hamming", "blackman", "rectangular"} {
+		options.Window = window
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("%s: AnalyzeSamples failed: %v", window, err)
+		}
+		if len(result.Onsets) != len(hann.Onsets) {
+			t.Fatalf("%s: expected %d onsets, got %v", window, len(hann.Onsets), result.Onsets)
+		}
+		for i := range result.Onsets {
+			if math.Abs(result.Onsets[i]-hann.Onsets[i]) > 0.01 {
+				t.Errorf("%s: expected onset %d at %.3fs as with hann, got %.3fs", window, i, hann.Onsets[i], result.Onsets[i])
+			}
+		}
+	}
+
+	options.Window = "triangle"
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for an unknown window, got nil")
+	}
+}
+
+func TestShortOneShot(t *testing.T) {
+	// 200 ms one-shots, shorter than the 100 ms optimization window on either
+	// side of a late attack
+	sampleRate := uint(44100)
+	for _, attack := range []float64{0, 0.02, 0.05, 0.12} {
+		samples := make([]float64, int(0.2*float64(sampleRate)))
+		copy(samples[int(attack*float64(sampleRate)):], clickTrack(sampleRate, 0.2-attack, []float64{0}, 0.8))
+
+		result, err := AnalyzeSamples(samples, sampleRate, DefaultSliceAnalyzerOptions())
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		if len(result.Onsets) != 1 || math.Abs(result.Onsets[0]-attack) > 0.003 {
+			t.Errorf("Attack at %.3fs: expected a single onset at the attack, got %v", attack, result.Onsets)
+		}
+	}
+}
+
+func TestPreEchoGuard(t *testing.T) {
+	// A 2 ms pre-spike 15 ms before a loud hit, which short hops detect as
+	// two onsets, and two equally loud hits 15 ms apart
+	sampleRate := uint(44100)
+	samples := make([]float64, sampleRate)
+	copy(samples[int(0.5*float64(sampleRate)):], clickTrack(sampleRate, 0.002, []float64{0}, 0.1))
+	for _, hit := range []float64{0.515, 0.8, 0.815} {
+		burst := clickTrack(sampleRate, 0.015, []float64{0}, 0.8)
+		for i, v := range burst {
+			samples[int(hit*float64(sampleRate))+i] += v
+		}
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", HopMs: 1}
+	unguarded, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(unguarded.Onsets) != 4 {
+		t.Fatalf("Expected the pre-spike and 3 hits without the guard, got %v", unguarded.Onsets)
+	}
+
+	var preEchoes []float64
+	options.PreEchoGuardMs = 20
+	options.OnReject = func(timeSec float64, reason string) {
+		if reason == "pre-echo" {
+			preEchoes = append(preEchoes, timeSec)
+		}
+	}
+	guarded, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	// The pre-spike is merged into its hit; the equal hits are both kept
+	expected := []float64{0.515, 0.8, 0.815}
+	if len(guarded.Onsets) != len(expected) {
+		t.Fatalf("Expected onsets near %v, got %v", expected, guarded.Onsets)
+	}
+	for i, onsetTime := range guarded.Onsets {
+		if math.Abs(onsetTime-expected[i]) > 0.003 {
+			t.Errorf("Expected onset %d near %.3fs, got %.4fs", i, expected[i], onsetTime)
+		}
+	}
+	if len(preEchoes) != 1 || math.Abs(preEchoes[0]-0.5) > 0.003 {
+		t.Errorf("Expected the pre-spike near 0.5s reported, got %v", preEchoes)
+	}
+
+	options.PreEchoGuardMs = -1
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for a negative pre-echo guard, got nil")
+	}
+}
+
+func TestLogCompression(t *testing.T) {
+	// A loud, limited mix: a sustained bass and noise bed with hits every
+	// 250ms, all through a tanh limiter so the hits add little energy
+	sampleRate := uint(44100)
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, 4*sampleRate)
+	for i := range samples {
+		x := float64(i) / float64(sampleRate)
+		samples[i] = 0.4*math.Sin(2*math.Pi*110*x) + 0.3*math.Sin(2*math.Pi*220.5*x) + 0.1*rng.NormFloat64()
+	}
+	var truth []float64
+	for k := 1; k < 16; k++ {
+		hit := float64(k) * 0.25
+		truth = append(truth, hit)
+		start := int(hit * float64(sampleRate))
+		for i, v := range clickTrack(sampleRate, 0.1, []float64{0}, 0.6) {
+			samples[start+i] += v
+		}
+	}
+	for i := range samples {
+		samples[i] = math.Tanh(2 * samples[i])
+	}
+
+	linear, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "energy"})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	compressed, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "energy", LogCompression: 1})
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	_, linearRecall, linearF1 := EvaluateOnsets(linear.Onsets, truth, 0.03)
+	_, recall, f1 := EvaluateOnsets(compressed.Onsets, truth, 0.03)
+	if linearRecall >= 1 {
+		t.Fatalf("Expected the linear energy to miss some hits of the limited mix, got recall %.2f", linearRecall)
+	}
+	if recall < 1 {
+		t.Errorf("Expected log compression to find every hit, got recall %.2f (onsets %v)", recall, compressed.Onsets)
+	}
+	if f1 <= linearF1 {
+		t.Errorf("Expected log compression to improve the F-measure over %.2f, got %.2f", linearF1, f1)
+	}
+
+	if _, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{LogCompression: -1}); err == nil {
+		t.Error("Expected error for a negative log compression, got nil")
+	}
+}
+
+func TestSuggestSliceCount(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("failed to read amen.wav: %v", err)
+	}
+	candidates, _ := findAllOnsets(samples, sampleRate, relaxedDetector("hfc", SliceAnalyzerOptions{}))
+	count := SuggestSliceCount(samples, sampleRate, "")
+	if count < 4 || count > 32 || count >= len(candidates) {
+		t.Errorf("Expected a small slice count for amen.wav below its %d candidates, got %d", len(candidates), count)
+	}
+
+	// Four loud hits among eight quiet ones
+	sampleRate = 44100
+	var loud, quiet []float64
+	for i := 0; i < 12; i++ {
+		if i%3 == 0 {
+			loud = append(loud, 0.1+float64(i)*0.25)
+		} else {
+			quiet = append(quiet, 0.1+float64(i)*0.25)
+		}
+	}
+	samples = clickTrack(sampleRate, 3.2, loud, 0.8)
+	for i, v := range clickTrack(sampleRate, 3.2, quiet, 0.05) {
+		samples[i] += v
+	}
+	if count := SuggestSliceCount(samples, sampleRate, "hfc"); count != len(loud) {
+		t.Errorf("Expected %d slices for the loud hits, got %d", len(loud), count)
+	}
+
+	// Equally loud hits are all kept
+	samples = clickTrack(sampleRate, 3.2, append(loud, quiet...), 0.8)
+	if count := SuggestSliceCount(samples, sampleRate, "hfc"); count != len(loud)+len(quiet) {
+		t.Errorf("Expected all %d equal hits, got %d", len(loud)+len(quiet), count)
+	}
+
+	if count := SuggestSliceCount(make([]float64, sampleRate), sampleRate, "hfc"); count != 0 {
+		t.Errorf("Expected 0 slices for silence, got %d", count)
+	}
+}
+
+func TestMinProminence(t *testing.T) {
+	// A shallow bump on a high plateau of busy novelty, and an isolated
+	// peak of the same height on a low baseline
+	novelty := []float64{0.1, 0.1, 0.9, 0.9, 0.9, 0.9, 1.0, 0.9, 0.9, 0.9, 0.9, 0.1, 0.1, 0.1, 0.1, 0.1, 1.0, 0.1, 0.1, 0.1}
+	if p := noveltyProminence(novelty, 6, 3); math.Abs(p-0.1) > 1e-9 {
+		t.Errorf("Expected a prominence of 0.1 for the bump on the plateau, got %f", p)
+	}
+	if p := noveltyProminence(novelty, 16, 3); math.Abs(p-0.9) > 1e-9 {
+		t.Errorf("Expected a prominence of 0.9 for the isolated peak, got %f", p)
+	}
+
+	// The search stops at higher novelty, and a missing side does not count
+	if p := noveltyProminence([]float64{0.2, 2.0, 0.5, 1.0, 0.1}, 3, 3); math.Abs(p-0.5) > 1e-9 {
+		t.Errorf("Expected a prominence of 0.5 next to a higher peak, got %f", p)
+	}
+	if p := noveltyProminence([]float64{1.0, 0.2, 0.2}, 0, 3); math.Abs(p-0.8) > 1e-9 {
+		t.Errorf("Expected a prominence of 0.8 at the start, got %f", p)
+	}
+
+	// Isolated clicks are prominent and all kept
+	sampleRate := uint(44100)
+	times := []float64{0.3, 0.8, 1.3, 1.8}
+	samples := clickTrack(sampleRate, 2.5, times, 0.8)
+	for _, method := range []string{"hfc", "energy", "specflux"} {
+		result, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: method, MinProminence: 0.9})
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if _, recall, _ := EvaluateOnsets(result.Onsets, times, 0.02); recall != 1 {
+			t.Errorf("%s: expected every click to be kept, got %v", method, result.Onsets)
+		}
+	}
+
+	if _, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{MinProminence: 1.5}); err == nil {
+		t.Error("Expected error for a minimum prominence above 1, got nil")
+	}
+}
+
+func TestAutoGain(t *testing.T) {
+	// A quiet section, under the silence gate, followed by a loud one
+	sampleRate := uint(44100)
+	var quiet, loud []float64
+	for k := 0; k < 6; k++ {
+		quiet = append(quiet, 0.5+float64(k)*0.4)
+		loud = append(loud, 3.5+float64(k)*0.4)
+	}
+	samples := clickTrack(sampleRate, 6, quiet, 0.0005)
+	for i, v := range clickTrack(sampleRate, 6, loud, 0.8) {
+		samples[i] += v
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc"}
+	fixed, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	_, quietRecall, _ := EvaluateOnsets(fixed.Onsets, quiet, 0.02)
+	if quietRecall > 0 {
+		t.Fatalf("Expected the quiet section to be gated without gain control, got recall %.2f", quietRecall)
+	}
+
+	options.AutoGainTimeMs = 500
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	precision, recall, _ := EvaluateOnsets(result.Onsets, append(quiet, loud...), 0.02)
+	if precision < 1 || recall < 1 {
+		t.Errorf("Expected every onset of both sections with gain control, got %v", result.Onsets)
+	}
+
+	options.AutoGainTimeMs = -1
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for a negative gain time constant, got nil")
+	}
+}
+
+func TestClipAware(t *testing.T) {
+	// A decaying 60 Hz tone driven far into clipping at 0.4s, a railed
+	// square wave whose edges look like onsets
+	sampleRate := uint(44100)
+	samples := make([]float64, sampleRate)
+	attack := 0.4
+	for i := 0; i < int(0.3*float64(sampleRate)); i++ {
+		x := float64(i) / float64(sampleRate)
+		v := 20 * math.Sin(2*math.Pi*60*x) * math.Exp(-5*x)
+		samples[int(attack*float64(sampleRate))+i] = math.Max(-1, math.Min(1, v))
+	}
+
+	options := SliceAnalyzerOptions{Method: "hfc", NumSlices: 1, Optimize: true, OptimizeWindowMs: 50}
+	unaware, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if math.Abs(unaware.Onsets[0]-attack) < 0.005 {
+		t.Fatalf("Expected the clipped plateau to mislead the analysis, got the attack at %.4fs", unaware.Onsets[0])
+	}
+
+	var clippedReports int
+	options.ClipAware = true
+	options.OnReject = func(timeSec float64, reason string) {
+		if reason == "clipped" {
+			clippedReports++
+		}
+	}
+	aware, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(aware.Onsets) != 1 || math.Abs(aware.Onsets[0]-attack) > 0.002 {
+		t.Errorf("Expected the onset at the attack %.3fs, got %v", attack, aware.Onsets)
+	}
+	if clippedReports == 0 {
+		t.Error("Expected onsets inside the clipped audio to be reported")
+	}
+
+	// An unclipped low tone has no clipped runs, even at its peaks
+	tone := make([]float64, sampleRate)
+	for i := range tone {
+		tone[i] = math.Sin(2 * math.Pi * 20 * float64(i) / float64(sampleRate))
+	}
+	for i, c := range clippedSamples(tone) {
+		if c {
+			t.Fatalf("Expected no clipped samples in an unclipped tone, got one at %d", i)
+		}
+	}
+}
+
+func TestPreviewSec(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("failed to read amen.wav: %v", err)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.NumSlices = 0
+
+	full, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	options.PreviewSec = 1.0
+	preview, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(preview.Samples) != int(sampleRate) || preview.Duration != 1.0 {
+		t.Errorf("Expected 1s of samples, got %d samples and %.3fs", len(preview.Samples), preview.Duration)
+	}
+	if len(preview.Onsets) == 0 || len(preview.Onsets) >= len(full.Onsets) {
+		t.Fatalf("Expected fewer onsets in the preview than the %d of the whole file, got %d", len(full.Onsets), len(preview.Onsets))
+	}
+	for i, onsetTime := range preview.Onsets {
+		if onsetTime >= 1.0 {
+			t.Errorf("Expected onsets within the preview, got %.3fs", onsetTime)
+		}
+		// Away from the end, the preview finds the onsets of the whole file
+		if onsetTime < 0.9 && math.Abs(onsetTime-full.Onsets[i]) > 1e-9 {
+			t.Errorf("Onset %d: expected %.4fs as in the whole file, got %.4fs", i, full.Onsets[i], onsetTime)
+		}
+	}
+
+	// A long file: AnalyzeSlices stops decoding at the end of the preview
+	var times []float64
+	for onsetTime := 0.25; onsetTime < 30; onsetTime += 0.5 {
+		times = append(times, onsetTime)
+	}
+	path := filepath.Join(t.TempDir(), "long.wav")
+	writeTestWav(t, path, clickTrack(sampleRate, 30, times, 0.8), sampleRate, 1)
+
+	options = DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	start := time.Now()
+	if _, err := AnalyzeSlices(path, options); err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	fullTime := time.Since(start)
+
+	options.PreviewSec = 2.0
+	start = time.Now()
+	decoded, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	previewTime := time.Since(start)
+
+	if len(decoded.Samples) != 2*int(sampleRate) || len(decoded.Stats.Warnings) != 0 {
+		t.Errorf("Expected 2s of samples and no length warning, got %d samples and %v", len(decoded.Samples), decoded.Stats.Warnings)
+	}
+	if previewTime*5 >= fullTime {
+		t.Errorf("Expected the preview to be more than 5 times faster than the whole file, took %v vs %v", previewTime, fullTime)
+	}
+
+	// Detecting while decoding stops there too, with the same onsets
+	options.DropSamples = true
+	streamed, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if streamed.Duration != 2.0 || len(streamed.Stats.Warnings) != 0 || !reflect.DeepEqual(streamed.Onsets, decoded.Onsets) {
+		t.Errorf("Expected the streamed preview %.3fs %v to match %v, warnings %v", streamed.Duration, streamed.Onsets, decoded.Onsets, streamed.Stats.Warnings)
+	}
+	if len(streamed.Onsets) != 4 {
+		t.Errorf("Expected the 4 clicks of the preview, got %v", streamed.Onsets)
+	}
+
+	options.PreviewSec = -1
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for a negative preview length, got nil")
+	}
+}
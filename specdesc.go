@@ -18,8 +18,13 @@ const (
 	OnsetKL
 	OnsetMKL
 	OnsetSpecflux
+	OnsetComplexity
 )
 
+// complexityPeakRatio is the magnitude, relative to the largest bin of the
+// frame, above which a spectral peak counts towards the spectral complexity
+const complexityPeakRatio = 0.1
+
 // Specdesc represents a spectral descriptor for onset detection
 type Specdesc struct {
 	OnsetType SpecdescType
@@ -28,6 +33,12 @@ type Specdesc struct {
 	Dev1      *Fvec
 	Theta1    *Fvec
 	Theta2    *Fvec
+	// Weights holds the weight of each spectral bin used by the energy and hfc
+	// descriptors, or nil for their default weighting (see SetWeighting)
+	Weights *Fvec
+	// OldCount is the number of significant spectral peaks of the previous
+	// frame, used by the complexity descriptor
+	OldCount float64
 }
 
 // NewSpecdesc creates a new spectral descriptor
@@ -62,6 +73,8 @@ func NewSpecdesc(onsetMode string, size uint) *Specdesc {
 		s.OnsetType = OnsetMKL
 	case "specflux":
 		s.OnsetType = OnsetSpecflux
+	case "complexity":
+		s.OnsetType = OnsetComplexity
 	default:
 		s.OnsetType = OnsetHFC
 	}
@@ -90,16 +103,50 @@ func (s *Specdesc) Do(fftgrain *Cvec, onset *Fvec) {
 		s.mkl(fftgrain, onset)
 	case OnsetSpecflux:
 		s.specflux(fftgrain, onset)
+	case OnsetComplexity:
+		s.complexity(fftgrain, onset)
 	default:
 		s.hfc(fftgrain, onset)
 	}
 }
 
+// DoFrame computes the spectral descriptor of a single spectral frame and
+// returns its novelty value. Descriptors that compare against the previous
+// frames (all except energy and hfc) update their history, so successive calls
+// must be given consecutive frames.
+func (s *Specdesc) DoFrame(spectrum *Cvec) float64 {
+	onset := NewFvec(1)
+	s.Do(spectrum, onset)
+	return onset.Data[0]
+}
+
+// SetWeighting sets the weight of each spectral bin in the energy and hfc
+// descriptors from weight(bin, numBins), to shape their frequency emphasis.
+// By default energy weights every bin by 1 and hfc weights bin j by j+1; for
+// example weight returning float64((bin+1)*(bin+1)) emphasizes high bins more
+// than hfc. A nil weight restores the defaults.
+func (s *Specdesc) SetWeighting(weight func(bin, numBins uint) float64) {
+	if weight == nil {
+		s.Weights = nil
+		return
+	}
+
+	numBins := s.OldMag.Length
+	s.Weights = NewFvec(numBins)
+	for j := uint(0); j < numBins; j++ {
+		s.Weights.Data[j] = weight(j, numBins)
+	}
+}
+
 // energy computes energy-based onset detection
 func (s *Specdesc) energy(fftgrain *Cvec, onset *Fvec) {
 	onset.Data[0] = 0.0
 	for j := uint(0); j < fftgrain.Length; j++ {
-		onset.Data[0] += fftgrain.Norm[j] * fftgrain.Norm[j]
+		weight := 1.0
+		if s.Weights != nil {
+			weight = s.Weights.Data[j]
+		}
+		onset.Data[0] += weight * fftgrain.Norm[j] * fftgrain.Norm[j]
 	}
 }
 
@@ -107,7 +154,11 @@ func (s *Specdesc) energy(fftgrain *Cvec, onset *Fvec) {
 func (s *Specdesc) hfc(fftgrain *Cvec, onset *Fvec) {
 	onset.Data[0] = 0.0
 	for j := uint(0); j < fftgrain.Length; j++ {
-		onset.Data[0] += float64(j+1) * fftgrain.Norm[j]
+		weight := float64(j + 1)
+		if s.Weights != nil {
+			weight = s.Weights.Data[j]
+		}
+		onset.Data[0] += weight * fftgrain.Norm[j]
 	}
 }
 
@@ -212,3 +263,25 @@ func (s *Specdesc) specflux(fftgrain *Cvec, onset *Fvec) {
 		s.OldMag.Data[j] = fftgrain.Norm[j]
 	}
 }
+
+// complexity computes Spectral Complexity onset detection: the change in the
+// number of spectral peaks above complexityPeakRatio of the largest bin (and
+// above Threshold), which rises when notes are added or a chord changes
+func (s *Specdesc) complexity(fftgrain *Cvec, onset *Fvec) {
+	maxNorm := 0.0
+	for j := uint(0); j < fftgrain.Length; j++ {
+		maxNorm = math.Max(maxNorm, fftgrain.Norm[j])
+	}
+	floor := math.Max(s.Threshold, complexityPeakRatio*maxNorm)
+
+	count := 0.0
+	for j := uint(1); j+1 < fftgrain.Length; j++ {
+		norm := fftgrain.Norm[j]
+		if norm > floor && norm > fftgrain.Norm[j-1] && norm >= fftgrain.Norm[j+1] {
+			count++
+		}
+	}
+
+	onset.Data[0] = math.Abs(count - s.OldCount)
+	s.OldCount = count
+}
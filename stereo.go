@@ -0,0 +1,123 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MergeStereoOnsets confirms onsets detected separately in the left and right
+// channels: an onset is kept only when both channels have one at most
+// toleranceSec apart, and its time is taken from the pair according to
+// mergeTime:
+//   - "average": the midpoint of the two times
+//   - "earliest": the earlier of the two times, so the slice does not cut
+//     into the first channel's transient
+//
+// An empty mergeTime is "average". Pairs are matched closest first, each
+// onset in at most one pair, and the merged onsets are sorted by time.
+func MergeStereoOnsets(left, right []float64, toleranceSec float64, mergeTime string) ([]float64, error) {
+	if mergeTime != "" && mergeTime != "average" && mergeTime != "earliest" {
+		return nil, fmt.Errorf("unknown stereo merge time: %q (must be \"average\" or \"earliest\")", mergeTime)
+	}
+
+	merged := []float64{}
+	for _, match := range MatchOnsets(left, right, toleranceSec) {
+		l, r := left[match.A], right[match.B]
+		if mergeTime == "earliest" {
+			merged = append(merged, min(l, r))
+		} else {
+			merged = append(merged, (l+r)/2)
+		}
+	}
+	sort.Float64s(merged)
+
+	return merged, nil
+}
+
+// stereoSilenceDb is the level in dB of a frame of both channels below which
+// its correlation is not measured
+const stereoSilenceDb = -70.0
+
+// stereoCorrelation returns the correlation coefficient of the left and right
+// channels over frames of frameSize samples every hopSize samples, from 1 for
+// a centered sound (both channels equal) through 0 for a sound in one channel
+// only to -1 for channels in opposite phase. Silent frames keep the
+// correlation of the frame before, starting from 1.
+func stereoCorrelation(left, right []float64, frameSize, hopSize int) []float64 {
+	numFrames := min(len(left), len(right)) / hopSize
+	correlation := make([]float64, numFrames)
+	silence := math.Pow(10, stereoSilenceDb/10)
+
+	previous := 1.0
+	for i := range correlation {
+		start := i * hopSize
+		end := min(start+frameSize, len(left), len(right))
+		var lr, ll, rr float64
+		for k := start; k < end; k++ {
+			lr += left[k] * right[k]
+			ll += left[k] * left[k]
+			rr += right[k] * right[k]
+		}
+
+		if (ll+rr)/float64(2*(end-start)) >= silence {
+			if ll > 0 && rr > 0 {
+				previous = lr / math.Sqrt(ll*rr)
+			} else {
+				previous = 0
+			}
+		}
+		correlation[i] = previous
+	}
+
+	return correlation
+}
+
+// detectStereoWidth detects onsets at abrupt changes of the correlation of the
+// left and right channels, e.g. a hit panned hard to one side over a centered
+// mix, with the frame sizes, threshold, minimum inter-onset interval and
+// adaptive threshold of the detector. The novelty is the change of the
+// correlation from one frame to the next. It returns the onset times in
+// seconds with the peak-picked novelty of each.
+func detectStereoWidth(left, right []float64, sampleRate uint, config detectorConfig) ([]float64, []float64) {
+	onsets, strengths := []float64{}, []float64{}
+	bufSize, hopSize := config.sizes(sampleRate)
+	if len(right) == 0 || hopSize == 0 {
+		return onsets, strengths
+	}
+
+	pp := NewPeakPicker()
+	pp.SetThreshold(config.threshold)
+	if config.medianWindow > 0 {
+		pp.SetMedianWindow(uint(config.medianWindow))
+	}
+	if config.delta > 0 {
+		pp.SetDelta(config.delta)
+	}
+
+	correlation := stereoCorrelation(left, right, int(bufSize), int(hopSize))
+	novelty := NewFvec(1)
+	out := NewFvec(1)
+	last := math.Inf(-1)
+	for i := 1; i < len(correlation); i++ {
+		novelty.Data[0] = math.Abs(correlation[i] - correlation[i-1])
+		pp.Do(novelty, out)
+		if out.Data[0] <= 0 {
+			continue
+		}
+
+		// The peak picker reports the peak WinPre+2 frames late, as in
+		// detectSpectra
+		peakFrame := float64(i) - float64(pp.WinPre) - 2 + out.Data[0]
+		onsetTime := math.Max(0, peakFrame*float64(hopSize)/float64(sampleRate))
+		if onsetTime-last < config.minioiMs/1000.0 {
+			reportRejected(config.onReject, []float64{onsetTime}, "minioi")
+			continue
+		}
+		onsets = append(onsets, onsetTime)
+		strengths = append(strengths, pp.GetPeakValue())
+		last = onsetTime
+	}
+
+	return onsets, strengths
+}
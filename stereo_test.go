@@ -0,0 +1,94 @@
+package onset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMergeStereoOnsets(t *testing.T) {
+	// The right channel fires 4 ms late at 0.5s and 2 ms early at 1.0s; the
+	// onset at 1.5s is only in the left channel
+	left := []float64{0.5, 1.0, 1.5}
+	right := []float64{1.0 - 0.002, 0.5 + 0.004}
+
+	tests := []struct {
+		mergeTime string
+		expected  []float64
+	}{
+		{"average", []float64{0.502, 0.999}},
+		{"", []float64{0.502, 0.999}},
+		{"earliest", []float64{0.5, 0.998}},
+	}
+
+	for _, tt := range tests {
+		merged, err := MergeStereoOnsets(left, right, 0.01, tt.mergeTime)
+		if err != nil {
+			t.Fatalf("MergeStereoOnsets(%q) failed: %v", tt.mergeTime, err)
+		}
+		if len(merged) != len(tt.expected) {
+			t.Fatalf("%q: expected %v, got %v", tt.mergeTime, tt.expected, merged)
+		}
+		for i := range tt.expected {
+			if math.Abs(merged[i]-tt.expected[i]) > 1e-9 {
+				t.Errorf("%q: expected onset %d at %.4fs, got %.4fs", tt.mergeTime, i, tt.expected[i], merged[i])
+			}
+		}
+	}
+
+	// Onsets further apart than the tolerance are not confirmed
+	if merged, _ := MergeStereoOnsets(left, right, 0.001, "average"); len(merged) != 0 {
+		t.Errorf("Expected no confirmed onsets with a 1 ms tolerance, got %v", merged)
+	}
+
+	if _, err := MergeStereoOnsets(left, right, 0.01, "latest"); err == nil {
+		t.Error("Expected error for an unknown merge time, got nil")
+	}
+}
+
+func TestStereoWidthMethod(t *testing.T) {
+	// Steady noise in the center that jumps to hard left at 1.0s: the left
+	// channel does not change, only the stereo image does
+	sampleRate := uint(44100)
+	jump := 1.0
+	numFrames := 2 * int(sampleRate)
+	interleaved := make([]float64, 2*numFrames)
+	seed := uint32(7)
+	for i := 0; i < numFrames; i++ {
+		seed = seed*1664525 + 1013904223
+		v := 0.3 * (float64(seed)/float64(math.MaxUint32)*2 - 1)
+		interleaved[2*i] = v
+		if i < int(jump*float64(sampleRate)) {
+			interleaved[2*i+1] = v
+		}
+	}
+	path := t.TempDir() + "/pan.wav"
+	writeTestWav(t, path, interleaved, sampleRate, 2)
+
+	options := SliceAnalyzerOptions{Method: "hfc"}
+	mono, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	for _, onsetTime := range mono.Onsets {
+		if math.Abs(onsetTime-jump) < 0.05 {
+			t.Fatalf("Expected hfc on the left channel to miss the jump, got %v", mono.Onsets)
+		}
+	}
+
+	options.Method = "stereowidth"
+	result, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Onsets) != 1 || math.Abs(result.Onsets[0]-jump) > 0.006 {
+		t.Errorf("Expected one onset at the jump to hard left at %.1fs, got %v", jump, result.Onsets)
+	}
+	if result.Method != "stereowidth" {
+		t.Errorf("Expected method stereowidth, got %q", result.Method)
+	}
+
+	// Without the right channel the method cannot run
+	if _, err := AnalyzeSamples(interleaved[:numFrames], sampleRate, options); err == nil {
+		t.Error("Expected error for stereowidth on mono samples, got nil")
+	}
+}
@@ -0,0 +1,338 @@
+package onset
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// minTempoBPM is the lower bound of the tempo range estimates are folded into.
+	// The upper bound is twice this value.
+	minTempoBPM = 80.0
+	// tempoTolerance is the relative tolerance for two tempo candidates to agree
+	tempoTolerance = 0.03
+	// minIOISec is the shortest inter-onset interval considered for tempo (flams are ignored)
+	minIOISec = 0.05
+	// periodBinSec is the width of the inter-onset interval histogram bins used
+	// to find the dominant period
+	periodBinSec = 0.01
+	// minPeriodSupport is the fraction of inter-onset intervals that must agree
+	// with the dominant period for onsets to be treated as periodic, and
+	// minPeriodCount the number of intervals
+	minPeriodSupport = 0.3
+	minPeriodCount   = 3
+	// periodicToleranceSec is how far an onset may lie from one period after or
+	// before another onset to be part of the periodic pulse
+	periodicToleranceSec = 0.015
+)
+
+// TempoPoint is a local tempo estimate
+type TempoPoint struct {
+	// Time is the center of the analysis window in seconds
+	Time float64
+	// BPM is the local tempo in beats per minute
+	BPM float64
+}
+
+// EstimateBPM estimates the tempo of the onsets from their inter-onset intervals.
+// Every interval is converted to a tempo and folded by octaves into the
+// 80-160 BPM range, and the tempo most intervals agree on is returned.
+// It returns 0 when there are fewer than two onsets.
+func EstimateBPM(onsets []float64) float64 {
+	bpm, _ := estimateBPMWithConfidence(onsets)
+	return bpm
+}
+
+// estimateBPMWithConfidence estimates the tempo of the onsets and returns the
+// fraction of inter-onset intervals that agree with it (0 to 1)
+func estimateBPMWithConfidence(onsets []float64) (float64, float64) {
+	sorted := make([]float64, len(onsets))
+	copy(sorted, onsets)
+	sort.Float64s(sorted)
+
+	var iois []float64
+	for i := 1; i < len(sorted); i++ {
+		iois = append(iois, sorted[i]-sorted[i-1])
+	}
+
+	return estimateBPMFromIOIs(iois)
+}
+
+// estimateBPMFromIOIs folds the tempo of every inter-onset interval into the
+// tempo range and returns the mean of the largest group of agreeing tempos
+// along with the fraction of intervals in that group
+func estimateBPMFromIOIs(iois []float64) (float64, float64) {
+	var tempos []float64
+	for _, ioi := range iois {
+		if ioi < minIOISec {
+			continue
+		}
+		tempos = append(tempos, foldTempo(60.0/ioi))
+	}
+
+	if len(tempos) == 0 {
+		return 0, 0
+	}
+
+	// Find the tempo with the most agreeing tempos
+	bestCount := 0
+	bestTempo := tempos[0]
+	for _, candidate := range tempos {
+		count := 0
+		for _, tempo := range tempos {
+			if tempoAgrees(candidate, tempo) {
+				count++
+			}
+		}
+		if count > bestCount {
+			bestCount = count
+			bestTempo = candidate
+		}
+	}
+
+	// Average the agreeing tempos for a more precise estimate
+	sum := 0.0
+	count := 0
+	for _, tempo := range tempos {
+		if tempoAgrees(bestTempo, tempo) {
+			sum += tempo
+			count++
+		}
+	}
+
+	return sum / float64(count), float64(count) / float64(len(tempos))
+}
+
+// foldTempo doubles or halves a tempo until it lies in the tempo range
+func foldTempo(bpm float64) float64 {
+	for bpm < minTempoBPM {
+		bpm *= 2
+	}
+	for bpm >= 2*minTempoBPM {
+		bpm /= 2
+	}
+	return bpm
+}
+
+// tempoAgrees reports whether two folded tempos are within the tolerance,
+// taking into account that the ends of the tempo range are an octave apart
+func tempoAgrees(a, b float64) bool {
+	ratio := math.Max(a, b) / math.Min(a, b)
+	return ratio <= 1+tempoTolerance || math.Abs(ratio-2) <= 2*tempoTolerance
+}
+
+// EstimateTempoCurve estimates the local tempo of the onsets in windows of
+// windowSec seconds that slide by half a window, for tempo-varying material.
+// Each window needs at least three onsets; windows with fewer are skipped.
+func EstimateTempoCurve(onsets []float64, windowSec float64) []TempoPoint {
+	curve := []TempoPoint{}
+	if len(onsets) < 3 || windowSec <= 0 {
+		return curve
+	}
+
+	sorted := make([]float64, len(onsets))
+	copy(sorted, onsets)
+	sort.Float64s(sorted)
+
+	last := sorted[len(sorted)-1]
+	step := windowSec / 2
+	for start := sorted[0]; start < last; start += step {
+		end := start + windowSec
+
+		var iois []float64
+		for i := 1; i < len(sorted); i++ {
+			if sorted[i-1] >= start && sorted[i] < end {
+				iois = append(iois, sorted[i]-sorted[i-1])
+			}
+		}
+
+		if len(iois) < 2 {
+			continue
+		}
+
+		bpm, _ := estimateBPMFromIOIs(iois)
+		if bpm > 0 {
+			curve = append(curve, TempoPoint{Time: start + windowSec/2, BPM: bpm})
+		}
+
+		if end >= last {
+			break
+		}
+	}
+
+	return curve
+}
+
+// dominantPeriod returns the most common inter-onset interval of the sorted
+// onsets from a histogram of the intervals, refined to the mean of the
+// intervals in the peak bin and its neighbors, along with the number of
+// intervals in them and the number of intervals. It returns 0 when there are
+// no usable intervals.
+func dominantPeriod(sorted []float64) (float64, int, int) {
+	histogram := make(map[int]int)
+	var iois []float64
+	for i := 1; i < len(sorted); i++ {
+		ioi := sorted[i] - sorted[i-1]
+		if ioi < minIOISec {
+			continue
+		}
+		iois = append(iois, ioi)
+		histogram[int(ioi/periodBinSec)]++
+	}
+	if len(iois) == 0 {
+		return 0, 0, 0
+	}
+
+	// The bin with the most intervals, counting its neighbors so a period on
+	// a bin edge is not split; ties go to the shorter period
+	bestBin, bestCount := 0, 0
+	for bin := range histogram {
+		count := histogram[bin-1] + histogram[bin] + histogram[bin+1]
+		if count > bestCount || (count == bestCount && bin < bestBin) {
+			bestBin, bestCount = bin, count
+		}
+	}
+
+	sum := 0.0
+	count := 0
+	for _, ioi := range iois {
+		if bin := int(ioi / periodBinSec); bin >= bestBin-1 && bin <= bestBin+1 {
+			sum += ioi
+			count++
+		}
+	}
+
+	return sum / float64(count), count, len(iois)
+}
+
+// suppressPeriodicOnsets removes the onsets of the dominant periodic pulse,
+// e.g. a steady hi-hat, to surface the non-repetitive hits. The period is the
+// most common inter-onset interval, and an onset is part of the pulse when
+// another onset lies one period before or after it. The onsets are returned
+// unchanged when there are fewer than four or no period is common enough
+// (at least three intervals and 30% of them).
+// It returns the kept onsets and the removed ones.
+func suppressPeriodicOnsets(onsets []float64) ([]float64, []float64) {
+	if len(onsets) < 4 {
+		return onsets, nil
+	}
+
+	sorted := make([]float64, len(onsets))
+	copy(sorted, onsets)
+	sort.Float64s(sorted)
+
+	period, count, total := dominantPeriod(sorted)
+	if count < minPeriodCount || float64(count) < minPeriodSupport*float64(total) {
+		return onsets, nil
+	}
+
+	var kept, removed []float64
+	for _, onset := range onsets {
+		if hasNeighborNear(sorted, onset-period) || hasNeighborNear(sorted, onset+period) {
+			removed = append(removed, onset)
+		} else {
+			kept = append(kept, onset)
+		}
+	}
+
+	return kept, removed
+}
+
+// hasNeighborNear reports whether a sorted, non-empty array has a value
+// within periodicToleranceSec of x
+func hasNeighborNear(sorted []float64, x float64) bool {
+	return math.Abs(nearestValue(sorted, x)-x) <= periodicToleranceSec
+}
+
+// beatSmoothingSec is the half-width of the triangle each onset is spread over
+// in the onset train used for beat tracking, to tolerate timing jitter
+const beatSmoothingSec = 0.02
+
+// TrackBeats phase-locks a beat grid to the onsets and returns its tempo and
+// the beat times in seconds from the start of the file up to the last onset.
+// The onsets are rendered as a smoothed pulse train at the detection frame
+// rate for sampleRate, the beat period is the autocorrelation peak of the
+// train within the 80-160 BPM range, and the grid phase is the offset whose
+// beats land on the most onset energy. It returns 0 and no beats when there
+// are fewer than two onsets or the sample rate is 0.
+func TrackBeats(onsets []float64, sampleRate uint) (bpm float64, beats []float64) {
+	beats = []float64{}
+	if len(onsets) < 2 || sampleRate == 0 {
+		return 0, beats
+	}
+
+	_, hopSize := detectorConfig{}.sizes(sampleRate)
+	frameRate := float64(sampleRate) / float64(hopSize)
+
+	last := 0.0
+	for _, onset := range onsets {
+		last = math.Max(last, onset)
+	}
+
+	// Onset train with every onset spread over a small triangle
+	train := make([]float64, int(last*frameRate)+2)
+	spread := max(int(beatSmoothingSec*frameRate), 1)
+	for _, onset := range onsets {
+		if onset < 0 {
+			continue
+		}
+		center := int(math.Round(onset * frameRate))
+		for k := -spread; k <= spread; k++ {
+			if i := center + k; i >= 0 && i < len(train) {
+				train[i] += 1 - math.Abs(float64(k))/float64(spread+1)
+			}
+		}
+	}
+
+	// Autocorrelation over the lags of the tempo range, plus one on either
+	// side for the interpolation. At very low frame rates the shortest lag
+	// rounds down, and is kept at 1 frame so the lag before it exists.
+	minLag := max(int(math.Floor(60.0/(2*minTempoBPM)*frameRate)), 1)
+	maxLag := int(math.Ceil(60.0 / minTempoBPM * frameRate))
+	if maxLag+1 >= len(train) {
+		return 0, beats
+	}
+	acf := make([]float64, maxLag+2)
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		for i := lag; i < len(train); i++ {
+			acf[lag] += train[i] * train[i-lag]
+		}
+	}
+
+	bestLag := minLag
+	for lag := minLag; lag <= maxLag; lag++ {
+		if acf[lag] > acf[bestLag] {
+			bestLag = lag
+		}
+	}
+	if acf[bestLag] <= 0 {
+		return 0, beats
+	}
+
+	// Refine the period between frames with a parabola through the peak, by
+	// at most half a frame. Lag 0, the energy of the train rather than a
+	// period, is never used.
+	period := float64(bestLag)
+	if bestLag-1 >= 1 && bestLag+1 < len(acf) {
+		period += parabolicPeakOffset(acf[bestLag-1], acf[bestLag], acf[bestLag+1])
+	}
+
+	// The phase whose beats collect the most of the onset train
+	bestPhase, bestScore := 0, -1.0
+	for phase := 0; phase < bestLag; phase++ {
+		score := 0.0
+		for pos := float64(phase); int(math.Round(pos)) < len(train); pos += period {
+			score += train[int(math.Round(pos))]
+		}
+		if score > bestScore {
+			bestPhase, bestScore = phase, score
+		}
+	}
+
+	periodSec := period / frameRate
+	for beat := float64(bestPhase) / frameRate; beat <= last; beat += periodSec {
+		beats = append(beats, beat)
+	}
+
+	return 60.0 / periodSec, beats
+}
@@ -0,0 +1,165 @@
+package onset
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestEstimateBPM(t *testing.T) {
+	// Eighth notes at 120 BPM with a few ghost notes and a flam
+	var onsets []float64
+	for i := 0; i < 32; i++ {
+		onsets = append(onsets, float64(i)*0.25)
+	}
+	onsets = append(onsets, 1.125, 3.625, 5.01)
+
+	bpm := EstimateBPM(onsets)
+	if math.Abs(bpm-120) > 1 {
+		t.Errorf("Expected ~120 BPM, got %f", bpm)
+	}
+
+	if EstimateBPM([]float64{1.0}) != 0 {
+		t.Error("Expected 0 BPM for a single onset")
+	}
+}
+
+func TestEstimateTempoCurve(t *testing.T) {
+	// A synthetic accelerando from 90 to 140 BPM
+	var onsets []float64
+	onsetTime := 0.0
+	for i := 0; i < 64; i++ {
+		onsets = append(onsets, onsetTime)
+		bpm := 90.0 + 50.0*float64(i)/63.0
+		onsetTime += 60.0 / bpm
+	}
+
+	curve := EstimateTempoCurve(onsets, 4.0)
+	if len(curve) < 5 {
+		t.Fatalf("Expected several tempo points, got %d", len(curve))
+	}
+
+	for i := 1; i < len(curve); i++ {
+		if curve[i].Time <= curve[i-1].Time {
+			t.Errorf("Tempo points not in chronological order at index %d", i)
+		}
+		if curve[i].BPM <= curve[i-1].BPM {
+			t.Errorf("Expected tempo to increase at index %d: %f <= %f", i, curve[i].BPM, curve[i-1].BPM)
+		}
+	}
+
+	if curve[0].BPM > 100 || curve[len(curve)-1].BPM < 125 {
+		t.Errorf("Expected tempo curve from ~90 to ~140 BPM, got %f to %f", curve[0].BPM, curve[len(curve)-1].BPM)
+	}
+}
+
+func TestSuppressPeriodicOnsets(t *testing.T) {
+	// Without a common interval nothing is removed
+	irregular := []float64{0.1, 0.43, 0.91, 1.12, 1.83, 2.31, 3.15}
+	kept, removed := suppressPeriodicOnsets(irregular)
+	if len(kept) != len(irregular) || len(removed) != 0 {
+		t.Errorf("Expected irregular onsets unchanged, got %v kept and %v removed", kept, removed)
+	}
+
+	// A slightly jittered pulse is removed, the off-grid hits are kept
+	var onsets []float64
+	for k := 0; k < 12; k++ {
+		onsets = append(onsets, 0.5*float64(k)+0.004*float64(k%3))
+	}
+	onsets = append(onsets, 1.2, 3.3)
+	kept, removed = suppressPeriodicOnsets(onsets)
+	if len(kept) != 2 || kept[0] != 1.2 || kept[1] != 3.3 {
+		t.Errorf("Expected the off-grid hits [1.2 3.3] to be kept, got %v", kept)
+	}
+	if len(removed) != 12 {
+		t.Errorf("Expected the 12 pulse onsets to be removed, got %d", len(removed))
+	}
+}
+
+func TestTrackBeats(t *testing.T) {
+	// Quarter notes at 120 BPM from 0.1s with eighth-note offbeats and a
+	// missing beat, so the grid must be inferred between onsets
+	var onsets []float64
+	for i := 0; i < 16; i++ {
+		if i == 7 {
+			continue
+		}
+		onsets = append(onsets, 0.1+float64(i)*0.5)
+		if i%2 == 1 {
+			onsets = append(onsets, 0.35+float64(i)*0.5)
+		}
+	}
+
+	bpm, beats := TrackBeats(onsets, 44100)
+	if math.Abs(bpm-120) > 1 {
+		t.Errorf("Expected ~120 BPM, got %f", bpm)
+	}
+	if len(beats) != 16 {
+		t.Fatalf("Expected 16 beats, got %d: %v", len(beats), beats)
+	}
+	for i, beat := range beats {
+		expected := 0.1 + float64(i)*0.5
+		if math.Abs(beat-expected) > 0.02 {
+			t.Errorf("Beat %d: expected %.3fs, got %.3fs", i, expected, beat)
+		}
+	}
+
+	t.Run("Amen", func(t *testing.T) {
+		result, err := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{Method: "hfc"})
+		if err != nil {
+			t.Skipf("amen.wav not available: %v", err)
+		}
+
+		bpm, beats := TrackBeats(result.Onsets, 44100)
+		t.Logf("%.2f BPM, %d beats", bpm, len(beats))
+		if bpm < minTempoBPM || bpm >= 2*minTempoBPM {
+			t.Fatalf("Expected a tempo in the 80-160 BPM range, got %f", bpm)
+		}
+		if len(beats) < 4 {
+			t.Fatalf("Expected several beats, got %v", beats)
+		}
+
+		period := 60.0 / bpm
+		for i := 1; i < len(beats); i++ {
+			if math.Abs(beats[i]-beats[i-1]-period) > 0.001 {
+				t.Errorf("Beats %d and %d are %.4fs apart, expected %.4fs", i-1, i, beats[i]-beats[i-1], period)
+			}
+		}
+
+		// Most beats should fall near an onset
+		sorted := append([]float64(nil), result.Onsets...)
+		sort.Float64s(sorted)
+		near := 0
+		for _, beat := range beats {
+			if math.Abs(nearestValue(sorted, beat)-beat) <= 0.05 {
+				near++
+			}
+		}
+		if near*2 < len(beats) {
+			t.Errorf("Expected most beats near an onset, got %d of %d", near, len(beats))
+		}
+	})
+
+	if bpm, beats := TrackBeats([]float64{1.0}, 44100); bpm != 0 || len(beats) != 0 {
+		t.Errorf("Expected no beats for a single onset, got %f BPM and %v", bpm, beats)
+	}
+
+	t.Run("low sample rates", func(t *testing.T) {
+		onsets := make([]float64, 40)
+		for i := range onsets {
+			onsets[i] = 0.5 * float64(i)
+		}
+		for _, sampleRate := range []uint{1, 2, 3, 4, 8} {
+			bpm, beats := TrackBeats(onsets, sampleRate)
+			if math.IsNaN(bpm) || math.IsInf(bpm, 0) || bpm < 0 {
+				t.Errorf("Sample rate %d: expected a finite BPM, got %f", sampleRate, bpm)
+			}
+			for i, beat := range beats {
+				if (i > 0 && beat <= beats[i-1]) || beat > onsets[len(onsets)-1] {
+					t.Errorf("Sample rate %d: beat %d at %f out of order or past the last onset", sampleRate, i, beat)
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package onset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+var (
+	// ErrNotWAV is returned when a file is not a RIFF/WAVE file
+	ErrNotWAV = errors.New("not a WAV file")
+	// ErrUnreadableWAV is returned when a file cannot be opened or is a WAV file
+	// whose header or format cannot be read
+	ErrUnreadableWAV = errors.New("unreadable WAV file")
+)
+
+// WavInfo contains the header information of a WAV file
+type WavInfo struct {
+	// SampleRate is the sample rate of the audio file
+	SampleRate uint
+	// NumChannels is the number of interleaved channels
+	NumChannels int
+	// BitDepth is the number of bits per sample
+	BitDepth int
+	// NumFrames is the number of sample frames declared by the data chunk
+	NumFrames int
+	// Duration is the declared duration in seconds
+	Duration float64
+}
+
+// ProbeWav reads the header and chunk layout of a WAV file without decoding
+// the PCM data, so it is cheap even for very large files and can be used to
+// validate or display a file before analyzing it. Errors wrap ErrNotWAV when
+// the file is not a WAV file and ErrUnreadableWAV when it cannot be read.
+func ProbeWav(path string) (WavInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return WavInfo{}, fmt.Errorf("%w: failed to open file: %w", ErrUnreadableWAV, err)
+	}
+	defer f.Close()
+
+	if err := checkRIFFWave(f); err != nil {
+		return WavInfo{}, err
+	}
+
+	return probeDecoder(wav.NewDecoder(f))
+}
+
+// checkRIFFWave checks that the file starts with a RIFF/WAVE header and rewinds it
+func checkRIFFWave(f *os.File) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrNotWAV
+		}
+		return fmt.Errorf("%w: %w", ErrUnreadableWAV, err)
+	}
+
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return ErrNotWAV
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnreadableWAV, err)
+	}
+	return nil
+}
+
+// probeDecoder validates the decoder and forwards it to the start of the PCM
+// data, returning the header information. The decoder is left ready for reading.
+func probeDecoder(decoder *wav.Decoder) (WavInfo, error) {
+	if !decoder.IsValidFile() {
+		return WavInfo{}, fmt.Errorf("%w: invalid WAV header", ErrUnreadableWAV)
+	}
+
+	if err := decoder.FwdToPCM(); err != nil {
+		return WavInfo{}, fmt.Errorf("%w: failed to find PCM data: %w", ErrUnreadableWAV, err)
+	}
+
+	info := WavInfo{
+		SampleRate:  uint(decoder.SampleRate),
+		NumChannels: int(decoder.NumChans),
+		BitDepth:    int(decoder.BitDepth),
+	}
+
+	switch info.BitDepth {
+	case 8, 16, 24, 32:
+	default:
+		return WavInfo{}, fmt.Errorf("%w: unsupported bit depth: %d", ErrUnreadableWAV, info.BitDepth)
+	}
+
+	bytesPerFrame := info.NumChannels * ((info.BitDepth-1)/8 + 1)
+	info.NumFrames = decoder.PCMSize / bytesPerFrame
+	if info.SampleRate > 0 {
+		info.Duration = float64(info.NumFrames) / float64(info.SampleRate)
+	}
+
+	return info, nil
+}
+
+// wavBlockFrames is the number of sample frames decoded per block when
+// streaming a WAV file
+const wavBlockFrames = 4096
+
+// wavBlockReader decodes the left channel (or mono) of a WAV file in
+// fixed-size blocks so the whole PCM buffer never has to be held in memory
+type wavBlockReader struct {
+	file    *os.File
+	decoder *wav.Decoder
+	info    WavInfo
+	buf     *audio.IntBuffer
+	carry   []int
+	block   []float64
+	// frames is the number of frames decoded so far
+	frames int
+	// maxFrames stops the decoding after this many frames (0 = no limit)
+	maxFrames int
+	// interleaved collects every channel of the decoded frames when
+	// keepInterleaved is set
+	keepInterleaved bool
+	interleaved     []float64
+}
+
+// openWavBlockReader opens a WAV file and positions it at the start of the PCM data
+func openWavBlockReader(filename string) (*wavBlockReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open file: %w", ErrUnreadableWAV, err)
+	}
+
+	if err := checkRIFFWave(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	decoder := wav.NewDecoder(f)
+	info, err := probeDecoder(decoder)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &wavBlockReader{
+		file:    f,
+		decoder: decoder,
+		info:    info,
+		buf:     &audio.IntBuffer{Data: make([]int, wavBlockFrames*info.NumChannels)},
+		carry:   make([]int, 0, info.NumChannels),
+		block:   make([]float64, 0, wavBlockFrames+1),
+	}, nil
+}
+
+// limit stops the decoding after the first previewSec seconds (0 = no limit)
+func (r *wavBlockReader) limit(previewSec float64) {
+	if previewSec > 0 {
+		r.maxFrames = max(int(previewSec*float64(r.info.SampleRate)), 1)
+	}
+}
+
+// expectedFrames returns the number of frames the reader should decode: those
+// declared by the header, up to the limit
+func (r *wavBlockReader) expectedFrames() int {
+	if r.maxFrames > 0 {
+		return min(r.info.NumFrames, r.maxFrames)
+	}
+	return r.info.NumFrames
+}
+
+// Next decodes the next block of samples. It returns an empty block at the
+// end of the data, or once the limit is reached. The returned slice is reused
+// by the following call.
+func (r *wavBlockReader) Next() ([]float64, error) {
+	numChannels := r.info.NumChannels
+	r.block = r.block[:0]
+	if r.maxFrames > 0 && r.frames >= r.maxFrames {
+		return r.block, nil
+	}
+
+	for len(r.block) == 0 {
+		n, err := r.decoder.PCMBuffer(r.buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PCM data: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		data := r.buf.Data[:n]
+		if r.keepInterleaved {
+			for _, v := range data {
+				r.interleaved = append(r.interleaved, float64(v)/32768.0)
+			}
+		}
+
+		// Complete a frame left over from the previous block
+		if len(r.carry) > 0 {
+			needed := numChannels - len(r.carry)
+			if needed > len(data) {
+				needed = len(data)
+			}
+			r.carry = append(r.carry, data[:needed]...)
+			data = data[needed:]
+			if len(r.carry) == numChannels {
+				r.block = append(r.block, float64(r.carry[0])/32768.0)
+				r.carry = r.carry[:0]
+			}
+		}
+
+		numFrames := len(data) / numChannels
+		for i := 0; i < numFrames; i++ {
+			// Normalize int to float64 [-1.0, 1.0]
+			r.block = append(r.block, float64(data[i*numChannels])/32768.0)
+		}
+		r.carry = append(r.carry, data[numFrames*numChannels:]...)
+	}
+
+	if r.maxFrames > 0 {
+		r.block = r.block[:min(len(r.block), r.maxFrames-r.frames)]
+	}
+	r.frames += len(r.block)
+	return r.block, nil
+}
+
+// Interleaved returns every channel of the frames decoded so far, interleaved
+// and in the same scale as the blocks. It is only collected when
+// keepInterleaved is set.
+func (r *wavBlockReader) Interleaved() []float64 {
+	// Drop a trailing partial frame and the frames past the limit
+	return r.interleaved[:min(len(r.interleaved), r.frames*r.info.NumChannels)]
+}
+
+// checkLength compares the number of decoded frames against the length declared
+// by the data chunk header, or the limit when it is shorter. A mismatch usually
+// means the file was truncated.
+// It is reported as an error when strict is set and as a warning otherwise.
+func (r *wavBlockReader) checkLength(strict bool) (SliceAnalyzerStats, error) {
+	stats := SliceAnalyzerStats{DeclaredDuration: r.info.Duration}
+	if r.info.SampleRate > 0 {
+		stats.DecodedDuration = float64(r.frames) / float64(r.info.SampleRate)
+	}
+
+	if r.frames != r.expectedFrames() {
+		msg := fmt.Sprintf("header declares %d frames (%.3fs) but %d frames (%.3fs) were decoded",
+			r.info.NumFrames, stats.DeclaredDuration, r.frames, stats.DecodedDuration)
+		if strict {
+			return stats, fmt.Errorf("length mismatch: %s", msg)
+		}
+		stats.Warnings = append(stats.Warnings, msg)
+	}
+
+	return stats, nil
+}
+
+// Close closes the underlying file
+func (r *wavBlockReader) Close() error {
+	return r.file.Close()
+}
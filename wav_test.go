@@ -0,0 +1,233 @@
+package onset
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+func TestProbeWav(t *testing.T) {
+	info, err := ProbeWav("amen.wav")
+	if err != nil {
+		t.Fatalf("ProbeWav failed: %v", err)
+	}
+
+	if info.SampleRate != 44100 {
+		t.Errorf("Expected sample rate 44100, got %d", info.SampleRate)
+	}
+
+	if info.NumChannels != 2 {
+		t.Errorf("Expected 2 channels, got %d", info.NumChannels)
+	}
+
+	if info.BitDepth != 24 {
+		t.Errorf("Expected bit depth 24, got %d", info.BitDepth)
+	}
+
+	// The declared length should match what a full decode produces
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+
+	if info.NumFrames != len(samples) {
+		t.Errorf("Expected %d frames, got %d", len(samples), info.NumFrames)
+	}
+
+	expectedDuration := float64(len(samples)) / float64(sampleRate)
+	if math.Abs(info.Duration-expectedDuration) > 1e-9 {
+		t.Errorf("Expected duration %f, got %f", expectedDuration, info.Duration)
+	}
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		if _, err := ProbeWav("nonexistent.wav"); !errors.Is(err, ErrUnreadableWAV) {
+			t.Errorf("Expected ErrUnreadableWAV for non-existent file, got %v", err)
+		}
+
+		dir := t.TempDir()
+		notWav := filepath.Join(dir, "notes.txt")
+		if err := os.WriteFile(notWav, []byte("these are not the samples you are looking for"), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if _, err := ProbeWav(notWav); !errors.Is(err, ErrNotWAV) {
+			t.Errorf("Expected ErrNotWAV for a text file, got %v", err)
+		}
+
+		// A RIFF/WAVE header whose chunks are cut off is a WAV file that cannot be read
+		broken := filepath.Join(dir, "broken.wav")
+		if err := os.WriteFile(broken, []byte("RIFF\x24\x00\x00\x00WAVEfmt "), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if _, err := ProbeWav(broken); !errors.Is(err, ErrUnreadableWAV) {
+			t.Errorf("Expected ErrUnreadableWAV for a broken header, got %v", err)
+		}
+
+		// Analysis reports the same errors
+		if _, err := AnalyzeSlices(notWav, DefaultSliceAnalyzerOptions()); !errors.Is(err, ErrNotWAV) {
+			t.Errorf("Expected AnalyzeSlices to return ErrNotWAV, got %v", err)
+		}
+	})
+}
+
+func TestStreamingDetectorBlockSizes(t *testing.T) {
+	samples, sampleRate, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+
+	config := relaxedDetector("hfc", SliceAnalyzerOptions{})
+	expected := detectOnsetsInternal(samples, sampleRate, config)
+
+	// Feeding odd block sizes must give the same onsets as one large write
+	d := newStreamingDetector(sampleRate, config)
+	for pos := 0; pos < len(samples); pos += 1000 {
+		end := pos + 1000
+		if end > len(samples) {
+			end = len(samples)
+		}
+		d.write(samples[pos:end])
+	}
+	d.flush()
+
+	if len(d.onsets) != len(expected) {
+		t.Fatalf("Expected %d onsets, got %d", len(expected), len(d.onsets))
+	}
+
+	for i := range expected {
+		if d.onsets[i] != expected[i] {
+			t.Errorf("Onset %d differs: got %f, expected %f", i, d.onsets[i], expected[i])
+		}
+	}
+}
+
+func TestReadWavFileLeftChannelMatchesFullDecode(t *testing.T) {
+	f, err := os.Open("amen.wav")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	buf, err := wav.NewDecoder(f).FullPCMBuffer()
+	if err != nil {
+		t.Fatalf("FullPCMBuffer failed: %v", err)
+	}
+
+	samples, _, err := readWavFileLeftChannel("amen.wav")
+	if err != nil {
+		t.Fatalf("readWavFileLeftChannel failed: %v", err)
+	}
+
+	numChannels := buf.Format.NumChannels
+	if len(samples) != len(buf.Data)/numChannels {
+		t.Fatalf("Expected %d samples, got %d", len(buf.Data)/numChannels, len(samples))
+	}
+
+	for i := range samples {
+		expected := float64(buf.Data[i*numChannels]) / 32768.0
+		if samples[i] != expected {
+			t.Fatalf("Sample %d differs: got %f, expected %f", i, samples[i], expected)
+		}
+	}
+}
+
+// writeTestWav writes interleaved samples in [-1, 1] to a 16-bit WAV file
+func writeTestWav(t *testing.T, path string, samples []float64, sampleRate uint, numChannels int) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer f.Close()
+
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: numChannels, SampleRate: int(sampleRate)},
+		Data:           make([]int, len(samples)),
+		SourceBitDepth: 16,
+	}
+	for i, sample := range samples {
+		buf.Data[i] = int(math.Max(-1, math.Min(1, sample)) * 32767)
+	}
+
+	encoder := wav.NewEncoder(f, int(sampleRate), 16, numChannels, 1)
+	if err := encoder.Write(buf); err != nil {
+		t.Fatalf("failed to write WAV data: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("failed to close WAV encoder: %v", err)
+	}
+}
+
+// clickTrack returns a mono signal of decaying noise bursts at the given times
+func clickTrack(sampleRate uint, durationSec float64, times []float64, amplitude float64) []float64 {
+	samples := make([]float64, int(durationSec*float64(sampleRate)))
+	burst := int(0.03 * float64(sampleRate))
+	seed := uint32(1)
+	for _, onsetTime := range times {
+		start := int(onsetTime * float64(sampleRate))
+		for i := 0; i < burst && start+i < len(samples); i++ {
+			// Simple deterministic pseudo-random noise
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(math.MaxUint32)*2 - 1
+			samples[start+i] += amplitude * noise * math.Exp(-float64(i)/float64(burst)*5)
+		}
+	}
+	return samples
+}
+
+func TestTruncatedWavLength(t *testing.T) {
+	path := t.TempDir() + "/truncated.wav"
+	samples := clickTrack(44100, 1.0, []float64{0.1, 0.3, 0.7}, 0.8)
+	writeTestWav(t, path, samples, 44100, 1)
+
+	// Cut off the second half of the data so the header overstates its length
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if err := os.Truncate(path, fi.Size()-int64(len(samples))); err != nil {
+		t.Fatalf("failed to truncate file: %v", err)
+	}
+
+	for _, dropSamples := range []bool{false, true} {
+		options := DefaultSliceAnalyzerOptions()
+		options.Optimize = false
+		options.DropSamples = dropSamples
+
+		result, err := AnalyzeSlices(path, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSlices failed (DropSamples=%v): %v", dropSamples, err)
+		}
+		if len(result.Stats.Warnings) != 1 {
+			t.Fatalf("Expected 1 warning (DropSamples=%v), got %v", dropSamples, result.Stats.Warnings)
+		}
+		if math.Abs(result.Stats.DeclaredDuration-1.0) > 1e-6 {
+			t.Errorf("Expected declared duration 1.0s, got %f", result.Stats.DeclaredDuration)
+		}
+		if math.Abs(result.Stats.DecodedDuration-0.5) > 1e-3 {
+			t.Errorf("Expected decoded duration 0.5s, got %f", result.Stats.DecodedDuration)
+		}
+
+		options.StrictLength = true
+		if _, err := AnalyzeSlices(path, options); err == nil {
+			t.Errorf("Expected error with StrictLength (DropSamples=%v)", dropSamples)
+		}
+	}
+
+	// An intact file has no warnings
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	options.StrictLength = true
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Stats.Warnings) != 0 {
+		t.Errorf("Expected no warnings for amen.wav, got %v", result.Stats.Warnings)
+	}
+}